@@ -37,6 +37,8 @@ import (
 	"os"
 	"os/user"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"net/http"
@@ -46,6 +48,8 @@ import (
 	"github.com/ghostdb/ghostdb-cache-node/server"
 	"github.com/ghostdb/ghostdb-cache-node/store/base"
 	"github.com/ghostdb/ghostdb-cache-node/store/persistence"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
 	"github.com/ghostdb/ghostdb-cache-node/store/monitor"
 	"github.com/ghostdb/ghostdb-cache-node/config"
 	"github.com/ghostdb/ghostdb-cache-node/system_monitor"
@@ -56,29 +60,51 @@ const (
 	DefaultHTTPAddr = ":7991"
 	retainSnapshotCount = 2
 	raftTimeout = 10 * time.Second
+
+	// A node that fails to join the cluster tries again, waiting
+	// twice as long after each failure, up to joinMaxBackoff.
+	joinMinBackoff = 500 * time.Millisecond
+	joinMaxBackoff = 30 * time.Second
+	probeTimeout   = 2 * time.Second
 )
 
 var (
-	httpAddr string
-	raftAddr string
-	joinAddr string
-	nodeID   string
+	httpAddr  string
+	httpAdv   string
+	raftAddr  string
+	groupPort int
+	joinAddr  string
+	nodeID    string
+	policy    string
+	groups    string
+	learner   bool
+	peers     string
+	respAddr  string
+	mcAddr    string
 )
 
 // Node configuration file
 var conf config.Configuration
 
-// Main cache object
-var store *base.Store
+// Main cache object, holding the store of each Raft group
+var node *shard.Node
 
 // Schedulers
 var sysMetricsScheduler *system_monitor.SysMetricsScheduler
 
 func init() {
 	flag.StringVar(&httpAddr, "http", DefaultHTTPAddr, "Set HTTP bind address")
+	flag.StringVar(&httpAdv, "http-adv", "", "Set advertised HTTP address other nodes forward requests to, if not set the HTTP bind address")
+	flag.StringVar(&respAddr, "resp", "", "Set RESP bind address Redis clients connect to, if not set no RESP listener")
+	flag.StringVar(&mcAddr, "memcache", "", "Set memcached bind address memcached clients connect to, if not set no memcached listener")
 	flag.StringVar(&raftAddr, "raft", DefaultRaftAddr, "Set Raft bind address")
+	flag.IntVar(&groupPort, "group-port", 0, "Set the Raft port of data group 1 in a sharded cluster, each next group using the next port, if not set the ports above the Raft bind address")
 	flag.StringVar(&joinAddr, "join", "", "Set join address, if any")
 	flag.StringVar(&nodeID, "id", "", "Node ID")
+	flag.StringVar(&peers, "peers", "", "Set the ID and HTTP address of every node of the cluster, as a comma separated list of id=host:port, overrides the config file")
+	flag.StringVar(&groups, "groups", "", "Set the data groups this node hosts in a sharded cluster, as a comma separated list, if not set every group")
+	flag.BoolVar(&learner, "learner", false, "Join as a learner, which serves stale reads but does not vote, until promoted")
+	flag.StringVar(&policy, "policy", "", "Set cache eviction policy (LRU, LFU, MRU, ARC, TLRU or WTINYLFU), overrides the config file")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] <raft-data-path> \n", os.Args[0])
 		flag.PrintDefaults()
@@ -127,17 +153,79 @@ func main() {
 	}
 	os.MkdirAll(raftDir, 0700)
 
+	// The command line takes precedence over the config file.
+	if policy != "" {
+		conf.Policy = policy
+	}
+	conf.Policy = strings.ToUpper(conf.Policy)
+	if err := base.ValidatePolicy(conf.Policy); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %s\n", err.Error())
+		os.Exit(1)
+	}
+	// The standby cluster only accepts writes from nodes that
+	// present a certificate signed by a CA it trusts.
+	if len(conf.ReplicationTargets) > 0 && !conf.RaftTLSEnabled() {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: replicating to a standby cluster requires raft TLS\n")
+		os.Exit(1)
+	}
+
 	go system_monitor.StartSysMetrics(sysMetricsScheduler)
 	log.Println("successfully started sysMetrics monitor...")
 
-	store = base.NewStore("LRU") // FUTURE: Read store type from config
-	store.BuildStore(conf)
+	// The node either bootstraps the cluster or joins it through
+	// joinAddrs, which are given by -join or else the peer list.
+	bootstrap := joinAddr == ""
+	var joinAddrs []string
+	if joinAddr != "" {
+		joinAddrs = []string{joinAddr}
+	} else {
+		peerList := conf.Peers
+		if peers != "" {
+			peerList = strings.Split(peers, ",")
+		}
+		seeds, err := config.ParsePeers(peerList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid peers: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if len(seeds) > 0 {
+			if bootstrap, joinAddrs, err = seedCluster(seeds); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid peers: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+
+	if httpAdv == "" {
+		httpAdv = httpAddr
+	}
+	hosted := shard.DataGroups(int(conf.RaftGroups))
+	if groups != "" {
+		var err error
+		if hosted, err = base.ParseGroups(groups); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid groups: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	// The first node bootstraps every group, which
+	// the other nodes then join.
+	if conf.RaftGroups > 1 && bootstrap && len(hosted) != int(conf.RaftGroups) {
+		fmt.Fprintf(os.Stderr, "The first node of a sharded cluster must host every group\n")
+		os.Exit(1)
+	}
+
+	var err error
+	node, err = shard.NewNode(conf, nodeID, httpAdv, hosted)
+	if err != nil {
+		log.Fatalf("failed to create node: %s", err.Error())
+	}
+	node.GroupPort = groupPort
+	log.Printf("using the %s cache policy...", conf.Policy)
 
-	store.RaftDir = raftDir
-	store.RaftBind = raftAddr
-	if err := store.Open(joinAddr == "", nodeID); err != nil {
+	if err := node.Open(raftDir, raftAddr, bootstrap); err != nil {
 		log.Fatalf("failed to open store: %s", err.Error())
 	} 
+	store := node.Meta()
 
 	usr, _ := user.Current()
 	configPath := usr.HomeDir
@@ -151,55 +239,184 @@ func main() {
 		} else { 
 			log.Println("successfully booted new cache...")
 		}
-	} else {
-		if conf.PersistenceAOF {
-			if ok, _ := persistence.AofExists(); ok {
-				persistence.RebootAof(&store.Cache, conf.AofMaxBytes)
-			}
-			store.BuildStoreFromAof()
-			persistence.BootAOF(&store.Cache, conf.AofMaxBytes)
-			log.Println("successfully booted from AOF...")
-		}
+	} else if !conf.PersistenceAOF {
 		log.Println("successfully booted new cache...")
 	}
 
-	store.RunStore()
+	// If AOF persistence is enabled, the store replays the
+	// AOF into its cache before it starts.
+	for _, g := range node.Groups() {
+		s, _ := node.Store(g)
+		s.RunStore()
+	}
+	if !conf.SnapshotEnabled && conf.PersistenceAOF {
+		log.Println("successfully booted from AOF...")
+	}
 
 	log.Println("Starting store...")
 
 	sysMetricsScheduler = system_monitor.NewSysMetricsScheduler(conf.SysMetricInterval)
 
-	service := server.NewService(httpAddr, store)
+	service := server.NewService(httpAddr, node)
 	go service.Start()
+	if respAddr != "" {
+		go func() {
+			if err := service.ServeRESP(respAddr); err != nil {
+				log.Fatalf("failed to serve RESP: %s", err.Error())
+			}
+		}()
+	}
+	if mcAddr != "" {
+		go func() {
+			if err := service.ServeMemcache(mcAddr); err != nil {
+				log.Fatalf("failed to serve memcached: %s", err.Error())
+			}
+		}()
+	}
 
 	log.Println("Starting service...")
 
-	if joinAddr != "" {
-		if err := join(joinAddr, raftAddr, nodeID); err != nil {
-			log.Fatalf("failed to join node at %s: %s", joinAddr, err.Error())
-		}
+	if len(joinAddrs) > 0 {
+		joinCluster(joinAddrs, raftAddr, httpAdv, nodeID)
 	}
 
 	log.Println("started successfully ...")
 
-	t := make(chan os.Signal)
+	t := make(chan os.Signal, 1)
 	signal.Notify(t, os.Interrupt, syscall.SIGTERM)
 	<-t
 
 	log.Println("exiting ...")
+	if err := service.Close(); err != nil {
+		log.Printf("failed to close store: %s", err.Error())
+	}
 	
 }
 
-func join(joinAddr, raftAddr, nodeID string) error {
-	b, err := json.Marshal(map[string]string{"addr": raftAddr, "id": nodeID})
+// seedCluster decides from the peer list if this node bootstraps the
+// cluster, and the HTTP addresses of the peers it otherwise joins
+// through, the bootstrapper first. Only the bootstrapper bootstraps,
+// and only if no other peer is already in a cluster, so a restarted
+// bootstrapper rejoins the cluster rather than starting another. A
+// node that bootstraps joins through no one, the others join it.
+func seedCluster(seeds []config.Peer) (bool, []string, error) {
+	bootstrapper := config.Bootstrapper(seeds)
+	var self *config.Peer
+	var addrs []string
+	for i := range seeds {
+		if seeds[i].ID == nodeID {
+			self = &seeds[i]
+		} else if seeds[i].ID == bootstrapper.ID {
+			addrs = append([]string{seeds[i].HTTPAddr}, addrs...)
+		} else {
+			addrs = append(addrs, seeds[i].HTTPAddr)
+		}
+	}
+	if self == nil {
+		return false, nil, fmt.Errorf("node ID %q is not one of the peers", nodeID)
+	}
+	// Other nodes are given the address in the peer list
+	if httpAdv == "" {
+		httpAdv = self.HTTPAddr
+	}
+	if self.ID != bootstrapper.ID {
+		return false, addrs, nil
+	}
+	for _, addr := range addrs {
+		if inCluster(addr) {
+			return false, addrs, nil
+		}
+	}
+	return true, nil, nil
+}
+
+// inCluster reports if the node at addr knows the leader of a cluster.
+func inCluster(addr string) bool {
+	client := http.Client{Timeout: probeTimeout}
+	resp, err := client.Post(fmt.Sprintf("http://%s/getLeader", addr), "application-type/json", nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	var res response.CacheResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return false
+	}
+	return res.Message != ""
+}
+
+// joinCluster joins the node to the cluster through any of the nodes
+// at addrs, which may not have started yet, so it keeps trying, with
+// backoff, until one of them accepts it.
+func joinCluster(addrs []string, raftAddr, httpAddr, nodeID string) {
+	backoff := joinMinBackoff
+	for {
+		for _, addr := range addrs {
+			err := joinGroups(addr, raftAddr, httpAddr, nodeID)
+			if err == nil {
+				return
+			}
+			log.Printf("failed to join node at %s: %s", addr, err.Error())
+		}
+		log.Printf("retrying join in %s...", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > joinMaxBackoff {
+			backoff = joinMaxBackoff
+		}
+	}
+}
+
+// joinGroups joins the node to every group it hosts. The data groups
+// it hosts are recorded in the directory of the meta group only once
+// it has joined them, so no node routes to it before then.
+func joinGroups(joinAddr, raftAddr, httpAddr, nodeID string) error {
+	for _, g := range node.Groups() {
+		addr, err := shard.GroupRaftAddr(raftAddr, node.GroupPort, g)
+		if err != nil {
+			return err
+		}
+		if err := join(joinAddr, addr, httpAddr, nodeID, map[string]string{"group": strconv.Itoa(g)}); err != nil {
+			return err
+		}
+	}
+	if !node.Sharded() {
+		return nil
+	}
+	return join(joinAddr, raftAddr, httpAddr, nodeID, map[string]string{"groups": base.FormatGroups(node.DataGroupsHosted())})
+}
+
+func join(joinAddr, raftAddr, httpAddr, nodeID string, extra map[string]string) error {
+	m := map[string]string{"addr": raftAddr, "httpAddr": httpAddr, "id": nodeID}
+	for k, v := range extra {
+		m[k] = v
+	}
+	cmd := "join"
+	if learner {
+		cmd = "addLearner"
+	}
+	b, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(fmt.Sprintf("http://%s/join", joinAddr), "application-type/json", bytes.NewReader(b))
+	resp, err := http.Post(fmt.Sprintf("http://%s/%s", joinAddr, cmd), "application-type/json", bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	// A node that is not in the cluster, or does not know its
+	// leader, answers with a failed response rather than an error.
+	var res response.CacheResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("join returned status %d: %s", resp.StatusCode, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK || res.Status != 1 {
+		msg := res.Message
+		if res.Error != "" {
+			msg = res.Error
+		}
+		return fmt.Errorf("join returned status %d: %s", resp.StatusCode, msg)
+	}
 	return nil
 }
\ No newline at end of file
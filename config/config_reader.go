@@ -50,6 +50,21 @@ const (
 	DEFAULT_ENTRY_TIMESTAMP          = true  // Enable timestamps in appMetrics logs
 	DEFAULT_ENABLE_ENCRYPTION        = true
 	DEFAULT_PASSPHRASE               = "SUPPLY_ME"
+	DEFAULT_POLICY                   = "LRU"
+	DEFAULT_MAX_MEMORY_BYTES         = 0 // No memory bound
+	DEFAULT_SHARD_COUNT              = 1
+	DEFAULT_RAFT_STORE               = RAFT_STORE_DURABLE
+	DEFAULT_RAFT_GROUPS              = 1 // A single Raft group holds every key
+	DEFAULT_PROPOSAL_BATCH_SIZE      = 128
+	DEFAULT_PROPOSAL_BATCH_WINDOW    = 500 // 0.5 milliseconds
+	DEFAULT_REPLICATION_SOURCE       = "primary"
+	DEFAULT_REPLICATION_BATCH_SIZE   = 512
+)
+
+// Raft store types
+const (
+	RAFT_STORE_INMEM   = "inmem"   // Raft state is lost when the node stops
+	RAFT_STORE_DURABLE = "durable" // Raft state is kept on disk under the Raft directory
 )
 
 type Configuration struct {
@@ -106,6 +121,85 @@ type Configuration struct {
 	// Passphrase is the passphrase to be used for snapshot encryption
 	// should it be enabled.
 	Passphrase             string
+
+	// Policy is the cache eviction policy used by the node.
+	// One of LRU, LFU, MRU, ARC, TLRU or WTINYLFU.
+	Policy                 string
+
+	// MaxMemoryBytes is the maximum estimated number of bytes
+	// the key-value pairs in the cache may use. The cache evicts
+	// when either this or KeyspaceSize is reached. If set to 0
+	// only KeyspaceSize bounds the cache.
+	MaxMemoryBytes         int64
+
+	// ShardCount is the number of independent segments the LRU
+	// cache is split into, each with its own lock. The keyspace
+	// size is divided between them, the memory bound is shared.
+	ShardCount             int32
+
+	// RaftStore is where the node keeps its Raft log, term and
+	// vote. One of durable, which keeps them on disk under the
+	// Raft directory so they survive a restart, or inmem.
+	RaftStore              string
+
+	// RaftGroups is the number of Raft groups, or shards, the
+	// keyspace is partitioned into. Each key belongs to the group
+	// that owns the slot of its hash, and each group can be hosted
+	// by a different set of nodes. If set to 1 every node holds
+	// every key. Unlike ShardCount it spans nodes.
+	RaftGroups             int32
+
+	// ProposalBatchSize is the maximum number of writes proposed
+	// to the Raft log in a single entry. Writes made concurrently
+	// are batched, so throughput is not bound by the round trip of
+	// each entry. If set to 1 batching is off, and each write is
+	// its own entry. Values below 1 are taken as the default.
+	ProposalBatchSize      int32
+
+	// ProposalBatchWindow is the most time, in microseconds, a
+	// batch waits for more writes while earlier batches commit.
+	// A write made while none are committing is proposed at once.
+	ProposalBatchWindow    int32
+
+	// RaftTLSCertFile and RaftTLSKeyFile are the paths of the PEM
+	// encoded certificate and private key the node presents to the
+	// other nodes of the cluster. If set, along with RaftTLSCAFile,
+	// Raft traffic between nodes is encrypted with TLS, as are the
+	// requests nodes send each other over HTTP.
+	RaftTLSCertFile        string
+	RaftTLSKeyFile         string
+
+	// RaftTLSCAFile is the path of the PEM encoded certificate of
+	// the CA that signs the certificates of every node. Nodes only
+	// accept connections from nodes presenting such a certificate.
+	RaftTLSCAFile          string
+
+	// Peers lists the ID and HTTP address of every node of the
+	// cluster, each as id=host:port. Nodes started with the same
+	// peers agree on the one that bootstraps the cluster, and the
+	// others join it through any peer already in the cluster.
+	Peers                  []string
+
+	// ReplicationTargets are the HTTP addresses of nodes of a standby
+	// cluster. If set, the leader of each group ships the writes it
+	// commits to the standby cluster, which applies them, keeping
+	// the later of conflicting writes. Any node of the standby
+	// cluster can be given, the others are tried if it is down.
+	ReplicationTargets     []string
+
+	// ReplicationSource is the name the standby cluster records
+	// how far it has replicated the writes of this cluster under,
+	// so clusters replicating to the same standby need their own.
+	ReplicationSource      string
+
+	// ReplicationBatchSize is the maximum number of writes shipped
+	// to the standby cluster in a single request.
+	ReplicationBatchSize   int32
+}
+
+// RaftTLSEnabled reports if Raft traffic between nodes uses TLS.
+func (conf *Configuration) RaftTLSEnabled() bool {
+	return conf.RaftTLSCertFile != "" || conf.RaftTLSKeyFile != "" || conf.RaftTLSCAFile != ""
 }
 
 // InitializeConfiguration initializes the cache configuration object
@@ -132,6 +226,15 @@ func (conf *Configuration) SetDefaultParams() {
 	conf.EntryTimestamp = DEFAULT_ENTRY_TIMESTAMP
 	conf.EnableEncryption = DEFAULT_ENABLE_ENCRYPTION
 	conf.Passphrase = DEFAULT_PASSPHRASE
+	conf.Policy = DEFAULT_POLICY
+	conf.MaxMemoryBytes = DEFAULT_MAX_MEMORY_BYTES
+	conf.ShardCount = DEFAULT_SHARD_COUNT
+	conf.RaftStore = DEFAULT_RAFT_STORE
+	conf.RaftGroups = DEFAULT_RAFT_GROUPS
+	conf.ProposalBatchSize = DEFAULT_PROPOSAL_BATCH_SIZE
+	conf.ProposalBatchWindow = DEFAULT_PROPOSAL_BATCH_WINDOW
+	conf.ReplicationSource = DEFAULT_REPLICATION_SOURCE
+	conf.ReplicationBatchSize = DEFAULT_REPLICATION_BATCH_SIZE
 }
 
 // InitializeFromConfig initializes a configuration object from
@@ -149,5 +252,32 @@ func InitializeFromConfig() (Configuration, error) {
 		return Configuration{}, err
 	}
 
+	// Config files written before the policy setting existed
+	// keep the policy the node has always used.
+	if config.Policy == "" {
+		config.Policy = DEFAULT_POLICY
+	}
+	if config.ShardCount < 1 {
+		config.ShardCount = DEFAULT_SHARD_COUNT
+	}
+	if config.RaftStore == "" {
+		config.RaftStore = DEFAULT_RAFT_STORE
+	}
+	if config.RaftGroups < 1 {
+		config.RaftGroups = DEFAULT_RAFT_GROUPS
+	}
+	if config.ProposalBatchSize < 1 {
+		config.ProposalBatchSize = DEFAULT_PROPOSAL_BATCH_SIZE
+	}
+	if config.ProposalBatchWindow < 0 {
+		config.ProposalBatchWindow = DEFAULT_PROPOSAL_BATCH_WINDOW
+	}
+	if config.ReplicationSource == "" {
+		config.ReplicationSource = DEFAULT_REPLICATION_SOURCE
+	}
+	if config.ReplicationBatchSize < 1 {
+		config.ReplicationBatchSize = DEFAULT_REPLICATION_BATCH_SIZE
+	}
+
 	return config, nil
 }
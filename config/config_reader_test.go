@@ -48,4 +48,16 @@ func TestInitializeConfiguration(t *testing.T) {
 	utils.AssertEqual(t, conf.EntryTimestamp, true, "")
 	utils.AssertEqual(t, conf.EnableEncryption, true, "")
 	utils.AssertEqual(t, conf.Passphrase, "SUPPLY_ME", "")
+	utils.AssertEqual(t, conf.Policy, "LRU", "")
+	utils.AssertEqual(t, conf.MaxMemoryBytes, int64(0), "")
+	utils.AssertEqual(t, conf.ShardCount, int32(1), "")
+	utils.AssertEqual(t, conf.RaftStore, "durable", "")
+	utils.AssertEqual(t, conf.RaftGroups, int32(1), "")
+	utils.AssertEqual(t, conf.ProposalBatchSize, int32(128), "")
+	utils.AssertEqual(t, conf.ProposalBatchWindow, int32(500), "")
+	utils.AssertEqual(t, conf.RaftTLSEnabled(), false, "")
+	utils.AssertEqual(t, len(conf.Peers), 0, "")
+	utils.AssertEqual(t, len(conf.ReplicationTargets), 0, "")
+	utils.AssertEqual(t, conf.ReplicationSource, "primary", "")
+	utils.AssertEqual(t, conf.ReplicationBatchSize, int32(512), "")
 }
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Peer is a node named in the peer list of the cluster.
+type Peer struct {
+	ID       string
+	HTTPAddr string
+}
+
+// ParsePeers parses a peer list, each entry of which gives the ID
+// and HTTP address of a node as id=host:port.
+func ParsePeers(entries []string) ([]Peer, error) {
+	peers := make([]Peer, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i <= 0 || i == len(entry)-1 {
+			return nil, fmt.Errorf("peer %q is not of the form id=host:port", entry)
+		}
+		peer := Peer{ID: entry[:i], HTTPAddr: entry[i+1:]}
+		if seen[peer.ID] {
+			return nil, fmt.Errorf("peer %s is listed more than once", peer.ID)
+		}
+		seen[peer.ID] = true
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Bootstrapper returns the peer that bootstraps the cluster, the
+// one with the lowest ID, so every node given the same peers agrees
+// on it whatever order they are listed in.
+func Bootstrapper(peers []Peer) Peer {
+	sorted := make([]Peer, len(peers))
+	copy(sorted, peers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted[0]
+}
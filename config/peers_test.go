@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers([]string{"node1=10.0.0.2:7991", " node0=10.0.0.1:7991", ""})
+	utils.AssertEqual(t, err, nil, "")
+	expected := []Peer{{"node1", "10.0.0.2:7991"}, {"node0", "10.0.0.1:7991"}}
+	utils.AssertEqual(t, reflect.DeepEqual(peers, expected), true, "")
+
+	// Every node agrees on the bootstrapper, whatever the order
+	utils.AssertEqual(t, Bootstrapper(peers), Peer{"node0", "10.0.0.1:7991"}, "")
+	peers[0], peers[1] = peers[1], peers[0]
+	utils.AssertEqual(t, Bootstrapper(peers), Peer{"node0", "10.0.0.1:7991"}, "")
+
+	for _, entries := range [][]string{{"node0"}, {"=10.0.0.1:7991"}, {"node0="}, {"node0=a:1", "node0=b:1"}} {
+		_, err := ParsePeers(entries)
+		utils.AssertEqual(t, err != nil, true, "")
+	}
+}
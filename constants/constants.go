@@ -39,6 +39,7 @@ const (
 	STORE_FLUSH = "flush"
 	STORE_NODE_SIZE = "nodeSize"
 	STORE_APP_METRICS = "getAppMetrics"
+	STORE_EXPIRE = "expire" // Internal, proposed by the leaders crawlers
 
 	// STORE POLICY TYPES
 	LRU_TYPE = "LRU"   // Least recently used
@@ -46,4 +47,5 @@ const (
 	MRU_TYPE = "MRU"   // Most recently used
 	ARC_TYPE = "ARC"   // Adaptive Replacement Cache
 	TLRU_TYPE = "TLRU" // Time-aware Least Recently Used
+	WTINYLFU_TYPE = "WTINYLFU" // Window TinyLFU
 )
\ No newline at end of file
@@ -44,10 +44,12 @@ func TestConstants(t *testing.T) {
 	utils.AssertEqual(t, STORE_FLUSH, "flush", "")
 	utils.AssertEqual(t, STORE_NODE_SIZE, "nodeSize", "")
 	utils.AssertEqual(t, STORE_APP_METRICS, "getAppMetrics", "")
+	utils.AssertEqual(t, STORE_EXPIRE, "expire", "")
 
 	utils.AssertEqual(t, LRU_TYPE, "LRU", "")
 	utils.AssertEqual(t, LFU_TYPE, "LFU", "")
 	utils.AssertEqual(t, MRU_TYPE, "MRU", "")
 	utils.AssertEqual(t, ARC_TYPE, "ARC", "")
 	utils.AssertEqual(t, TLRU_TYPE, "TLRU", "")
+	utils.AssertEqual(t, WTINYLFU_TYPE, "WTINYLFU", "")
 }
\ No newline at end of file
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/valyala/fasthttp"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// statusTimeout bounds how long the status of a
+// single node is waited for.
+const statusTimeout = 2 * time.Second
+
+// parseMembershipRequest reads the body of a membership request,
+// which must give every one of the required fields.
+func parseMembershipRequest(ctx *fasthttp.RequestCtx, required ...string) (map[string]string, bool) {
+	m := make(map[string]string)
+	if err := json.Unmarshal(ctx.PostBody(), &m); err != nil {
+		return nil, false
+	}
+	for _, field := range required {
+		if m[field] == "" {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+func badMembershipRequest(ctx *fasthttp.RequestCtx, msg string) response.CacheResponse {
+	ctx.SetStatusCode(http.StatusBadRequest)
+	return response.NewResponseFromMessage(msg, 0)
+}
+
+// membershipResponse converts the result of a membership change into
+// a response. Changes sent to a follower name the leader, so they are
+// forwarded to it like any other request the follower cannot serve.
+func membershipResponse(ctx *fasthttp.RequestCtx, store *base.Store, err error) response.CacheResponse {
+	if err == nil {
+		return response.NewResponseFromMessage("OK", 1)
+	}
+	if err == raft.ErrNotLeader {
+		return response.NewNotLeaderResponse(store.LeaderHTTPAddr())
+	}
+	log.Println(err.Error())
+	ctx.SetStatusCode(http.StatusInternalServerError)
+	return response.NewResponseFromMessage(err.Error(), 0)
+}
+
+func handleAddLearner(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "id", "addr")
+	if !ok {
+		return badMembershipRequest(ctx, "addLearner requires an id and addr")
+	}
+
+	// Learners replicate the log and serve stale reads,
+	// without counting towards the quorum.
+	return membershipResponse(ctx, store, addPeer(store, m, store.AddLearner))
+}
+
+func handleRemove(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "id")
+	if !ok {
+		return badMembershipRequest(ctx, "remove requires an id")
+	}
+	return membershipResponse(ctx, store, store.Remove(m["id"]))
+}
+
+// handlePromote makes a learner a voter of the group,
+// so it counts towards the quorum.
+func handlePromote(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "id")
+	if !ok {
+		return badMembershipRequest(ctx, "promote requires an id")
+	}
+	return membershipResponse(ctx, store, store.Promote(m["id"]))
+}
+
+// handleLeave removes this node from every group it hosts, its data
+// groups before the meta group, so other nodes stop routing to it
+// before it leaves. A follower cannot change the membership of a
+// group itself, so it asks the leader of the group to remove it.
+func (service *Service) handleLeave(ctx *fasthttp.RequestCtx) response.CacheResponse {
+	groups := service.node.Groups()
+	res := response.NewResponseFromMessage("OK", 1)
+	for i := len(groups) - 1; i >= 0; i-- {
+		if res = service.leaveGroup(ctx, groups[i]); res.Status != 1 {
+			return res
+		}
+	}
+	return res
+}
+
+func (service *Service) leaveGroup(ctx *fasthttp.RequestCtx, group int) response.CacheResponse {
+	store, _ := service.node.Store(group)
+	err := store.Remove(store.ServerID)
+	if err != raft.ErrNotLeader {
+		return membershipResponse(ctx, store, err)
+	}
+
+	leader := store.LeaderHTTPAddr()
+	if leader == "" {
+		return response.NewNotLeaderResponse(leader)
+	}
+	body, _ := json.Marshal(map[string]string{"id": store.ServerID})
+	var res response.CacheResponse
+	if err := service.call(leader, "remove", ForwardedHeader, group, body, forwardTimeout, &res); err != nil {
+		return membershipResponse(ctx, store, err)
+	}
+	return res
+}
+
+// nodeStatusResponse is a response holding the status of a node.
+type nodeStatusResponse struct {
+	Gobj struct {
+		Value base.ServerStatus
+	}
+}
+
+// handleGetStatus returns the status of every server in a group.
+// Each other server is asked for its own last contact and applied
+// index, and is reported unreachable if it does not answer.
+func (service *Service) handleGetStatus(ctx *fasthttp.RequestCtx, store *base.Store, group int) response.CacheResponse {
+	servers, err := store.Status()
+	if err != nil {
+		return membershipResponse(ctx, store, err)
+	}
+
+	for i := range servers {
+		srv := &servers[i]
+		if srv.Reachable || srv.HTTPAddr == "" {
+			continue
+		}
+		var res nodeStatusResponse
+		if err := service.call(srv.HTTPAddr, "getNodeStatus", ForwardedHeader, group, nil, statusTimeout, &res); err != nil {
+			continue
+		}
+		srv.LastContact = res.Gobj.Value.LastContact
+		srv.AppliedIndex = res.Gobj.Value.AppliedIndex
+		srv.Reachable = true
+	}
+	return response.NewResponseFromValue(servers)
+}
+
+// call sends a request for a group to the node at addr, marked with
+// the given header, and decodes its response. Requests to another
+// cluster are given no header, as its groups are its own.
+func (service *Service) call(addr string, cmd string, header string, group int, body []byte, timeout time.Duration, res interface{}) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(fmt.Sprintf("%s://%s/%s", service.scheme(), addr, cmd))
+	req.Header.SetMethod("POST")
+	if header != "" {
+		req.Header.Set(header, service.addr)
+		req.Header.Set(GroupHeader, strconv.Itoa(group))
+	}
+	req.SetBody(body)
+
+	if err := service.client.DoTimeout(req, resp, timeout); err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Body(), res)
+}
+
+// callBinary sends a cache request for a group to the node at addr,
+// marked with the given header, in the binary format of the log, so
+// its value and the value of its response are passed on unchanged.
+func (service *Service) callBinary(addr string, cmd string, header string, group int, r request.CacheRequest) (response.CacheResponse, error) {
+	body, err := base.EncodeRequest(&r)
+	if err != nil {
+		return response.CacheResponse{}, err
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(fmt.Sprintf("%s://%s/%s", service.scheme(), addr, cmd))
+	req.Header.SetMethod("POST")
+	req.Header.Set(header, service.addr)
+	req.Header.Set(GroupHeader, strconv.Itoa(group))
+	req.Header.Set(EncodingHeader, BinaryEncoding)
+	req.SetBody(body)
+
+	if err := service.client.DoTimeout(req, resp, forwardTimeout); err != nil {
+		return response.CacheResponse{}, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return response.CacheResponse{}, fmt.Errorf("%s answered %s with status %d", addr, cmd, resp.StatusCode())
+	}
+	return base.DecodeResponse(resp.Body())
+}
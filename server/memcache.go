@@ -0,0 +1,700 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+const (
+	// memcacheMaxKey is the longest key, and memcacheMaxItem
+	// the largest value, a client may store, as in memcached.
+	memcacheMaxKey  = 250
+	memcacheMaxItem = 1024 * 1024
+
+	// memcacheMaxRelative is the longest expiration time that is
+	// a number of seconds, longer times are unix times.
+	memcacheMaxRelative = 60 * 60 * 24 * 30
+
+	memcacheFormatErr = "CLIENT_ERROR bad command line format"
+	memcacheFlagErr   = "CLIENT_ERROR invalid flag"
+)
+
+var (
+	errMemcacheChunk    = errors.New("CLIENT_ERROR bad data chunk")
+	errMemcacheTooLarge = errors.New("SERVER_ERROR object too large for cache")
+)
+
+// memcacheConn is a connection of a memcached client. As with Redis
+// clients, replies are flushed once every command the client has
+// sent has been served.
+type memcacheConn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// ServeMemcache serves memcached clients on addr, speaking the
+// memcached text protocol and its meta commands.
+func (service *Service) ServeMemcache(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving memcached on %s...", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go service.serveMemcacheConn(conn)
+	}
+}
+
+func (service *Service) serveMemcacheConn(conn net.Conn) {
+	defer conn.Close()
+	c := &memcacheConn{
+		r: bufio.NewReaderSize(conn, respBufferSize),
+		w: bufio.NewWriterSize(conn, respBufferSize),
+	}
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.writeLine("CLIENT_ERROR line too long")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if !service.serveMemcacheCommand(c, strings.Fields(string(line))) {
+			c.w.Flush()
+			return
+		}
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *memcacheConn) writeLine(s string) {
+	c.w.WriteString(s + "\r\n")
+}
+
+// reply writes a line unless the client asked for no reply.
+func (c *memcacheConn) reply(noreply bool, s string) {
+	if !noreply {
+		c.writeLine(s)
+	}
+}
+
+// writeFailure replies with the error of a failed response.
+func (c *memcacheConn) writeFailure(res response.CacheResponse) {
+	if res.Error != "" {
+		c.writeLine("SERVER_ERROR " + res.Error + ": " + res.Message)
+	} else {
+		c.writeLine("SERVER_ERROR " + res.Message)
+	}
+}
+
+// readData reads a data block of size bytes and its CRLF. A block
+// larger than a value may be is read and discarded.
+func (c *memcacheConn) readData(size int) ([]byte, error) {
+	if size > memcacheMaxItem {
+		if _, err := c.r.Discard(size + 2); err != nil {
+			return nil, err
+		}
+		return nil, errMemcacheTooLarge
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return nil, errMemcacheChunk
+	}
+	return data[:size], nil
+}
+
+// readValue reads the data block of a storage command, replying
+// with the error if it cannot be stored. It returns false if the
+// block could not be read either.
+func (c *memcacheConn) readValue(size int) ([]byte, bool) {
+	data, err := c.readData(size)
+	if err == errMemcacheChunk || err == errMemcacheTooLarge {
+		c.writeLine(err.Error())
+		return nil, true
+	}
+	return data, err == nil
+}
+
+// noreply reports if the last of the arguments of a command is
+// noreply, and returns the other arguments.
+func noreply(args []string) ([]string, bool) {
+	if n := len(args); n > 0 && args[n-1] == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > memcacheMaxKey {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// memcacheTTL converts a memcached expiration time, which is 0 for
+// none, a number of seconds, or a unix time if it is longer than 30
+// days, to a TTL. It also reports if the time has already passed.
+func memcacheTTL(exptime int64) (int64, bool) {
+	switch {
+	case exptime == 0:
+		return -1, false
+	case exptime < 0:
+		return 0, true
+	case exptime > memcacheMaxRelative:
+		ttl := exptime - time.Now().Unix()
+		return ttl, ttl <= 0
+	}
+	return exptime, false
+}
+
+// executeExpiring serves a write for a single key. If the expiration
+// time of the write has already passed, the write is served and the
+// key deleted, so the condition of the write is checked but it
+// leaves no key-value pair.
+func (service *Service) executeExpiring(cmd string, req request.CacheRequest, expired bool) response.CacheResponse {
+	if !expired {
+		return service.executeKey(cmd, req)
+	}
+	req.Gobj.TTL = 0
+	res := service.executeKey(cmd, req)
+	if res.Status == 1 {
+		service.executeKey(base.STORE_DELETE, request.NewRequestFromValues(req.Gobj.Key, nil, -1))
+	}
+	return res
+}
+
+// serveMemcacheCommand serves a command and writes its reply. It
+// returns false if the connection should be closed.
+func (service *Service) serveMemcacheCommand(c *memcacheConn, fields []string) bool {
+	if len(fields) == 0 {
+		c.writeLine("ERROR")
+		return true
+	}
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case "get", "gets":
+		service.memcacheGet(c, args, cmd == "gets")
+	case "set", "add", "replace", "cas":
+		return service.memcacheStore(c, cmd, args)
+	case "delete":
+		service.memcacheDelete(c, args)
+	case "incr", "decr":
+		service.memcacheCounter(c, cmd, args)
+	case "touch":
+		service.memcacheTouch(c, args)
+	case "flush_all":
+		service.memcacheFlushAll(c, args)
+	case "mg":
+		service.memcacheMetaGet(c, args)
+	case "ms":
+		return service.memcacheMetaSet(c, args)
+	case "md":
+		service.memcacheMetaDelete(c, args)
+	case "mn":
+		c.writeLine("MN")
+	case "version":
+		c.writeLine("VERSION " + ServerVersion)
+	case "verbosity":
+		_, quiet := noreply(args)
+		c.reply(quiet, "OK")
+	case "quit":
+		return false
+	default:
+		c.writeLine("ERROR")
+	}
+	return true
+}
+
+// memcacheGet replies with the key-value pairs of the keys that
+// exist, with their version as their cas unique for gets.
+func (service *Service) memcacheGet(c *memcacheConn, keys []string, cas bool) {
+	if len(keys) == 0 {
+		c.writeLine("ERROR")
+		return
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			c.writeLine(memcacheFormatErr)
+			return
+		}
+	}
+	for _, key := range keys {
+		res := service.executeKey(base.STORE_GETS, request.NewRequestFromValues(key, nil, -1))
+		if failed(res) {
+			c.writeFailure(res)
+			return
+		}
+		if res.Status != 1 {
+			continue
+		}
+		data := valueBytes(res.Gobj.Value)
+		line := "VALUE " + key + " " + strconv.FormatUint(uint64(res.Gobj.Flags), 10) + " " + strconv.Itoa(len(data))
+		if cas {
+			line += " " + strconv.FormatUint(res.Version, 10)
+		}
+		c.writeLine(line)
+		c.w.Write(data)
+		c.w.WriteString("\r\n")
+	}
+	c.writeLine("END")
+}
+
+// memcacheStore serves set, add, replace and cas, which are sent as
+// <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+// followed by a data block. It returns false if the data block
+// could not be read.
+func (service *Service) memcacheStore(c *memcacheConn, cmd string, args []string) bool {
+	args, quiet := noreply(args)
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	if len(args) < 4 {
+		c.writeLine(memcacheFormatErr)
+		return true
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		c.writeLine(memcacheFormatErr)
+		return true
+	}
+	data, ok := c.readValue(size)
+	if data == nil {
+		return ok
+	}
+
+	flags, err := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	if len(args) != n || !validKey(args[0]) || err != nil || err2 != nil {
+		c.writeLine(memcacheFormatErr)
+		return true
+	}
+	ttl, expired := memcacheTTL(exptime)
+	req := request.NewRequestFromValues(args[0], data, ttl)
+	req.Gobj.Flags = uint32(flags)
+
+	storeCmd := map[string]string{
+		"set":     base.STORE_PUT,
+		"add":     base.STORE_ADD,
+		"replace": base.STORE_REPLACE,
+		"cas":     base.STORE_CAS,
+	}[cmd]
+	if cmd == "cas" {
+		if req.Cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			c.writeLine(memcacheFormatErr)
+			return true
+		}
+	}
+
+	res := service.executeExpiring(storeCmd, req, expired)
+	switch {
+	case failed(res):
+		c.writeFailure(res)
+	case res.Status == 1:
+		c.reply(quiet, "STORED")
+	case res.Message == base.EXISTS:
+		c.reply(quiet, "EXISTS")
+	case res.Message == lru.NOT_FOUND:
+		c.reply(quiet, "NOT_FOUND")
+	default:
+		c.reply(quiet, "NOT_STORED")
+	}
+	return true
+}
+
+// memcacheDelete serves delete <key> [0] [noreply].
+func (service *Service) memcacheDelete(c *memcacheConn, args []string) {
+	args, quiet := noreply(args)
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 || !validKey(args[0]) {
+		c.writeLine(memcacheFormatErr)
+		return
+	}
+	res := service.executeKey(base.STORE_DELETE, request.NewRequestFromValues(args[0], nil, -1))
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status == 1 {
+		c.reply(quiet, "DELETED")
+	} else {
+		c.reply(quiet, "NOT_FOUND")
+	}
+}
+
+// memcacheCounter serves incr and decr <key> <value> [noreply],
+// replying with the new value.
+func (service *Service) memcacheCounter(c *memcacheConn, cmd string, args []string) {
+	args, quiet := noreply(args)
+	if len(args) != 2 || !validKey(args[0]) {
+		c.writeLine(memcacheFormatErr)
+		return
+	}
+	amount, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.writeLine("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+	storeCmd := base.STORE_INCR
+	if cmd == "decr" {
+		storeCmd = base.STORE_DECR
+	}
+	res := service.executeKey(storeCmd, request.NewRequestFromValues(args[0], amount, -1))
+	switch {
+	case failed(res):
+		c.writeFailure(res)
+	case res.Status == 1:
+		c.reply(quiet, string(valueBytes(res.Gobj.Value)))
+	case res.Message == base.NON_NUMERIC:
+		c.writeLine("CLIENT_ERROR cannot increment or decrement non-numeric value")
+	default:
+		c.reply(quiet, "NOT_FOUND")
+	}
+}
+
+// memcacheTouch serves touch <key> <exptime> [noreply].
+func (service *Service) memcacheTouch(c *memcacheConn, args []string) {
+	args, quiet := noreply(args)
+	if len(args) != 2 || !validKey(args[0]) {
+		c.writeLine(memcacheFormatErr)
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.writeLine("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+	ttl, expired := memcacheTTL(exptime)
+	res := service.executeExpiring(base.STORE_TOUCH, request.NewRequestFromValues(args[0], nil, ttl), expired)
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status == 1 {
+		c.reply(quiet, "TOUCHED")
+	} else {
+		c.reply(quiet, "NOT_FOUND")
+	}
+}
+
+// memcacheFlushAll serves flush_all [delay] [noreply]. A delayed
+// flush is made by this node once the delay has passed.
+func (service *Service) memcacheFlushAll(c *memcacheConn, args []string) {
+	args, quiet := noreply(args)
+	delay := int64(0)
+	if len(args) > 1 {
+		c.writeLine(memcacheFormatErr)
+		return
+	}
+	if len(args) == 1 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || delay < 0 {
+			c.writeLine(memcacheFormatErr)
+			return
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			if res := service.flushAll(); res.Status != 1 {
+				log.Printf("failed to flush: %s", res.Message)
+			}
+		})
+		c.reply(quiet, "OK")
+		return
+	}
+	if res := service.flushAll(); res.Status != 1 {
+		c.writeFailure(res)
+		return
+	}
+	c.reply(quiet, "OK")
+}
+
+// metaRequest is a meta command parsed from its key and flags.
+type metaRequest struct {
+	key    string
+	flags  []string
+	base64 bool
+}
+
+// has reports if the request has the flag, and returns its token.
+func (m *metaRequest) has(flag byte) (string, bool) {
+	for _, f := range m.flags {
+		if f[0] == flag {
+			return f[1:], true
+		}
+	}
+	return "", false
+}
+
+// returned formats the flags of a reply that echo the request,
+// followed by those given.
+func (m *metaRequest) returned(values map[byte]string) string {
+	var out []string
+	for _, f := range m.flags {
+		switch f[0] {
+		case 'O':
+			out = append(out, f)
+		case 'k':
+			key := m.key
+			if m.base64 {
+				key = base64.StdEncoding.EncodeToString([]byte(key))
+			}
+			out = append(out, "k"+key)
+		case 'b':
+			out = append(out, "b")
+		default:
+			if v, ok := values[f[0]]; ok {
+				out = append(out, string(f[0])+v)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return " " + strings.Join(out, " ")
+}
+
+// parseMeta parses the key and flags of a meta command, only
+// accepting the flags given, and replies if they are invalid.
+func (c *memcacheConn) parseMeta(args []string, allowed string) (*metaRequest, bool) {
+	if len(args) == 0 {
+		c.writeLine(memcacheFormatErr)
+		return nil, false
+	}
+	m := &metaRequest{key: args[0], flags: args[1:]}
+	for _, f := range m.flags {
+		if !strings.Contains(allowed, f[:1]) {
+			c.writeLine(memcacheFlagErr)
+			return nil, false
+		}
+		if f[0] == 'b' {
+			m.base64 = true
+		}
+	}
+	if m.base64 {
+		key, err := base64.StdEncoding.DecodeString(m.key)
+		if err != nil {
+			c.writeLine("CLIENT_ERROR error decoding key")
+			return nil, false
+		}
+		m.key = string(key)
+	}
+	if !validKey(m.key) && !(m.base64 && len(m.key) > 0 && len(m.key) <= memcacheMaxKey) {
+		c.writeLine(memcacheFormatErr)
+		return nil, false
+	}
+	return m, true
+}
+
+// memcacheMetaGet serves mg <key> <flags>*. It supports the b, c,
+// f, k, O, q, s, t, T and v flags.
+func (service *Service) memcacheMetaGet(c *memcacheConn, args []string) {
+	m, ok := c.parseMeta(args, "bcfkOqstTv")
+	if !ok {
+		return
+	}
+	_, quiet := m.has('q')
+	miss := func() {
+		if !quiet {
+			c.writeLine("EN")
+		}
+	}
+
+	if token, ok := m.has('T'); ok {
+		exptime, err := strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			c.writeLine("CLIENT_ERROR bad token in command line format")
+			return
+		}
+		ttl, expired := memcacheTTL(exptime)
+		res := service.executeExpiring(base.STORE_TOUCH, request.NewRequestFromValues(m.key, nil, ttl), expired)
+		if failed(res) {
+			c.writeFailure(res)
+			return
+		} else if res.Status != 1 {
+			miss()
+			return
+		}
+	}
+
+	res := service.executeKey(base.STORE_GETS, request.NewRequestFromValues(m.key, nil, -1))
+	if failed(res) {
+		c.writeFailure(res)
+		return
+	} else if res.Status != 1 {
+		miss()
+		return
+	}
+	data := valueBytes(res.Gobj.Value)
+	flags := m.returned(map[byte]string{
+		'c': strconv.FormatUint(res.Version, 10),
+		'f': strconv.FormatUint(uint64(res.Gobj.Flags), 10),
+		's': strconv.Itoa(len(data)),
+		't': strconv.FormatInt(res.Gobj.TTL, 10),
+	})
+	if _, ok := m.has('v'); !ok {
+		c.writeLine("HD" + flags)
+		return
+	}
+	c.writeLine("VA " + strconv.Itoa(len(data)) + flags)
+	c.w.Write(data)
+	c.w.WriteString("\r\n")
+}
+
+// memcacheMetaSet serves ms <key> <datalen> <flags>* followed by a
+// data block. It supports the b, C, F, k, M, O, q and T flags, and
+// the E (add), R (replace) and S (set) modes. It returns false if
+// the data block could not be read.
+func (service *Service) memcacheMetaSet(c *memcacheConn, args []string) bool {
+	if len(args) < 2 {
+		c.writeLine(memcacheFormatErr)
+		return true
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size < 0 {
+		c.writeLine(memcacheFormatErr)
+		return true
+	}
+	data, ok := c.readValue(size)
+	if data == nil {
+		return ok
+	}
+	m, ok := c.parseMeta(append([]string{args[0]}, args[2:]...), "bCFkMOqT")
+	if !ok {
+		return true
+	}
+
+	req := request.NewRequestFromValues(m.key, data, -1)
+	expired := false
+	if token, ok := m.has('T'); ok {
+		exptime, err := strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			c.writeLine("CLIENT_ERROR bad token in command line format")
+			return true
+		}
+		req.Gobj.TTL, expired = memcacheTTL(exptime)
+	}
+	if token, ok := m.has('F'); ok {
+		flags, err := strconv.ParseUint(token, 10, 32)
+		if err != nil {
+			c.writeLine("CLIENT_ERROR bad token in command line format")
+			return true
+		}
+		req.Gobj.Flags = uint32(flags)
+	}
+
+	cmd := base.STORE_PUT
+	if mode, ok := m.has('M'); ok {
+		switch strings.ToUpper(mode) {
+		case "S":
+		case "E":
+			cmd = base.STORE_ADD
+		case "R":
+			cmd = base.STORE_REPLACE
+		default:
+			c.writeLine("CLIENT_ERROR invalid mode for ms")
+			return true
+		}
+	}
+	if token, ok := m.has('C'); ok {
+		if req.Cas, err = strconv.ParseUint(token, 10, 64); err != nil {
+			c.writeLine("CLIENT_ERROR bad token in command line format")
+			return true
+		}
+		cmd = base.STORE_CAS
+	}
+
+	res := service.executeExpiring(cmd, req, expired)
+	_, quiet := m.has('q')
+	flags := m.returned(nil)
+	switch {
+	case failed(res):
+		c.writeFailure(res)
+	case res.Status == 1:
+		c.reply(quiet, "HD"+flags)
+	case res.Message == base.EXISTS:
+		c.writeLine("EX" + flags)
+	case res.Message == lru.NOT_FOUND:
+		c.writeLine("NF" + flags)
+	default:
+		c.writeLine("NS" + flags)
+	}
+	return true
+}
+
+// memcacheMetaDelete serves md <key> <flags>*. It supports the b,
+// k, O and q flags, and q leaves out both HD and NF.
+func (service *Service) memcacheMetaDelete(c *memcacheConn, args []string) {
+	m, ok := c.parseMeta(args, "bkOq")
+	if !ok {
+		return
+	}
+	res := service.executeKey(base.STORE_DELETE, request.NewRequestFromValues(m.key, nil, -1))
+	_, quiet := m.has('q')
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status == 1 {
+		c.reply(quiet, "HD"+m.returned(nil))
+	} else {
+		c.reply(quiet, "NF"+m.returned(nil))
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// memcacheClient is a client connected to a node over the
+// memcached protocol.
+type memcacheClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newMemcacheClient(service *Service) *memcacheClient {
+	client, server := net.Pipe()
+	go service.serveMemcacheConn(server)
+	return &memcacheClient{conn: client, r: bufio.NewReader(client)}
+}
+
+// send writes a request and reads the given number of reply lines
+func (c *memcacheClient) send(t *testing.T, req string, lines int) []string {
+	c.conn.SetDeadline(time.Now().Add(20 * time.Second))
+	go c.conn.Write([]byte(req))
+	var reply []string
+	for i := 0; i < lines; i++ {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the reply to %q: %s", req, err)
+		}
+		reply = append(reply, line)
+	}
+	return reply
+}
+
+func TestMemcacheForwardsBinaryValues(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 2)
+	defer stopCluster(nodes)
+
+	// A value that is not valid UTF-8 is stored unchanged through
+	// a follower, which forwards the write to the leader.
+	value := []byte{0xff, 0xfe, 0x00, 0x80, 'a'}
+	follower := newMemcacheClient(nodes[1].service)
+	defer follower.conn.Close()
+	reply := follower.send(t, "set bin 5 0 5\r\n"+string(value)+"\r\n", 1)
+	utils.AssertEqual(t, reply[0], "STORED\r\n", "")
+
+	x := nodes[0].store(0).Execute("gets", request.NewRequestFromValues("bin", nil, -1))
+	utils.AssertEqual(t, reflect.DeepEqual(x.Gobj.Value, value), true, "")
+	utils.AssertEqual(t, x.Gobj.Flags, uint32(5), "")
+
+	// The follower reads it back once the write is replicated
+	replicated := waitFor(10*time.Second, func() bool {
+		reply := follower.send(t, "get bin\r\n", 1)
+		if reply[0] == "END\r\n" {
+			return false
+		}
+		reply = append(reply, follower.send(t, "", 2)...)
+		return reflect.DeepEqual(reply, []string{"VALUE bin 5 5\r\n", string(value) + "\r\n", "END\r\n"})
+	})
+	utils.AssertEqual(t, replicated, true, "")
+
+	// Meta commands store it unchanged as well
+	reply = follower.send(t, "ms bin2 5 F3\r\n"+string(value)+"\r\n", 1)
+	utils.AssertEqual(t, reply[0], "HD\r\n", "")
+	x = nodes[0].store(0).Execute("get", request.NewRequestFromValues("bin2", nil, -1))
+	utils.AssertEqual(t, reflect.DeepEqual(x.Gobj.Value, value), true, "")
+}
+
+func TestMemcacheCommands(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 1)
+	defer stopCluster(nodes)
+	client := newMemcacheClient(nodes[0].service)
+	defer client.conn.Close()
+
+	// cas only stores if the key is unchanged since gets read it
+	reply := client.send(t, "set England 3 0 6\r\nLondon\r\ngets England\r\n", 4)
+	utils.AssertEqual(t, reply[0], "STORED\r\n", "")
+	utils.AssertEqual(t, reply[2], "London\r\n", "")
+	fields := strings.Fields(reply[1])
+	utils.AssertEqual(t, reflect.DeepEqual(fields[:4], []string{"VALUE", "England", "3", "6"}), true, "")
+	unique := fields[4]
+	reply = client.send(t, "cas England 0 0 5 "+unique+"\r\nLeeds\r\ncas England 0 0 4 "+unique+"\r\nYork\r\ncas Wales 0 0 7 1\r\nCardiff\r\n", 3)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"STORED\r\n", "EXISTS\r\n", "NOT_FOUND\r\n"}), true, "")
+
+	// add and replace depend on whether the key exists
+	reply = client.send(t, "add England 0 0 4\r\nYork\r\nreplace Wales 0 0 7\r\nCardiff\r\nadd Wales 0 0 7 noreply\r\nCardiff\r\nget England Wales Scotland\r\n", 7)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{
+		"NOT_STORED\r\n", "NOT_STORED\r\n", "VALUE England 0 5\r\n", "Leeds\r\n", "VALUE Wales 0 7\r\n", "Cardiff\r\n", "END\r\n",
+	}), true, "")
+
+	// incr and decr reply with the value they stored
+	reply = client.send(t, "set Counter 0 0 2\r\n10\r\nincr Counter 5\r\ndecr Counter 3\r\nincr England 1\r\nincr Ireland 1\r\nincr Counter x\r\n", 6)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{
+		"STORED\r\n", "15\r\n", "12\r\n",
+		"CLIENT_ERROR cannot increment or decrement non-numeric value\r\n",
+		"NOT_FOUND\r\n",
+		"CLIENT_ERROR invalid numeric delta argument\r\n",
+	}), true, "")
+
+	reply = client.send(t, "touch England 60\r\ntouch Ireland 60\r\ndelete Wales\r\ndelete Wales\r\n", 4)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"TOUCHED\r\n", "NOT_FOUND\r\n", "DELETED\r\n", "NOT_FOUND\r\n"}), true, "")
+
+	// Meta commands return the flags asked for
+	reply = client.send(t, "ms Scotland 9 F4 T60 Oabc\r\nEdinburgh\r\nmg Scotland v f s k Oxyz\r\nmg Ireland v\r\nmg Ireland v q\r\nmn\r\n", 5)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{
+		"HD Oabc\r\n", "VA 9 f4 s9 kScotland Oxyz\r\n", "Edinburgh\r\n", "EN\r\n", "MN\r\n",
+	}), true, "")
+	reply = client.send(t, "mg Scotland c t\r\n", 1)
+	fields = strings.Fields(reply[0])
+	utils.AssertEqual(t, fields[0], "HD", "")
+	ttl, _ := strconv.Atoi(fields[2][1:])
+	utils.AssertEqual(t, ttl > 55 && ttl <= 60, true, "")
+
+	// ms modes and compare-and-swap
+	cas := fields[1][1:]
+	reply = client.send(t, "ms Scotland 7 ME\r\nGlasgow\r\nms Ireland 6 MR\r\nDublin\r\nms Scotland 7 C"+cas+"\r\nGlasgow\r\nms Scotland 8 C"+cas+"\r\nAberdeen\r\nms Scotland 1 MX\r\na\r\n", 5)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{
+		"NS\r\n", "NS\r\n", "HD\r\n", "EX\r\n", "CLIENT_ERROR invalid mode for ms\r\n",
+	}), true, "")
+
+	// md leaves out its reply with q, and keys may be base64
+	reply = client.send(t, "md Scotland q\r\nmd Scotland\r\nmd RW5nbGFuZA== b k\r\nmg Scotland\r\nmn\r\n", 4)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"NF\r\n", "HD b kRW5nbGFuZA==\r\n", "EN\r\n", "MN\r\n"}), true, "")
+
+	reply = client.send(t, "mg Counter Z\r\nflush_all\r\nget Counter\r\nbogus\r\n", 4)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{memcacheFlagErr + "\r\n", "OK\r\n", "END\r\n", "ERROR\r\n"}), true, "")
+}
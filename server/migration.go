@@ -0,0 +1,367 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/valyala/fasthttp"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
+)
+
+const (
+	// migrationBatch is the number of key-value pairs
+	// moved between groups in a single log entry.
+	migrationBatch = 256
+
+	// migrationRetry is how long a migration waits before retrying
+	// a failed batch, and how often the leader of the meta group
+	// checks for migrations to resume.
+	migrationRetry = time.Second
+)
+
+// migrationCommands are the requests to move slots between groups.
+// Requests sent between nodes name their group in GroupHeader,
+// the others are for the meta group.
+var migrationCommands = map[string]bool{
+	"migrateSlot":    true,
+	"getMigrations":  true,
+	"exportSlot":     true,
+	"importEntries":  true,
+	"releaseEntries": true,
+}
+
+// entriesRequest is the body of a request to import
+// or release a batch of key-value pairs.
+type entriesRequest struct {
+	Entries []lru.Entry `json:"entries"`
+}
+
+// moved reports if a request for a key of a slot being moved from
+// the given group is for a key the group does not hold, and so must
+// be served by the group the slot is moving to. Such keys have either
+// been moved already, or are new and are written to the new group.
+func (service *Service) moved(store *base.Store, group int, cmd string, req request.CacheRequest) (int, bool) {
+	if !keyCommands[cmd] {
+		return 0, false
+	}
+	m, ok := service.node.Migrating(shard.Slot(req.Gobj.Key))
+	if !ok || m.From != group || store.Contains(req.Gobj.Key) {
+		return 0, false
+	}
+	return m.To, true
+}
+
+// handleMigrateSlot starts moving a slot to another data group.
+func (service *Service) handleMigrateSlot(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "slot", "to")
+	if !ok {
+		return badMembershipRequest(ctx, "migrateSlot requires a slot and to")
+	}
+	slot, err := strconv.Atoi(m["slot"])
+	if err != nil || slot < 0 || slot >= shard.SLOT_COUNT {
+		return badMembershipRequest(ctx, fmt.Sprintf("slot must be between 0 and %d", shard.SLOT_COUNT-1))
+	}
+	to, err := strconv.Atoi(m["to"])
+	if err != nil || !service.node.Sharded() || to < 1 || to > service.node.Shards {
+		return badMembershipRequest(ctx, "to must be a data group of a sharded cluster")
+	}
+	if _, ok := service.node.Migrating(slot); ok {
+		return badMembershipRequest(ctx, fmt.Sprintf("slot %d is already being moved", slot))
+	}
+	from := service.node.Owner(slot)
+	if from == to {
+		return badMembershipRequest(ctx, fmt.Sprintf("slot %d is already owned by group %d", slot, to))
+	}
+
+	migration := base.Migration{
+		Slot:  slot,
+		From:  from,
+		To:    to,
+		State: base.MIGRATION_COPYING,
+	}
+	if err := store.SetMigration(migration); err != nil {
+		return membershipResponse(ctx, store, err)
+	}
+	service.startMigration(migration)
+	return response.NewResponseFromValue(migration)
+}
+
+// handleGetMigrations returns the migration of every slot that has
+// been moved, or is being moved, as last applied by this node.
+func handleGetMigrations(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	return response.NewResponseFromValue(store.Migrations())
+}
+
+func handleExportSlot(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "slot", "count")
+	if !ok {
+		return badMembershipRequest(ctx, "exportSlot requires a slot and count")
+	}
+	slot, err := strconv.Atoi(m["slot"])
+	if err != nil {
+		return badMembershipRequest(ctx, "bad slot")
+	}
+	count, err := strconv.Atoi(m["count"])
+	if err != nil || count < 1 {
+		return badMembershipRequest(ctx, "bad count")
+	}
+	cursor := 0
+	if value, ok := m["cursor"]; ok {
+		if cursor, err = strconv.Atoi(value); err != nil || cursor < 0 {
+			return badMembershipRequest(ctx, "bad cursor")
+		}
+	}
+	return exportSlot(store, slot, count, cursor)
+}
+
+func handleImportEntries(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	var req entriesRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		return badMembershipRequest(ctx, "importEntries requires entries")
+	}
+	return importEntries(store, req.Entries)
+}
+
+func handleReleaseEntries(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	var req entriesRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		return badMembershipRequest(ctx, "releaseEntries requires entries")
+	}
+	return releaseEntries(store, req.Entries)
+}
+
+// exportSlot returns a batch of the key-value pairs of a slot, read
+// from cursor. They are read from the leader, which holds every
+// acknowledged write, so the slot is only found empty once every
+// key has been moved.
+func exportSlot(store *base.Store, slot int, count int, cursor int) response.CacheResponse {
+	if !store.IsLeader() {
+		return response.NewNotLeaderResponse(store.LeaderHTTPAddr())
+	}
+	batch := store.SlotEntries(slot, func(key string) bool {
+		return shard.Slot(key) == slot
+	}, cursor, count)
+	return response.NewResponseFromValue(batch)
+}
+
+func importEntries(store *base.Store, entries []lru.Entry) response.CacheResponse {
+	version, err := store.ImportEntries(entries)
+	return entriesResponse(store, version, err)
+}
+
+func releaseEntries(store *base.Store, entries []lru.Entry) response.CacheResponse {
+	release, err := store.ReleaseEntries(entries)
+	return entriesResponse(store, release, err)
+}
+
+// entriesResponse converts the result of moving a batch into a
+// response. Batches sent to a follower name the leader.
+func entriesResponse(store *base.Store, value interface{}, err error) response.CacheResponse {
+	if err == raft.ErrNotLeader {
+		return response.NewNotLeaderResponse(store.LeaderHTTPAddr())
+	} else if err != nil {
+		return response.NewResponseFromMessage(err.Error(), 0)
+	}
+	return response.NewResponseFromValue(value)
+}
+
+// startMigration runs a migration on this node, unless it already is.
+func (service *Service) startMigration(m base.Migration) {
+	service.mux.Lock()
+	if service.migrating[m.Slot] {
+		service.mux.Unlock()
+		return
+	}
+	service.migrating[m.Slot] = true
+	service.mux.Unlock()
+
+	go func() {
+		service.migrate(m)
+
+		service.mux.Lock()
+		delete(service.migrating, m.Slot)
+		service.mux.Unlock()
+	}()
+}
+
+// resumeMigrations runs, on the leader of the meta group, any
+// migration a previous leader did not finish, until the node
+// is closed.
+func (service *Service) resumeMigrations() {
+	meta := service.node.Meta()
+	ticker := time.NewTicker(migrationRetry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-meta.Done():
+			return
+		case <-ticker.C:
+		}
+		if !meta.IsLeader() {
+			continue
+		}
+		for _, m := range meta.Migrations() {
+			if m.State == base.MIGRATION_COPYING {
+				service.startMigration(m)
+			}
+		}
+	}
+}
+
+// migrate moves the key-value pairs of a slot to its new group in
+// batches, then moves the slot. Meanwhile the old group serves the
+// slot, and sends requests for keys it no longer holds on to the new
+// group. Key-value pairs written to the old group after the last
+// batch, by nodes yet to see the slot move, are moved once it has.
+// The migration stops if this node stops leading the meta group,
+// and is resumed by the next leader.
+func (service *Service) migrate(m base.Migration) {
+	meta := service.node.Meta()
+	log.Printf("moving slot %d from group %d to group %d...", m.Slot, m.From, m.To)
+	cursor := 0
+	for meta.IsLeader() {
+		n, err := service.moveBatch(&m, &cursor)
+		if err != nil {
+			log.Printf("failed to move a batch of slot %d: %s", m.Slot, err.Error())
+			time.Sleep(migrationRetry)
+			continue
+		}
+		if n == 0 && m.State == base.MIGRATION_COMPLETE {
+			log.Printf("moved slot %d to group %d, %d key-value pairs moved", m.Slot, m.To, m.Moved)
+			return
+		}
+		if n == 0 {
+			m.State = base.MIGRATION_COMPLETE
+		}
+		if err := meta.SetMigration(m); err != nil {
+			log.Printf("failed to record the migration of slot %d: %s", m.Slot, err.Error())
+			return
+		}
+	}
+}
+
+// moveBatch copies the next batch of the key-value pairs of a slot,
+// from cursor, to the new group, then removes those not written since
+// from the old group. It returns the number of key-value pairs in the
+// batch, which is 0 once a new scan of the old group finds none of
+// the slot.
+func (service *Service) moveBatch(m *base.Migration, cursor *int) (int, error) {
+	var batch base.SlotBatch
+	for {
+		from := *cursor
+		body, _ := json.Marshal(map[string]string{
+			"slot":   strconv.Itoa(m.Slot),
+			"count":  strconv.Itoa(migrationBatch),
+			"cursor": strconv.Itoa(from),
+		})
+		res := service.groupCall(m.From, "exportSlot", body, func(store *base.Store) response.CacheResponse {
+			return exportSlot(store, m.Slot, migrationBatch, from)
+		})
+		if err := decodeValue(res, &batch); err != nil {
+			*cursor = 0
+			return 0, err
+		}
+		*cursor = batch.Cursor
+		// The end of a scan is followed by a new scan, which
+		// finds the keys written since the last one
+		if len(batch.Entries) > 0 || from == 0 {
+			break
+		}
+	}
+	entries := batch.Entries
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	var version uint64
+	if err := service.moveEntries(m.To, "importEntries", entries, importEntries, &version); err != nil {
+		return 0, err
+	}
+	var release base.Release
+	if err := service.moveEntries(m.From, "releaseEntries", entries, releaseEntries, &release); err != nil {
+		return 0, err
+	}
+
+	// Key-value pairs deleted from the old group while they were
+	// copied are removed from the new group, unless written since.
+	if len(release.Missing) > 0 {
+		copies := make([]lru.Entry, 0, len(release.Missing))
+		for _, key := range release.Missing {
+			copies = append(copies, lru.Entry{Key: key, Version: version})
+		}
+		if err := service.moveEntries(m.To, "releaseEntries", copies, releaseEntries, &base.Release{}); err != nil {
+			return 0, err
+		}
+	}
+
+	m.Moved += release.Released
+	return len(entries), nil
+}
+
+// moveEntries sends a batch of key-value pairs to the leader of a
+// group to import or release, and decodes the result into value.
+func (service *Service) moveEntries(group int, cmd string, entries []lru.Entry, local func(*base.Store, []lru.Entry) response.CacheResponse, value interface{}) error {
+	body, err := json.Marshal(entriesRequest{Entries: entries})
+	if err != nil {
+		return err
+	}
+	res := service.groupCall(group, cmd, body, func(store *base.Store) response.CacheResponse {
+		return local(store, entries)
+	})
+	return decodeValue(res, value)
+}
+
+// decodeValue decodes the value of a successful response, which is
+// typed if served by this node and decoded JSON if by another.
+func decodeValue(res response.CacheResponse, value interface{}) error {
+	if res.Status != 1 {
+		if res.Error != "" {
+			return errors.New(res.Error)
+		}
+		return errors.New(res.Message)
+	}
+	b, err := json.Marshal(res.Gobj.Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, value)
+}
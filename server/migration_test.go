@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// keysOf returns keys whose slot is owned by the given group,
+// all of a single slot if one is set.
+func keysOf(node *shard.Node, group int, slot int, count int) []string {
+	keys := []string{}
+	for i := 0; len(keys) < count; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if node.Group(key) == group && (slot < 0 || shard.Slot(key) == slot) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func putBody(key string, value string) string {
+	return fmt.Sprintf(`{"Gobj": {"Key": %q, "Value": %q, "TTL": "-1"}}`, key, value)
+}
+
+func getBody(key string) string {
+	return fmt.Sprintf(`{"Gobj": {"Key": %q}}`, key)
+}
+
+func TestSlotRoutingAndMigration(t *testing.T) {
+	conf := testConfig(2)
+	nodes := startCluster(t, conf, 1)
+	defer stopCluster(nodes)
+	seed := nodes[0]
+
+	// The second node only hosts the first group
+	n := startNodeGroups(t, conf, "node1", false, []int{1})
+	defer n.close()
+	seed.join(t, n, "node1")
+
+	// Requests for a group a node does not host are routed to
+	// a node that does
+	routed := keysOf(n.node, 2, -1, 1)[0]
+	code, res := send(t, n.addr, "put", putBody(routed, "London"), nil)
+	utils.AssertEqual(t, code, http.StatusOK, "")
+	utils.AssertEqual(t, res.Status, int32(1), "")
+	utils.AssertEqual(t, seed.store(2).Contains(routed), true, "")
+	utils.AssertEqual(t, seed.store(1).Contains(routed), false, "")
+	_, res = send(t, n.addr, "get", getBody(routed), nil)
+	utils.AssertEqual(t, res.Gobj.Value, "London", "")
+
+	// A request already routed is not routed again
+	_, res = send(t, n.addr, "get", getBody(routed), map[string]string{RoutedHeader: seed.addr, GroupHeader: "2"})
+	utils.AssertEqual(t, res.Error, response.WRONG_GROUP_ERR, "")
+
+	// A slot moves with its keys to another group
+	first := keysOf(n.node, 1, -1, 1)[0]
+	slot := shard.Slot(first)
+	keys := keysOf(n.node, 1, slot, 3)
+	for _, key := range keys {
+		_, res = send(t, n.addr, "put", putBody(key, "value of "+key), nil)
+		utils.AssertEqual(t, res.Status, int32(1), "")
+	}
+	code, res = send(t, n.addr, "migrateSlot", fmt.Sprintf(`{"slot": "%d", "to": "2"}`, slot), nil)
+	utils.AssertEqual(t, code, http.StatusOK, "")
+	utils.AssertEqual(t, res.Status, int32(1), "")
+
+	moved := waitFor(10*time.Second, func() bool {
+		m, ok := n.node.Meta().SlotMigration(slot)
+		return ok && m.State == base.MIGRATION_COMPLETE && n.node.Owner(slot) == 2
+	})
+	utils.AssertEqual(t, moved, true, "")
+	m, _ := seed.node.Meta().SlotMigration(slot)
+	utils.AssertEqual(t, m.Moved, len(keys), "")
+	for _, key := range keys {
+		utils.AssertEqual(t, seed.store(1).Contains(key), false, "")
+		utils.AssertEqual(t, seed.store(2).Contains(key), true, "")
+
+		// The node that no longer hosts the slot routes its keys
+		_, res = send(t, n.addr, "get", getBody(key), nil)
+		utils.AssertEqual(t, res.Gobj.Value, "value of "+key, "")
+	}
+
+	// A slot is only moved once at a time, to a group that exists
+	_, res = send(t, n.addr, "migrateSlot", fmt.Sprintf(`{"slot": "%d", "to": "2"}`, slot), nil)
+	utils.AssertEqual(t, res.Status, int32(0), "")
+	code, _ = send(t, n.addr, "migrateSlot", fmt.Sprintf(`{"slot": "%d", "to": "3"}`, slot), nil)
+	utils.AssertEqual(t, code, http.StatusBadRequest, "")
+}
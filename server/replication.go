@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
+)
+
+const (
+	// replicationInterval is how often the leader of a group checks
+	// for writes to ship to the standby cluster once it has shipped
+	// every write.
+	replicationInterval = 100 * time.Millisecond
+
+	// replicationMaxBackoff bounds how long the leader of a group
+	// waits before shipping again after failing to, as the wait
+	// doubles after each failure.
+	replicationMaxBackoff = 10 * time.Second
+)
+
+// replicationCommands are the requests of another cluster replicating
+// to this one, which are served by the meta group.
+var replicationCommands = map[string]bool{
+	"replicate":     true,
+	"getCheckpoint": true,
+}
+
+// replicationRequest is the body of a replicate request.
+type replicationRequest struct {
+	// Batch is encoded by base.EncodeReplicationBatch
+	Batch []byte `json:"batch"`
+}
+
+// checkpointResponse is a response holding the position
+// of the last batch replicated from a cluster.
+type checkpointResponse struct {
+	Gobj struct {
+		Value uint64
+	}
+	Status int32
+	Error  string
+}
+
+// startReplication ships the writes of every group this node
+// hosts to the standby cluster, while it leads the group, until
+// the node is closed.
+func (service *Service) startReplication() {
+	for _, g := range service.node.DataGroupsHosted() {
+		service.wg.Add(1)
+		go func(g int) {
+			defer service.wg.Done()
+			service.replicate(g)
+		}(g)
+	}
+}
+
+// replicate ships the writes committed in a group to the standby
+// cluster, in batches, while this node leads the group. A new
+// leader, or a leader that failed to ship a batch, resumes from the
+// position the standby recorded, so no write is lost when the leader
+// of either cluster changes, though some may be shipped twice. It
+// returns once the store of the group is closed.
+func (service *Service) replicate(group int) {
+	store, _ := service.node.Store(group)
+	source := fmt.Sprintf("%s/%d", store.Conf.ReplicationSource, group)
+	var position uint64
+	resumed := false
+	backoff := replicationInterval
+	for {
+		if !store.IsLeader() {
+			resumed = false
+			if !pause(store, replicationInterval) {
+				return
+			}
+			continue
+		}
+
+		var err error
+		if !resumed {
+			position, err = service.checkpoint(store, source)
+			resumed = err == nil
+		}
+		caughtUp := true
+		if err == nil {
+			position, caughtUp, err = service.shipBatch(store, source, position)
+		}
+		if err != nil {
+			log.Printf("failed to replicate group %d to the standby cluster: %s", group, err.Error())
+			resumed = false
+			if !pause(store, backoff) {
+				return
+			}
+			if backoff *= 2; backoff > replicationMaxBackoff {
+				backoff = replicationMaxBackoff
+			}
+			continue
+		}
+		backoff = replicationInterval
+		if caughtUp && !pause(store, replicationInterval) {
+			return
+		}
+	}
+}
+
+// pause waits for the given time, and reports false if the
+// store was closed meanwhile.
+func pause(store *base.Store, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-store.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// shipBatch ships the writes of the log entries after position, or
+// the whole cache if the log no longer holds them. It returns the
+// position of the batch, and if it held every write to ship.
+func (service *Service) shipBatch(store *base.Store, source string, position uint64) (uint64, bool, error) {
+	limit := int(store.Conf.ReplicationBatchSize)
+	writes, next, err := store.ReplicationLog(position, limit)
+	if err == base.ErrNotInLog {
+		return service.shipSnapshot(store, source)
+	} else if err != nil || next == position {
+		return position, true, err
+	}
+
+	batch := &base.ReplicationBatch{Source: source, Position: next, Writes: writes}
+	if err := service.ship(store, batch); err != nil {
+		return position, false, err
+	}
+	return next, len(writes) < limit, nil
+}
+
+// shipSnapshot ships every key-value pair in the cache. The position
+// is only sent with the last batch, so a standby that misses a batch
+// is sent them all again.
+func (service *Service) shipSnapshot(store *base.Store, source string) (uint64, bool, error) {
+	writes, position := store.ReplicationSnapshot()
+	log.Printf("sending the %d key-value pairs of %s to the standby cluster...", len(writes), source)
+
+	limit := int(store.Conf.ReplicationBatchSize)
+	for start := 0; ; start += limit {
+		end := start + limit
+		if end >= len(writes) {
+			end = len(writes)
+		}
+		batch := &base.ReplicationBatch{Source: source, Writes: writes[start:end]}
+		if end == len(writes) {
+			batch.Position = position
+		}
+		if err := service.ship(store, batch); err != nil {
+			return 0, false, err
+		}
+		if end == len(writes) {
+			return position, false, nil
+		}
+	}
+}
+
+// ship sends a batch to the standby cluster.
+func (service *Service) ship(store *base.Store, batch *base.ReplicationBatch) error {
+	data, err := base.EncodeReplicationBatch(batch)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(replicationRequest{Batch: data})
+	if err != nil {
+		return err
+	}
+
+	var res response.CacheResponse
+	if err := service.callStandby(store, "replicate", body, &res); err != nil {
+		return err
+	}
+	if res.Status != 1 {
+		return fmt.Errorf("the standby cluster did not apply the batch: %s %s", res.Error, res.Message)
+	}
+	return nil
+}
+
+// checkpoint returns the position of the last batch of the source
+// the standby cluster applied.
+func (service *Service) checkpoint(store *base.Store, source string) (uint64, error) {
+	body, _ := json.Marshal(map[string]string{"source": source})
+	var res checkpointResponse
+	if err := service.callStandby(store, "getCheckpoint", body, &res); err != nil {
+		return 0, err
+	}
+	if res.Status != 1 {
+		return 0, fmt.Errorf("the standby cluster did not return the checkpoint of %s: %s", source, res.Error)
+	}
+	return res.Gobj.Value, nil
+}
+
+// callStandby sends a request to the nodes of the standby cluster
+// in turn, until one of them answers it.
+func (service *Service) callStandby(store *base.Store, cmd string, body []byte, res interface{}) error {
+	err := fmt.Errorf("no standby cluster is set")
+	for _, addr := range store.Conf.ReplicationTargets {
+		if err = service.call(addr, cmd, "", 0, body, forwardTimeout, res); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// handleReplicate applies a batch of writes shipped from another
+// cluster. In a sharded cluster each write is applied in the group
+// that owns its key, and the position recorded in the meta group
+// once every write is applied.
+func (service *Service) handleReplicate(ctx *fasthttp.RequestCtx, store *base.Store, group int) response.CacheResponse {
+	var req replicationRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		return badMembershipRequest(ctx, "replicate requires a batch")
+	}
+	batch, err := base.DecodeReplicationBatch(req.Batch)
+	if err != nil {
+		return badMembershipRequest(ctx, fmt.Sprintf("replicate requires a batch: %s", err.Error()))
+	}
+
+	if service.node.Sharded() && group == shard.META_GROUP {
+		if res := service.replicateToGroups(ctx, batch); res.Status != 1 {
+			return res
+		}
+		batch = &base.ReplicationBatch{Source: batch.Source, Position: batch.Position}
+	}
+	return membershipResponse(ctx, store, store.Replicate(batch))
+}
+
+// replicateToGroups applies the writes of a batch in the data groups
+// that own their keys, in order, and a flush in every data group.
+func (service *Service) replicateToGroups(ctx *fasthttp.RequestCtx, batch *base.ReplicationBatch) response.CacheResponse {
+	writes := make(map[int][]base.Command)
+	for _, w := range batch.Writes {
+		if w.Cmd == base.STORE_FLUSH {
+			for _, g := range service.node.DataGroups() {
+				writes[g] = append(writes[g], w)
+			}
+		} else {
+			g := service.node.Group(w.Args.Gobj.Key)
+			writes[g] = append(writes[g], w)
+		}
+	}
+
+	for g, w := range writes {
+		part := &base.ReplicationBatch{Source: batch.Source, Writes: w}
+		data, err := base.EncodeReplicationBatch(part)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusInternalServerError)
+			return response.NewResponseFromMessage(err.Error(), 0)
+		}
+		body, _ := json.Marshal(replicationRequest{Batch: data})
+		res := service.groupCall(g, "replicate", body, func(store *base.Store) response.CacheResponse {
+			return membershipResponse(ctx, store, store.Replicate(part))
+		})
+		if res.Status != 1 {
+			return res
+		}
+	}
+	return response.NewResponseFromMessage("OK", 1)
+}
+
+func handleGetCheckpoint(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "source")
+	if !ok {
+		return badMembershipRequest(ctx, "getCheckpoint requires a source")
+	}
+	return response.NewResponseFromValue(store.Checkpoint(m["source"]))
+}
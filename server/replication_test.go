@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestReplicationToStandby(t *testing.T) {
+	conf := testConfig(1)
+	issueTestCerts(t, &conf)
+	standby := startCluster(t, conf, 1)
+	defer stopCluster(standby)
+
+	// The standby only accepts the writes of nodes whose
+	// certificate is signed by the CA it trusts
+	conf.ReplicationTargets = []string{standby[0].addr}
+	primary := startCluster(t, conf, 2)
+	defer stopCluster(primary)
+
+	// Writes made through a follower are shipped by the leader,
+	// counters as the value they stored
+	client := newMemcacheClient(primary[1].service)
+	defer client.conn.Close()
+	reply := client.send(t, "set England 0 0 6\r\nLondon\r\n", 1)
+	utils.AssertEqual(t, reply[0], "STORED\r\n", "")
+	reply = client.send(t, "set Counter 0 0 2\r\n10\r\n", 1)
+	utils.AssertEqual(t, reply[0], "STORED\r\n", "")
+	reply = client.send(t, "incr Counter 5\r\n", 1)
+	utils.AssertEqual(t, reply[0], "15\r\n", "")
+	reply = client.send(t, "delete England\r\n", 1)
+	utils.AssertEqual(t, reply[0], "DELETED\r\n", "")
+
+	store := standby[0].store(0)
+	replicated := waitFor(10*time.Second, func() bool {
+		x := store.Execute("get", request.NewRequestFromValues("Counter", nil, -1))
+		return x.Gobj.Value == "15" && !store.Contains("England")
+	})
+	utils.AssertEqual(t, replicated, true, "")
+	checkpoint := store.Checkpoint("primary/0")
+	utils.AssertEqual(t, checkpoint > 0, true, "")
+
+	// The leader resumes from the checkpoint of the standby, so
+	// nothing is applied twice
+	reply = client.send(t, "incr Counter 1\r\n", 1)
+	utils.AssertEqual(t, reply[0], "16\r\n", "")
+	replicated = waitFor(10*time.Second, func() bool {
+		x := store.Execute("get", request.NewRequestFromValues("Counter", nil, -1))
+		return reflect.DeepEqual(x.Gobj.Value, "16")
+	})
+	utils.AssertEqual(t, replicated, true, "")
+	utils.AssertEqual(t, store.Checkpoint("primary/0") > checkpoint, true, "")
+}
@@ -0,0 +1,573 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/cache"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+const (
+	// respMaxBulk is the largest bulk string, and respMaxArgs the
+	// most arguments, a RESP command may have, as in Redis.
+	respMaxBulk = 512 * 1024 * 1024
+	respMaxArgs = 1024 * 1024
+
+	// respPreallocArgs is the most arguments reserved before they
+	// arrive, so a client cannot reserve memory it never sends.
+	respPreallocArgs = 64
+
+	respBufferSize = 16 * 1024
+)
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// respConn is a connection of a Redis client. Replies are buffered
+// and only flushed once every command the client has sent has been
+// served, so pipelined commands are answered together.
+type respConn struct {
+	r     *bufio.Reader
+	w     *bufio.Writer
+
+	// proto is the RESP version of the replies, 2 unless the
+	// client switched to 3 with HELLO
+	proto int
+}
+
+// ServeRESP serves Redis clients on addr, speaking RESP2 and RESP3.
+// Their commands are served by the cluster as the HTTP requests
+// they map onto would be.
+func (service *Service) ServeRESP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving RESP on %s...", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go service.serveRESPConn(conn)
+	}
+}
+
+func (service *Service) serveRESPConn(conn net.Conn) {
+	defer conn.Close()
+	c := &respConn{
+		r:     bufio.NewReaderSize(conn, respBufferSize),
+		w:     bufio.NewWriterSize(conn, respBufferSize),
+		proto: 2,
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				c.writeError(fmt.Sprintf("ERR %s", err))
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if !service.serveRESPCommand(c, args) {
+			c.w.Flush()
+			return
+		}
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command, sent either as an array of bulk
+// strings or inline as words separated by spaces.
+func (c *respConn) readCommand() ([][]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		fields := bytes.Fields(line)
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = append([]byte(nil), f...)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > respMaxArgs {
+		return nil, fmt.Errorf("%s: invalid multibulk length", errRESPProtocol)
+	}
+	// As in Redis, an empty or null array is an empty command
+	if n <= 0 {
+		return nil, nil
+	}
+	prealloc := n
+	if prealloc > respPreallocArgs {
+		prealloc = respPreallocArgs
+	}
+	args := make([][]byte, 0, prealloc)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%s: expected '$', got '%s'", errRESPProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > respMaxBulk {
+			return nil, fmt.Errorf("%s: invalid bulk length", errRESPProtocol)
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, arg); err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, fmt.Errorf("%s: invalid bulk terminator", errRESPProtocol)
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a line without its CRLF. The returned slice is only
+// valid until the next read.
+func (c *respConn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%s: too big inline request", errRESPProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+func (c *respConn) writeSimple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) writeError(s string) {
+	c.w.WriteString("-" + s + "\r\n")
+}
+
+func (c *respConn) writeInteger(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *respConn) writeBulk(b []byte) {
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+func (c *respConn) writeNull() {
+	if c.proto == 3 {
+		c.w.WriteString("_\r\n")
+	} else {
+		c.w.WriteString("$-1\r\n")
+	}
+}
+
+func (c *respConn) writeArrayHeader(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMapHeader starts a map of n pairs, which in RESP2 is an
+// array of its keys and values.
+func (c *respConn) writeMapHeader(n int) {
+	if c.proto == 3 {
+		c.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		c.writeArrayHeader(2 * n)
+	}
+}
+
+// writeFailure replies with the error of a failed response.
+func (c *respConn) writeFailure(res response.CacheResponse) {
+	if res.Error != "" {
+		c.writeError(fmt.Sprintf("ERR %s: %s", res.Error, res.Message))
+	} else {
+		c.writeError(fmt.Sprintf("ERR %s", res.Message))
+	}
+}
+
+// failed reports if a response is an error, rather than a command
+// that found nothing to do, such as a get of a missing key.
+func failed(res response.CacheResponse) bool {
+	return res.Error != "" || res.Status > 1
+}
+
+func wrongArity(cmd string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd)
+}
+
+const (
+	respSyntaxErr  = "ERR syntax error"
+	respIntegerErr = "ERR value is not an integer or out of range"
+)
+
+// serveRESPCommand serves a command and writes its reply. It returns
+// false if the connection should be closed.
+func (service *Service) serveRESPCommand(c *respConn, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+
+	switch name {
+	case "ping":
+		switch len(args) {
+		case 0:
+			c.writeSimple("PONG")
+		case 1:
+			c.writeBulk(args[0])
+		default:
+			c.writeError(wrongArity(name))
+		}
+	case "echo":
+		if len(args) != 1 {
+			c.writeError(wrongArity(name))
+			break
+		}
+		c.writeBulk(args[0])
+	case "hello":
+		service.respHello(c, args)
+	case "select":
+		if len(args) != 1 {
+			c.writeError(wrongArity(name))
+		} else if string(args[0]) != "0" {
+			c.writeError("ERR DB index is out of range")
+		} else {
+			c.writeSimple("OK")
+		}
+	case "quit":
+		c.writeSimple("OK")
+		return false
+	case "get":
+		service.respGet(c, args)
+	case "set":
+		service.respSet(c, args)
+	case "del":
+		service.respDel(c, args)
+	case "exists":
+		service.respExists(c, args)
+	case "ttl":
+		service.respTTL(c, args)
+	case "expire":
+		service.respExpire(c, args)
+	case "flushall":
+		service.respFlushAll(c, args)
+	case "dbsize":
+		service.respDBSize(c, args)
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	return true
+}
+
+// respHello switches the connection to the RESP version asked
+// for, if any, and describes the server.
+func (service *Service) respHello(c *respConn, args [][]byte) {
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			c.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			c.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		if len(args) > 1 {
+			c.writeError(respSyntaxErr)
+			return
+		}
+		c.proto = proto
+	}
+
+	mode := "standalone"
+	if service.node.Sharded() {
+		mode = "cluster"
+	}
+	role := "replica"
+	if service.node.Meta().IsLeader() {
+		role = "master"
+	}
+	c.writeMapHeader(7)
+	c.writeBulk([]byte("server"))
+	c.writeBulk([]byte("ghostdb"))
+	c.writeBulk([]byte("version"))
+	c.writeBulk([]byte(ServerVersion))
+	c.writeBulk([]byte("proto"))
+	c.writeInteger(int64(c.proto))
+	c.writeBulk([]byte("id"))
+	c.writeInteger(0)
+	c.writeBulk([]byte("mode"))
+	c.writeBulk([]byte(mode))
+	c.writeBulk([]byte("role"))
+	c.writeBulk([]byte(role))
+	c.writeBulk([]byte("modules"))
+	c.writeArrayHeader(0)
+}
+
+func (service *Service) respGet(c *respConn, args [][]byte) {
+	if len(args) != 1 {
+		c.writeError(wrongArity("get"))
+		return
+	}
+	res := service.executeKey(base.STORE_GET, request.NewRequestFromValues(string(args[0]), nil, -1))
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status != 1 {
+		c.writeNull()
+	} else {
+		c.writeBulk(valueBytes(res.Gobj.Value))
+	}
+}
+
+// respSet writes a key-value pair, only if the key is missing with
+// NX and only if it exists with XX. A TTL given in milliseconds is
+// rounded up to whole seconds.
+func (service *Service) respSet(c *respConn, args [][]byte) {
+	if len(args) < 2 {
+		c.writeError(wrongArity("set"))
+		return
+	}
+	cmd := base.STORE_PUT
+	ttl := int64(-1)
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "nx", "xx":
+			if cmd != base.STORE_PUT {
+				c.writeError(respSyntaxErr)
+				return
+			}
+			cmd = base.STORE_ADD
+			if opt == "xx" {
+				cmd = base.STORE_REPLACE
+			}
+		case "ex", "px":
+			if ttl != -1 || i+1 == len(args) {
+				c.writeError(respSyntaxErr)
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				c.writeError(respIntegerErr)
+				return
+			}
+			if n <= 0 {
+				c.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+			if ttl = n; opt == "px" {
+				ttl = (n + 999) / 1000
+			}
+		default:
+			c.writeError(respSyntaxErr)
+			return
+		}
+	}
+
+	res := service.executeKey(cmd, request.NewRequestFromValues(string(args[0]), args[1], ttl))
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status != 1 && cmd != base.STORE_PUT {
+		c.writeNull()
+	} else if res.Status != 1 {
+		c.writeFailure(res)
+	} else {
+		c.writeSimple("OK")
+	}
+}
+
+func (service *Service) respDel(c *respConn, args [][]byte) {
+	if len(args) == 0 {
+		c.writeError(wrongArity("del"))
+		return
+	}
+	var deleted int64
+	for _, key := range args {
+		res := service.executeKey(base.STORE_DELETE, request.NewRequestFromValues(string(key), nil, -1))
+		if failed(res) {
+			c.writeFailure(res)
+			return
+		}
+		if res.Status == 1 {
+			deleted++
+		}
+	}
+	c.writeInteger(deleted)
+}
+
+// respExists counts the keys that exist, reading their TTL so
+// they are not counted as used.
+func (service *Service) respExists(c *respConn, args [][]byte) {
+	if len(args) == 0 {
+		c.writeError(wrongArity("exists"))
+		return
+	}
+	var found int64
+	for _, key := range args {
+		res := service.executeKey(base.STORE_TTL, request.NewRequestFromValues(string(key), nil, -1))
+		if failed(res) {
+			c.writeFailure(res)
+			return
+		}
+		if res.Status == 1 {
+			found++
+		}
+	}
+	c.writeInteger(found)
+}
+
+func (service *Service) respTTL(c *respConn, args [][]byte) {
+	if len(args) != 1 {
+		c.writeError(wrongArity("ttl"))
+		return
+	}
+	res := service.executeKey(base.STORE_TTL, request.NewRequestFromValues(string(args[0]), nil, -1))
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status != 1 {
+		c.writeInteger(-2)
+	} else {
+		c.writeInteger(respInteger(res.Gobj.Value))
+	}
+}
+
+// respExpire sets the TTL of a key, deleting it if the TTL is not
+// positive, and replies 1 if the key existed.
+func (service *Service) respExpire(c *respConn, args [][]byte) {
+	if len(args) != 2 {
+		c.writeError(wrongArity("expire"))
+		return
+	}
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.writeError(respIntegerErr)
+		return
+	}
+	cmd := base.STORE_TOUCH
+	if seconds <= 0 {
+		cmd = base.STORE_DELETE
+	}
+	res := service.executeKey(cmd, request.NewRequestFromValues(string(args[0]), nil, seconds))
+	if failed(res) {
+		c.writeFailure(res)
+	} else if res.Status == 1 {
+		c.writeInteger(1)
+	} else {
+		c.writeInteger(0)
+	}
+}
+
+// respFlushAll flushes every data group of the cluster. The ASYNC
+// and SYNC modes are accepted, and both flush synchronously.
+func (service *Service) respFlushAll(c *respConn, args [][]byte) {
+	if len(args) > 1 {
+		c.writeError(respSyntaxErr)
+		return
+	}
+	if len(args) == 1 {
+		if mode := strings.ToLower(string(args[0])); mode != "async" && mode != "sync" {
+			c.writeError(respSyntaxErr)
+			return
+		}
+	}
+	if res := service.flushAll(); res.Status != 1 {
+		c.writeFailure(res)
+		return
+	}
+	c.writeSimple("OK")
+}
+
+// respDBSize counts the keys of every data group of the cluster, as
+// held by this node for the groups it hosts.
+func (service *Service) respDBSize(c *respConn, args [][]byte) {
+	if len(args) != 0 {
+		c.writeError(wrongArity("dbsize"))
+		return
+	}
+	body, _ := json.Marshal(request.NewEmptyRequest())
+	var keys int64
+	for _, g := range service.node.DataGroups() {
+		res := service.groupCall(g, base.STORE_NODE_SIZE, body, func(store *base.Store) response.CacheResponse {
+			return response.NewResponseFromValue(store.CacheSize())
+		})
+		if failed(res) || res.Status != 1 {
+			c.writeFailure(res)
+			return
+		}
+		switch size := res.Gobj.Value.(type) {
+		case cache.NodeSize:
+			keys += int64(size.Keys)
+		case map[string]interface{}:
+			keys += respInteger(size["Keys"])
+		}
+	}
+	c.writeInteger(keys)
+}
+
+// respInteger returns an integer value, which is a float64 if it
+// was decoded from the JSON response of another node.
+func respInteger(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// respClient is a client connected to a node over RESP.
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newRESPClient(service *Service) *respClient {
+	client, server := net.Pipe()
+	go service.serveRESPConn(server)
+	return &respClient{conn: client, r: bufio.NewReader(client)}
+}
+
+// command encodes a command as an array of bulk strings
+func command(args ...string) string {
+	s := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		s += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return s
+}
+
+// send writes a request and reads the given number of reply lines
+func (c *respClient) send(t *testing.T, req string, lines int) []string {
+	c.conn.SetDeadline(time.Now().Add(20 * time.Second))
+	go c.conn.Write([]byte(req))
+	var reply []string
+	for i := 0; i < lines; i++ {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the reply to %q: %s", req, err)
+		}
+		reply = append(reply, line)
+	}
+	return reply
+}
+
+func TestRESPForwardsBinaryValues(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 2)
+	defer stopCluster(nodes)
+
+	// A value that is not valid UTF-8 is stored unchanged through
+	// a follower, which forwards the write to the leader.
+	value := string([]byte{0xff, 0xfe, 0x00, 0x80, 'a'})
+	follower := newRESPClient(nodes[1].service)
+	defer follower.conn.Close()
+	reply := follower.send(t, command("SET", "bin", value), 1)
+	utils.AssertEqual(t, reply[0], "+OK\r\n", "")
+
+	x := nodes[0].store(0).Execute("get", request.NewRequestFromValues("bin", nil, -1))
+	utils.AssertEqual(t, reflect.DeepEqual(x.Gobj.Value, []byte(value)), true, "")
+
+	// The follower reads it back once the write is replicated
+	replicated := waitFor(10*time.Second, func() bool {
+		reply := follower.send(t, command("GET", "bin"), 1)
+		if reply[0] == "$-1\r\n" {
+			return false
+		}
+		reply = append(reply, follower.send(t, "", 1)...)
+		return reflect.DeepEqual(reply, []string{"$5\r\n", value + "\r\n"})
+	})
+	utils.AssertEqual(t, replicated, true, "")
+}
+
+func TestRESPCommands(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 1)
+	defer stopCluster(nodes)
+	client := newRESPClient(nodes[0].service)
+	defer client.conn.Close()
+
+	// Pipelined commands are answered in order
+	reply := client.send(t, command("PING")+command("SET", "England", "London")+command("GET", "England")+command("EXISTS", "England", "Wales"), 5)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"+PONG\r\n", "+OK\r\n", "$6\r\n", "London\r\n", ":1\r\n"}), true, "")
+
+	// NX only sets a missing key, XX only an existing one
+	reply = client.send(t, command("SET", "England", "Leeds", "NX")+command("SET", "Wales", "Cardiff", "XX"), 2)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"$-1\r\n", "$-1\r\n"}), true, "")
+	reply = client.send(t, command("SET", "Wales", "Cardiff", "NX")+command("SET", "England", "Leeds", "XX")+command("GET", "England"), 4)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"+OK\r\n", "+OK\r\n", "$5\r\n", "Leeds\r\n"}), true, "")
+
+	// EX sets a TTL in seconds, PX in milliseconds rounded up
+	reply = client.send(t, command("SET", "Scotland", "Edinburgh", "EX", "60")+command("TTL", "Scotland"), 2)
+	utils.AssertEqual(t, reply[0], "+OK\r\n", "")
+	ttl, _ := strconv.Atoi(reply[1][1 : len(reply[1])-2])
+	utils.AssertEqual(t, ttl > 55 && ttl <= 60, true, "")
+	reply = client.send(t, command("SET", "Ireland", "Dublin", "PX", "1500")+command("TTL", "Ireland")+command("TTL", "England")+command("TTL", "France"), 4)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"+OK\r\n", ":2\r\n", ":-1\r\n", ":-2\r\n"}), true, "")
+
+	// Options that conflict or are incomplete are refused
+	for _, req := range []string{
+		command("SET", "England", "London", "NX", "XX"),
+		command("SET", "England", "London", "EX", "5", "PX", "5"),
+		command("SET", "England", "London", "EX"),
+		command("SET", "England", "London", "KEEP"),
+	} {
+		reply = client.send(t, req, 1)
+		utils.AssertEqual(t, reply[0], "-"+respSyntaxErr+"\r\n", "")
+	}
+	reply = client.send(t, command("SET", "England", "London", "EX", "0")+command("SET", "England", "London", "EX", "ten"), 2)
+	utils.AssertEqual(t, reply[0][0], byte('-'), "")
+	utils.AssertEqual(t, reply[1], "-"+respIntegerErr+"\r\n", "")
+
+	// EXPIRE sets the TTL of an existing key, or deletes it
+	reply = client.send(t, command("EXPIRE", "England", "30")+command("EXPIRE", "France", "30")+command("EXPIRE", "Wales", "0")+command("EXISTS", "Wales"), 4)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{":1\r\n", ":0\r\n", ":1\r\n", ":0\r\n"}), true, "")
+
+	reply = client.send(t, command("DBSIZE")+command("DEL", "England", "France", "Scotland")+command("DBSIZE"), 3)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{":3\r\n", ":2\r\n", ":1\r\n"}), true, "")
+	reply = client.send(t, command("FLUSHALL")+command("DBSIZE")+command("GET", "Ireland"), 3)
+	utils.AssertEqual(t, reflect.DeepEqual(reply, []string{"+OK\r\n", ":0\r\n", "$-1\r\n"}), true, "")
+
+	reply = client.send(t, command("GET")+command("NOSUCH"), 2)
+	utils.AssertEqual(t, reply[0], "-"+wrongArity("get")+"\r\n", "")
+	utils.AssertEqual(t, reply[1][0], byte('-'), "")
+}
+
+func TestRESPArrayLengths(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 1)
+	defer stopCluster(nodes)
+	client := newRESPClient(nodes[0].service)
+	defer client.conn.Close()
+
+	// Null and empty arrays are empty commands, as in Redis
+	reply := client.send(t, "*-1\r\n*0\r\n"+command("PING"), 1)
+	utils.AssertEqual(t, reply[0], "+PONG\r\n", "")
+
+	// A large array does not reserve memory before its arguments arrive
+	c := &respConn{r: bufio.NewReader(strings.NewReader("*1048576\r\n$4\r\nPING\r\n"))}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := c.readCommand()
+	runtime.ReadMemStats(&after)
+	utils.AssertEqual(t, err != nil, true, "")
+	utils.AssertEqual(t, after.TotalAlloc-before.TotalAlloc < 1024*1024, true, "")
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/cache"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
+)
+
+// membershipCommands are the requests for a group named in their
+// body, rather than the group that owns their key.
+var membershipCommands = map[string]bool{
+	"join":          true,
+	"addLearner":    true,
+	"remove":        true,
+	"promote":       true,
+	"getStatus":     true,
+	"getNodeStatus": true,
+}
+
+// keyCommands are the requests for a single key, which are served
+// by the group that owns the slot of their key.
+var keyCommands = map[string]bool{
+	base.STORE_GET:     true,
+	base.STORE_PUT:     true,
+	base.STORE_ADD:     true,
+	base.STORE_DELETE:  true,
+	base.STORE_REPLACE: true,
+	base.STORE_TOUCH:   true,
+	base.STORE_TTL:     true,
+	base.STORE_GETS:    true,
+	base.STORE_CAS:     true,
+	base.STORE_INCR:    true,
+	base.STORE_DECR:    true,
+}
+
+// group returns the group a request is for. Membership requests name
+// it in their body and default to the meta group, as do migration
+// and replication requests, and cache requests belong to the group that owns their
+// key. Requests routed or forwarded by another node name their group,
+// but the group of a key is taken from this nodes own slot map, as a
+// slot may have moved since. A request routed to the group a slot is
+// being moved to is served there.
+func (service *Service) group(ctx *fasthttp.RequestCtx, cmd string, req *request.CacheRequest) int {
+	header, err := strconv.Atoi(string(ctx.Request.Header.Peek(GroupHeader)))
+	if keyCommands[cmd] {
+		slot := shard.Slot(req.Gobj.Key)
+		if m, ok := service.node.Migrating(slot); ok && err == nil && header == m.To {
+			return header
+		}
+		return service.node.Owner(slot)
+	}
+	if err == nil {
+		return header
+	}
+	if membershipCommands[cmd] {
+		m := make(map[string]string)
+		json.Unmarshal(ctx.PostBody(), &m)
+		if g, err := strconv.Atoi(m["group"]); err == nil {
+			return g
+		}
+		return shard.META_GROUP
+	}
+	if migrationCommands[cmd] || replicationCommands[cmd] {
+		return shard.META_GROUP
+	}
+	return service.node.Group(req.Gobj.Key)
+}
+
+// route sends a request for a group this node does not host to a
+// node that does, and relays its response. A request that was
+// already routed or forwarded is not routed again.
+func (service *Service) route(ctx *fasthttp.RequestCtx, group int) bool {
+	if len(ctx.Request.Header.Peek(RoutedHeader)) > 0 || len(ctx.Request.Header.Peek(ForwardedHeader)) > 0 {
+		return false
+	}
+	for _, member := range service.node.Members(group) {
+		if service.relay(ctx, member, RoutedHeader, group) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// spansGroups reports if a request is for every data group of a
+// sharded cluster, rather than the group that owns a single key.
+func (service *Service) spansGroups(ctx *fasthttp.RequestCtx, cmd string) bool {
+	if !service.node.Sharded() || len(ctx.Request.Header.Peek(GroupHeader)) > 0 {
+		return false
+	}
+	return cmd == base.STORE_FLUSH || cmd == base.STORE_NODE_SIZE
+}
+
+// handleAllGroups serves a request for every data group. A flush is
+// sent to every data group of the cluster, while the size of a node
+// is the sum of the sizes of the data groups it hosts.
+func (service *Service) handleAllGroups(ctx *fasthttp.RequestCtx, cmd string, req request.CacheRequest) response.CacheResponse {
+	if cmd == base.STORE_NODE_SIZE {
+		size := cache.NodeSize{}
+		for _, g := range service.node.DataGroupsHosted() {
+			store, _ := service.node.Store(g)
+			s := store.CacheSize()
+			size.Keys += s.Keys
+			size.Bytes += s.Bytes
+		}
+		return response.NewResponseFromValue(size)
+	}
+
+	res := response.NewResponseFromMessage("OK", 1)
+	for _, g := range service.node.DataGroups() {
+		if res = service.groupRequest(ctx, g, cmd, req); res.Status != 1 {
+			return res
+		}
+	}
+	return res
+}
+
+// groupRequest serves a request in the given group, on this node if
+// it hosts the group, otherwise on a node that does.
+func (service *Service) groupRequest(ctx *fasthttp.RequestCtx, group int, cmd string, req request.CacheRequest) response.CacheResponse {
+	return service.groupExecute(group, cmd, req, func(store *base.Store) response.CacheResponse {
+		return store.Execute(cmd, req)
+	})
+}
+
+// groupCall serves a request in the given group, calling local if
+// this node hosts the group, otherwise sending body to a node that
+// does. A follower that cannot serve it sends it on to the leader.
+func (service *Service) groupCall(group int, cmd string, body []byte, local func(*base.Store) response.CacheResponse) response.CacheResponse {
+	return service.groupSend(group, local, func(addr string, header string) (response.CacheResponse, error) {
+		var res response.CacheResponse
+		err := service.call(addr, cmd, header, group, body, forwardTimeout, &res)
+		return res, err
+	})
+}
+
+// groupExecute serves a cache request in the given group as groupCall
+// does, but sends it to other nodes in the binary format of the log,
+// so values that are not valid UTF-8 reach the store unchanged.
+func (service *Service) groupExecute(group int, cmd string, req request.CacheRequest, local func(*base.Store) response.CacheResponse) response.CacheResponse {
+	return service.groupSend(group, local, func(addr string, header string) (response.CacheResponse, error) {
+		return service.callBinary(addr, cmd, header, group, req)
+	})
+}
+
+// groupSend serves a request in the given group, calling local if
+// this node hosts the group, otherwise calling send with the address
+// of a node that does, and the header to mark the request with.
+func (service *Service) groupSend(group int, local func(*base.Store) response.CacheResponse, send func(string, string) (response.CacheResponse, error)) response.CacheResponse {
+	if store, ok := service.node.Store(group); ok {
+		res := local(store)
+		leader, _ := res.Gobj.Value.(string)
+		if res.Error != response.NOT_LEADER_ERR || leader == "" {
+			return res
+		}
+		forwarded, err := send(leader, ForwardedHeader)
+		if err != nil {
+			return response.NewNotLeaderResponse(leader)
+		}
+		return forwarded
+	}
+
+	for _, member := range service.node.Members(group) {
+		if res, err := send(member, RoutedHeader); err == nil {
+			return res
+		}
+	}
+	return response.NewWrongGroupResponse(group)
+}
+
+// executeKey serves a request for a single key in the group that
+// owns its slot, or in the group the slot is being moved to if the
+// key has already moved there, as the HTTP request would be.
+func (service *Service) executeKey(cmd string, req request.CacheRequest) response.CacheResponse {
+	group := service.node.Group(req.Gobj.Key)
+	return service.groupExecute(group, cmd, req, func(store *base.Store) response.CacheResponse {
+		if to, ok := service.moved(store, group, cmd, req); ok {
+			return service.groupExecute(to, cmd, req, func(s *base.Store) response.CacheResponse {
+				return s.Execute(cmd, req)
+			})
+		}
+		return store.Execute(cmd, req)
+	})
+}
+
+// flushAll flushes every data group of the cluster.
+func (service *Service) flushAll() response.CacheResponse {
+	req := request.NewEmptyRequest()
+	res := response.NewResponseFromMessage("OK", 1)
+	for _, g := range service.node.DataGroups() {
+		res = service.groupExecute(g, base.STORE_FLUSH, req, func(store *base.Store) response.CacheResponse {
+			return store.Execute(base.STORE_FLUSH, req)
+		})
+		if res.Status != 1 {
+			return res
+		}
+	}
+	return res
+}
+
+// valueBytes formats a cached value as bytes. Values written by
+// Redis and memcached clients are bytes, while values of other
+// types, written over HTTP, are sent as JSON.
+func valueBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	}
+	b, _ := json.Marshal(value)
+	return b
+}
@@ -1,13 +1,19 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"fmt"
+	"time"
+	"strconv"
+	"sync"
 
 	"github.com/valyala/fasthttp"
 	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
 	"github.com/ghostdb/ghostdb-cache-node/store/request"
 	"github.com/ghostdb/ghostdb-cache-node/store/response"
 	"github.com/ghostdb/ghostdb-cache-node/system_monitor"
@@ -18,101 +24,304 @@ var (
     HTTPAddr string
 )
 
+const (
+	// ForwardedHeader marks a request forwarded by a follower, so
+	// it is not forwarded again if leadership moved in the meantime.
+	ForwardedHeader = "X-Ghostdb-Forwarded-By"
+
+	// RoutedHeader marks a request routed by a node that does not
+	// host its group, so it is not routed again if the directory
+	// is out of date. A routed request may still be forwarded.
+	RoutedHeader = "X-Ghostdb-Routed-By"
+
+	// GroupHeader names the Raft group a routed or
+	// forwarded request is for.
+	GroupHeader = "X-Ghostdb-Group"
+
+	// EncodingHeader marks a request whose body is encoded in the
+	// binary format of the log rather than JSON, as are the
+	// requests nodes pass on for Redis and memcached clients,
+	// whose values may be any bytes. Its response is encoded
+	// the same way.
+	EncodingHeader = "X-Ghostdb-Encoding"
+	BinaryEncoding = "binary"
+
+	forwardTimeout = 10 * time.Second
+
+	// ServerVersion is the version reported to Redis
+	// and memcached clients.
+	ServerVersion = "1.0.0"
+)
+
 // Service is a type to be used by the raft consensus protocol
-// consists of a base http address and a store in the finite state machine
+// consists of a base http address and a node running a store
+// in the finite state machine of each Raft group it hosts
 type Service struct {
-	addr  string
-	store *base.Store
+	addr      string
+	node      *shard.Node
+	client    *fasthttp.Client
+	// tls, if set, is the TLS configuration nodes connect to each
+	// other with, which is that of the Raft transport.
+	tls       *tls.Config
+
+	// mux guards migrating, the slots this node is moving
+	mux       sync.Mutex
+	migrating map[int]bool
+
+	// wg tracks the goroutines replicating to the standby
+	// cluster, which Close waits for.
+	wg        sync.WaitGroup
 }
 
 // NewService is used to initialize a new service struct
-// parameters: addr (a string of a http address), store (a store of node details)
+// parameters: addr (a string of a http address), node (the stores of the node)
 // returns: *Service (a newly initialized service struct)
-func NewService(addr string, store *base.Store) *Service {
-	return &Service{
-		addr:  addr,
-		store: store,
+func NewService(addr string, node *shard.Node) *Service {
+	service := &Service{
+		addr:      addr,
+		node:      node,
+		client:    &fasthttp.Client{},
+		migrating: make(map[int]bool),
+	}
+	if conf := node.Meta().Conf; conf.RaftTLSEnabled() {
+		tlsConfig, err := base.RaftTLSConfig(conf)
+		if err != nil {
+			log.Fatalf("failed to load the TLS configuration: %s", err.Error())
+		}
+		service.tls = tlsConfig
+		service.client.TLSConfig = tlsConfig
 	}
+	return service
 }
 
+// Start serves HTTP requests on the address of the service.
 func (service *Service) Start() {
+	ln, err := net.Listen("tcp4", service.addr)
+	if err != nil {
+		log.Fatalf("failed to serve HTTP: %s", err.Error())
+	}
+	service.Serve(ln)
+}
+
+// Close closes the node, and waits for the goroutines replicating
+// its groups to the standby cluster to return.
+func (service *Service) Close() error {
+	err := service.node.Close()
+	service.wg.Wait()
+	return err
+}
+
+// Serve serves HTTP requests on the given listener, which accepts
+// connections on the address of the service, and resumes the
+// migrations and replication this node takes part in. With TLS,
+// nodes connect over TLS and clients without.
+func (service *Service) Serve(ln net.Listener) error {
+	if service.tls != nil {
+		ln = &nodeListener{Listener: ln, config: service.tls}
+	}
 	routes := func(ctx *fasthttp.RequestCtx) {
 		var req = new(request.CacheRequest)
 		var path = ctx.Path()
 		var cmd = string(path[1:])
 		var body = ctx.PostBody()
 
-		if err := json.Unmarshal(body, &req); err != nil {
-			log.Println(err)
-			ctx.Request.Header.Set("Content-Type", "application/json; charset=UTF-8")
-			ctx.SetStatusCode(422)
-			if err := json.NewEncoder(ctx).Encode(err); err != nil {
-				panic(err)
+		binary := string(ctx.Request.Header.Peek(EncodingHeader)) == BinaryEncoding
+		if binary {
+			r, err := base.DecodeRequest(body)
+			if err != nil {
+				log.Println(err)
+				ctx.SetStatusCode(422)
+				return
+			}
+			*req = r
+		} else {
+			// Cluster requests, such as leave, may have no body
+			if len(body) == 0 {
+				body = []byte("{}")
+			}
+			if err := json.Unmarshal(body, &req); err != nil {
+				log.Println(err)
+				ctx.Request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+				ctx.SetStatusCode(422)
+				if err := json.NewEncoder(ctx).Encode(err); err != nil {
+					panic(err)
+				}
 			}
 		}
 
 		var res response.CacheResponse
 		// Handle SysMet
-		if cmd == "getSysMetrics" {
+		if nodeCommands[cmd] && !service.fromNode(ctx, cmd) {
+			ctx.SetStatusCode(http.StatusForbidden)
+			res = response.NewResponseFromMessage(fmt.Sprintf("%s is only accepted from other nodes", cmd), 0)
+		} else if cmd == "getSysMetrics" {
 			res = system_monitor.GetSysMetrics()
 		} else if cmd == "ping" {
 			res = response.NewPingResponse()
-		} else if cmd == "join" {
-			handleJoin(ctx, service.store)
 		} else if cmd == "getLeader" {
-			res = handleGetLeader(ctx, service.store)
+			res = handleGetLeader(ctx, service.node.Meta())
+		} else if cmd == "leave" {
+			res = service.handleLeave(ctx)
+		} else if service.spansGroups(ctx, cmd) {
+			res = service.handleAllGroups(ctx, cmd, *req)
 		} else {
-			fmt.Println(service.store.RaftDir)
-			res = service.store.Execute(cmd, *req)
+			group := service.group(ctx, cmd, req)
+			store, ok := service.node.Store(group)
+			if !ok {
+				if service.route(ctx, group) {
+					return
+				}
+				res = response.NewWrongGroupResponse(group)
+			} else if to, ok := service.moved(store, group, cmd, *req); ok {
+				res = service.groupRequest(ctx, to, cmd, *req)
+			} else {
+				res = service.handle(ctx, store, group, cmd, *req)
+				if res.Error == response.NOT_LEADER_ERR && service.forward(ctx, res, group) {
+					return
+				}
+			}
 		}
 		
+		// Handlers set a status code only if the request failed
+		if binary {
+			b, err := base.EncodeResponse(&res)
+			if err != nil {
+				panic(err)
+			}
+			ctx.Response.Header.Set("Content-Type", "application/octet-stream")
+			ctx.SetBody(b)
+			return
+		}
 		ctx.Response.Header.Set("Content-Type", "application/json; charset=UTF-8")
-		ctx.SetStatusCode(http.StatusOK)
 
 		if err := json.NewEncoder(ctx).Encode(res); err != nil {
 			panic(err)
 		}
 	}
 
+	if service.node.Sharded() {
+		go service.resumeMigrations()
+	}
+	if len(service.node.Meta().Conf.ReplicationTargets) > 0 {
+		service.startReplication()
+	}
+
 	HTTPAddr = service.addr
 	log.Println("Serving...")
-	fasthttp.ListenAndServe(HTTPAddr, routes)
+	return fasthttp.Serve(ln, routes)
+}
+
+// handle serves a request for a group this node hosts.
+func (service *Service) handle(ctx *fasthttp.RequestCtx, store *base.Store, group int, cmd string, req request.CacheRequest) response.CacheResponse {
+	switch cmd {
+	case "join":
+		return handleJoin(ctx, store)
+	case "addLearner":
+		return handleAddLearner(ctx, store)
+	case "remove":
+		return handleRemove(ctx, store)
+	case "promote":
+		return handlePromote(ctx, store)
+	case "getStatus":
+		return service.handleGetStatus(ctx, store, group)
+	case "getNodeStatus":
+		return response.NewResponseFromValue(store.NodeStatus())
+	case "migrateSlot":
+		return service.handleMigrateSlot(ctx, store)
+	case "getMigrations":
+		return handleGetMigrations(ctx, store)
+	case "exportSlot":
+		return handleExportSlot(ctx, store)
+	case "importEntries":
+		return handleImportEntries(ctx, store)
+	case "releaseEntries":
+		return handleReleaseEntries(ctx, store)
+	case "replicate":
+		return service.handleReplicate(ctx, store, group)
+	case "getCheckpoint":
+		return handleGetCheckpoint(ctx, store)
+	}
+	return store.Execute(cmd, req)
+}
+
+// forward sends a request the node could not serve to the leader,
+// and relays the leaders response. If the leader is not known, or
+// the request was already forwarded, the client is sent the not
+// leader response instead, which names the leaders HTTP address.
+func (service *Service) forward(ctx *fasthttp.RequestCtx, res response.CacheResponse, group int) bool {
+	leader, _ := res.Gobj.Value.(string)
+	if leader == "" || len(ctx.Request.Header.Peek(ForwardedHeader)) > 0 {
+		return false
+	}
+
+	if err := service.relay(ctx, leader, ForwardedHeader, group); err != nil {
+		log.Printf("failed to forward %s to the leader at %s: %s", ctx.Path(), leader, err.Error())
+		return false
+	}
+	return true
+}
+
+// relay sends a request on to the node at addr, marked with the
+// given header and the group it is for, and relays its response.
+func (service *Service) relay(ctx *fasthttp.RequestCtx, addr string, header string, group int) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	ctx.Request.CopyTo(req)
+	req.SetRequestURI(fmt.Sprintf("%s://%s%s", service.scheme(), addr, ctx.Path()))
+	req.Header.Set(header, service.addr)
+	req.Header.Set(GroupHeader, strconv.Itoa(group))
+
+	if err := service.client.DoTimeout(req, resp, forwardTimeout); err != nil {
+		return err
+	}
+
+	ctx.Response.Header.SetContentTypeBytes(resp.Header.ContentType())
+	ctx.SetStatusCode(resp.StatusCode())
+	ctx.SetBody(resp.Body())
+	return nil
 }
 
 type JoinRequest struct {
 	Addr   string `json:"addr"`
 	Id interface{} `json:"id"`
+	HTTPAddr string `json:"httpAddr,omitempty"`
 }
 
 func handleGetLeader(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
 	return response.NewResponseFromMessage(string(store.Raft.Leader()), http.StatusOK)
 }
 
-func handleJoin(ctx *fasthttp.RequestCtx, store *base.Store) {
-	m := make(map[string]string)
-	if err := json.Unmarshal(ctx.PostBody(), &m); err != nil {
-		ctx.SetStatusCode(http.StatusBadRequest)
-		return
-	}
-
-	if len(m) != 2 {
-		ctx.SetStatusCode(http.StatusBadRequest)
-		return
-	}
-
-	remoteAddr, ok := m["addr"]
+func handleJoin(ctx *fasthttp.RequestCtx, store *base.Store) response.CacheResponse {
+	m, ok := parseMembershipRequest(ctx, "id", "addr")
 	if !ok {
-		ctx.SetStatusCode(http.StatusBadRequest)
-		return
+		return badMembershipRequest(ctx, "join requires an id and addr")
 	}
+	return membershipResponse(ctx, store, addPeer(store, m, store.Join))
+}
 
-	nodeID, ok := m["id"]
-	if !ok {
-		ctx.SetStatusCode(http.StatusBadRequest)
-		return
+// addPeer adds a node to the group with the given membership
+// change, then records the addresses and groups it gave.
+func addPeer(store *base.Store, m map[string]string, add func(nodeID string, addr string) error) error {
+	err := add(m["id"], m["addr"])
+	if err == nil {
+		// Nodes that give their HTTP address can be forwarded
+		// requests when they lead.
+		if httpAddr, ok := m["httpAddr"]; ok {
+			err = store.SetPeerHTTPAddr(m["id"], httpAddr)
+		}
 	}
-
-	if err := store.Join(nodeID, remoteAddr); err != nil {
-		panic(err)
+	if err == nil {
+		// Nodes joining the meta group of a sharded cluster
+		// give the data groups they host.
+		if value, ok := m["groups"]; ok {
+			var groups []int
+			if groups, err = base.ParseGroups(value); err == nil {
+				err = store.SetPeerGroups(m["id"], groups)
+			}
+		}
 	}
-}
\ No newline at end of file
+	return err
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/store/shard"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// testNode is a node of a test cluster, serving HTTP on addr
+type testNode struct {
+	node    *shard.Node
+	service *Service
+	addr    string
+	ln      net.Listener
+	dir     string
+}
+
+// freeRaftAddrs returns a local address whose port, and the
+// ports of the given number of groups above it, are all free
+func freeRaftAddrs(t *testing.T, groups int) string {
+	for attempt := 0; attempt < 100; attempt++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to find a free port: %s", err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+
+		free := true
+		for g := 1; g <= groups && free; g++ {
+			l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port+g))
+			if err != nil {
+				free = false
+				continue
+			}
+			l.Close()
+		}
+		if free {
+			return "127.0.0.1:" + strconv.Itoa(port)
+		}
+	}
+	t.Fatalf("failed to find free ports")
+	return ""
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cond()
+}
+
+// startNode opens a node hosting every data group and serves it
+// over HTTP. The first node of a cluster bootstraps it.
+func startNode(t *testing.T, conf config.Configuration, id string, bootstrap bool) *testNode {
+	return startNodeGroups(t, conf, id, bootstrap, shard.DataGroups(int(conf.RaftGroups)))
+}
+
+// startNodeGroups opens a node hosting the given data groups
+// and serves it over HTTP.
+func startNodeGroups(t *testing.T, conf config.Configuration, id string, bootstrap bool, groups []int) *testNode {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	n := &testNode{addr: ln.Addr().String(), ln: ln}
+	n.node, err = shard.NewNode(conf, id, n.addr, groups)
+	if err != nil {
+		t.Fatalf("failed to create node: %s", err)
+	}
+	n.dir, _ = ioutil.TempDir("", "server_test")
+	if err := n.node.Open(n.dir, freeRaftAddrs(t, int(conf.RaftGroups)), bootstrap); err != nil {
+		t.Fatalf("failed to open node: %s", err)
+	}
+	n.service = NewService(n.addr, n.node)
+	go n.service.Serve(ln)
+	return n
+}
+
+// close stops the node and removes its Raft directory
+func (n *testNode) close() {
+	n.ln.Close()
+	n.service.Close()
+	os.RemoveAll(n.dir)
+}
+
+// store returns the store of a group of the node
+func (n *testNode) store(group int) *base.Store {
+	store, _ := n.node.Store(group)
+	return store
+}
+
+// startCluster starts a cluster of the given number of nodes, the
+// first of which leads every group the others have joined.
+func startCluster(t *testing.T, conf config.Configuration, size int) []*testNode {
+	seed := startNode(t, conf, "node0", true)
+	nodes := []*testNode{seed}
+	led := waitFor(10*time.Second, func() bool {
+		for _, g := range seed.node.Groups() {
+			if !seed.store(g).IsLeader() || seed.store(g).LeaderHTTPAddr() == "" {
+				return false
+			}
+		}
+		return true
+	})
+	if !led {
+		t.Fatalf("no leader elected")
+	}
+
+	for i := 1; i < size; i++ {
+		n := startNode(t, conf, "node"+strconv.Itoa(i), false)
+		nodes = append(nodes, n)
+		seed.join(t, n, "node"+strconv.Itoa(i))
+	}
+	return nodes
+}
+
+// join joins a node to every group it hosts, led by this node,
+// and waits until the node knows this node leads them.
+func (seed *testNode) join(t *testing.T, n *testNode, id string) {
+	for _, g := range n.node.Groups() {
+		if err := seed.store(g).Join(id, n.store(g).RaftBind); err != nil {
+			t.Fatalf("failed to join group %d: %s", g, err)
+		}
+		if err := seed.store(g).SetPeerHTTPAddr(id, n.addr); err != nil {
+			t.Fatalf("failed to record the address of %s: %s", id, err)
+		}
+	}
+	if seed.node.Sharded() {
+		if err := seed.node.Meta().SetPeerGroups(id, n.node.DataGroupsHosted()); err != nil {
+			t.Fatalf("failed to record the groups of %s: %s", id, err)
+		}
+	}
+
+	known := waitFor(10*time.Second, func() bool {
+		for _, g := range n.node.Groups() {
+			if n.store(g).LeaderHTTPAddr() != seed.addr {
+				return false
+			}
+		}
+		return true
+	})
+	if !known {
+		t.Fatalf("the leader is not known to %s", id)
+	}
+}
+
+// stopCluster stops every node of a cluster
+func stopCluster(nodes []*testNode) {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodes[i].close()
+	}
+}
+
+// testConfig returns the configuration of a test node
+func testConfig(shards int32) config.Configuration {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.PersistenceAOF = false
+	conf.RaftGroups = shards
+	return conf
+}
+
+// send posts a JSON request as a client would, with the given
+// headers, and decodes the response.
+func send(t *testing.T, addr string, cmd string, body string, headers map[string]string) (int, response.CacheResponse) {
+	req, _ := http.NewRequest("POST", "http://"+addr+"/"+cmd, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("failed to send %s: %s", cmd, err)
+	}
+	defer resp.Body.Close()
+
+	var res response.CacheResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode the response to %s: %s", cmd, err)
+	}
+	return resp.StatusCode, res
+}
+
+func TestFollowerForwardsToLeader(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 2)
+	defer stopCluster(nodes)
+	leader, follower := nodes[0], nodes[1]
+
+	// A write sent to a follower is made by the leader
+	code, res := send(t, follower.addr, "put", `{"Gobj": {"Key": "England", "Value": "London", "TTL": "-1"}}`, nil)
+	utils.AssertEqual(t, code, http.StatusOK, "")
+	utils.AssertEqual(t, res.Status, int32(1), "")
+	x := leader.store(0).Execute("get", request.NewRequestFromValues("England", nil, -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+
+	// As is a read only the leader serves
+	code, res = send(t, follower.addr, "get", `{"Gobj": {"Key": "England"}, "Consistency": "leader"}`, nil)
+	utils.AssertEqual(t, code, http.StatusOK, "")
+	utils.AssertEqual(t, res.Gobj.Value, "London", "")
+
+	// A request already forwarded is not forwarded again, so a
+	// stale leader address cannot bounce it between nodes
+	headers := map[string]string{ForwardedHeader: leader.addr, GroupHeader: "0"}
+	_, res = send(t, follower.addr, "put", `{"Gobj": {"Key": "Wales", "Value": "Cardiff", "TTL": "-1"}}`, headers)
+	utils.AssertEqual(t, res.Error, response.NOT_LEADER_ERR, "")
+	utils.AssertEqual(t, res.Gobj.Value, leader.addr, "")
+	utils.AssertEqual(t, leader.store(0).Contains("Wales"), false, "")
+
+	// A follower that does not know the leader names no one
+	leader.close()
+	unknown := waitFor(10*time.Second, func() bool {
+		return follower.store(0).LeaderHTTPAddr() == ""
+	})
+	utils.AssertEqual(t, unknown, true, "")
+	_, res = send(t, follower.addr, "put", `{"Gobj": {"Key": "Wales", "Value": "Cardiff", "TTL": "-1"}}`, nil)
+	utils.AssertEqual(t, res.Error, response.NOT_LEADER_ERR, "")
+	utils.AssertEqual(t, res.Gobj.Value, "", "")
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// tlsHandshake is the first byte a TLS client sends.
+const tlsHandshake = 0x16
+
+// nodeListener accepts clients and nodes on the HTTP address. Nodes
+// of the cluster connect with TLS, presenting a certificate signed by
+// the CA of the cluster, while clients connect without, so the first
+// byte each connection sends decides which it is.
+type nodeListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func (l *nodeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &nodeConn{Conn: conn, config: l.config}, nil
+}
+
+// nodeConn is a connection accepted by a nodeListener. The first
+// read or write waits for the first byte, and completes the TLS
+// handshake if it starts one.
+type nodeConn struct {
+	net.Conn
+	config *tls.Config
+
+	once sync.Once
+	conn net.Conn
+	err  error
+}
+
+// peekedConn reads a connection through the reader that peeked it
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *nodeConn) detect() {
+	r := bufio.NewReader(c.Conn)
+	c.conn = &peekedConn{Conn: c.Conn, r: r}
+	first, err := r.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	if first[0] == tlsHandshake {
+		conn := tls.Server(c.conn, c.config)
+		c.err = conn.Handshake()
+		c.conn = conn
+	}
+}
+
+func (c *nodeConn) Read(b []byte) (int, error) {
+	if c.once.Do(c.detect); c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *nodeConn) Write(b []byte) (int, error) {
+	if c.once.Do(c.detect); c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Write(b)
+}
+
+// authenticated reports if the connection is from a node, which
+// presented a certificate signed by the CA of the cluster.
+func (c *nodeConn) authenticated() bool {
+	c.once.Do(c.detect)
+	conn, ok := c.conn.(*tls.Conn)
+	return ok && c.err == nil && len(conn.ConnectionState().VerifiedChains) > 0
+}
+
+// nodeCommands are the requests only nodes send each other, which
+// move key-value pairs between groups or apply the writes of another
+// cluster, and so skip the checks of the requests of clients.
+var nodeCommands = map[string]bool{
+	"exportSlot":     true,
+	"importEntries":  true,
+	"releaseEntries": true,
+	"replicate":      true,
+	"getCheckpoint":  true,
+}
+
+// fromNode reports if a request was sent by a node. With TLS the node
+// must have connected with a certificate signed by the CA. Without
+// it nothing authenticates nodes, as any client can mark a request
+// with the headers nodes mark them with, so requests within the
+// cluster are only accepted from nodes on the same host, and
+// requests of another cluster are refused.
+func (service *Service) fromNode(ctx *fasthttp.RequestCtx, cmd string) bool {
+	if service.tls != nil {
+		conn, ok := ctx.Conn().(*nodeConn)
+		return ok && conn.authenticated()
+	}
+	if replicationCommands[cmd] || !ctx.RemoteIP().IsLoopback() {
+		return false
+	}
+	marked := len(ctx.Request.Header.Peek(RoutedHeader)) > 0 || len(ctx.Request.Header.Peek(ForwardedHeader)) > 0
+	return marked && len(ctx.Request.Header.Peek(GroupHeader)) > 0
+}
+
+// scheme returns the scheme of the requests this node sends other
+// nodes, which is https if TLS is configured.
+func (service *Service) scheme() string {
+	if service.tls != nil {
+		return "https"
+	}
+	return "http"
+}
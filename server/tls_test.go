@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// issueTestCerts writes a CA and a certificate for 127.0.0.1 signed
+// by it, and sets the configuration to present and trust them.
+func issueTestCerts(t *testing.T, conf *config.Configuration) {
+	dir, _ := ioutil.TempDir("", "tls_test")
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA: %s", err)
+	}
+	ca, _ := x509.ParseCertificate(caDer)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	conf.RaftTLSCAFile = filepath.Join(dir, "ca.pem")
+	conf.RaftTLSCertFile = filepath.Join(dir, "node.crt")
+	conf.RaftTLSKeyFile = filepath.Join(dir, "node.key")
+	for path, block := range map[string]*pem.Block{
+		conf.RaftTLSCAFile:   {Type: "CERTIFICATE", Bytes: caDer},
+		conf.RaftTLSCertFile: {Type: "CERTIFICATE", Bytes: der},
+		conf.RaftTLSKeyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDer},
+	} {
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("failed to write %s: %s", path, err)
+		}
+	}
+}
+
+// post sends a request as a client would, with the given headers
+func post(t *testing.T, addr string, cmd string, body string, headers map[string]string) int {
+	req, _ := http.NewRequest("POST", "http://"+addr+"/"+cmd, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("failed to send %s: %s", cmd, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestNodeRequestsOverTLS(t *testing.T) {
+	conf := testConfig(1)
+	issueTestCerts(t, &conf)
+	nodes := startCluster(t, conf, 2)
+	defer stopCluster(nodes)
+
+	// Clients connect without TLS, and a follower forwards their
+	// writes to the leader with TLS
+	follower := newMemcacheClient(nodes[1].service)
+	defer follower.conn.Close()
+	reply := follower.send(t, "set England 0 0 6\r\nLondon\r\n", 1)
+	utils.AssertEqual(t, reply[0], "STORED\r\n", "")
+	x := nodes[0].store(0).Execute("get", request.NewRequestFromValues("England", nil, -1))
+	utils.AssertEqual(t, string(x.Gobj.Value.([]byte)), "London", "")
+	utils.AssertEqual(t, post(t, nodes[1].addr, "put", `{"Gobj": {"Key": "France", "Value": "Paris", "TTL": "-1"}}`, nil), http.StatusOK, "")
+	x = nodes[0].store(0).Execute("get", request.NewRequestFromValues("France", nil, -1))
+	utils.AssertEqual(t, x.Gobj.Value, "Paris", "")
+
+	// Requests only nodes send are refused from clients, even
+	// if marked as a node would mark them
+	marked := map[string]string{RoutedHeader: nodes[1].addr, GroupHeader: "0"}
+	for _, cmd := range []string{"exportSlot", "importEntries", "releaseEntries", "replicate", "getCheckpoint"} {
+		utils.AssertEqual(t, post(t, nodes[0].addr, cmd, "{}", marked), http.StatusForbidden, cmd)
+	}
+}
+
+func TestNodeRequestsWithoutTLS(t *testing.T) {
+	nodes := startCluster(t, testConfig(1), 1)
+	defer stopCluster(nodes)
+
+	// Without TLS requests within the cluster are told apart by
+	// their headers, and requests of another cluster are refused
+	marked := map[string]string{RoutedHeader: nodes[0].addr, GroupHeader: "0"}
+	for _, cmd := range []string{"exportSlot", "importEntries", "releaseEntries"} {
+		utils.AssertEqual(t, post(t, nodes[0].addr, cmd, "{}", nil), http.StatusForbidden, cmd)
+		utils.AssertEqual(t, post(t, nodes[0].addr, cmd, "{}", marked) != http.StatusForbidden, true, cmd)
+	}
+	for _, cmd := range []string{"replicate", "getCheckpoint"} {
+		utils.AssertEqual(t, post(t, nodes[0].addr, cmd, "{}", marked), http.StatusForbidden, cmd)
+	}
+
+	// Clients on another host cannot pass as nodes by marking
+	// their requests with the same headers
+	var req fasthttp.Request
+	for header, value := range marked {
+		req.Header.Set(header, value)
+	}
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}, nil)
+	for _, cmd := range []string{"exportSlot", "importEntries", "releaseEntries"} {
+		utils.AssertEqual(t, nodes[0].service.fromNode(&ctx, cmd), false, cmd)
+	}
+}
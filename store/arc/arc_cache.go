@@ -0,0 +1,341 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package arc
+
+import (
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// ARCCache represents an adaptive replacement cache object.
+//
+// Key-value pairs seen once live in T1, pairs seen at least twice
+// live in T2. Keys recently evicted from T1 and T2 are remembered,
+// without their values, in the ghost lists B1 and B2. A hit on a
+// ghost list shifts the target size of T1 towards recency (B1) or
+// frequency (B2), so the cache adapts to the workload on its own.
+type ARCCache struct {
+	lru.Keyed
+
+	// Size represents the maximum number of allowable
+	// key-value pairs in the cache.
+	Size int32
+
+	// Count records the number of key-value pairs
+	// currently in the cache.
+	Count int32
+
+	// Full tracks if Count is equal to Size
+	Full bool
+
+	// Target is the adaptive target size of T1, known as p.
+	Target int32
+
+	// RecentGhostHits is the cumulative number of hits on B1
+	RecentGhostHits uint64
+
+	// FrequentGhostHits is the cumulative number of hits on B2
+	FrequentGhostHits uint64
+
+
+	// ghosts maps keys to nodes in B1 and B2
+	ghosts map[string]*lru.Node
+
+	// lists maps every key, cached or ghost, to the list it is in
+	lists map[string]*lru.List
+
+	t1 *lru.List
+	t2 *lru.List
+	b1 *lru.List
+	b2 *lru.List
+}
+
+// NewARC will initialize the cache
+func NewARC(config config.Configuration) *ARCCache {
+	cache := &ARCCache{
+		Size: config.KeyspaceSize,
+	}
+	cache.reset()
+	cache.InitKeyed(cache.remove, cache.replayOrder)
+	return cache
+}
+
+func (cache *ARCCache) reset() {
+	cache.Count = 0
+	cache.Full = false
+	cache.Target = 0
+	cache.Hashtable = make(map[string]*lru.Node)
+	cache.ghosts = make(map[string]*lru.Node)
+	cache.lists = make(map[string]*lru.List)
+	cache.t1 = lru.InitList()
+	cache.t2 = lru.InitList()
+	cache.b1 = lru.InitList()
+	cache.b2 = lru.InitList()
+}
+
+// Get will fetch a key/value pair from the cache
+func (cache *ARCCache) Get(args request.CacheRequest) response.CacheResponse {
+	key := args.Gobj.Key
+
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	node, ok := cache.Live(key)
+	if !ok {
+		return response.NewCacheMissResponse()
+	}
+	cache.promote(node)
+
+	return response.NewResponseFromValue(node.Value)
+}
+
+// Put will add a key/value pair to the cache, possibly
+// overwriting an existing key/value pair. Put will evict
+// a key/value pair if the cache is full.
+func (cache *ARCCache) Put(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Value = args.Gobj.Value
+		node.TTL = args.Gobj.TTL
+		node.Stamp(args)
+		cache.promote(node)
+		return response.NewResponseFromMessage(lru.STORED, 1)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Add will add a key/value pair to the cache if the key
+// does not exist already.
+func (cache *ARCCache) Add(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok && !cache.Expire(node, lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_STORED, 0)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Flush removes all key/value pairs from the cache even if they have not expired
+func (cache *ARCCache) Flush(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.reset()
+
+	return response.NewResponseFromMessage(lru.FLUSHED, 1)
+}
+
+// CountKeys return the number of keys in the cache
+func (cache *ARCCache) CountKeys(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+	return response.NewResponseFromValue(cache.Count)
+}
+
+// PolicyMetrics reports the adaptive target size and the
+// number of hits on each of the ghost lists.
+func (cache *ARCCache) PolicyMetrics() map[string]int64 {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	return map[string]int64{
+		"ArcTarget":            int64(cache.Target),
+		"ArcRecentSize":        int64(cache.t1.Size),
+		"ArcFrequentSize":      int64(cache.t2.Size),
+		"ArcRecentGhostHits":   int64(cache.RecentGhostHits),
+		"ArcFrequentGhostHits": int64(cache.FrequentGhostHits),
+	}
+}
+
+// promote moves a cached key-value pair to the most recently
+// used end of T2. The caller must hold the cache lock.
+func (cache *ARCCache) promote(node *lru.Node) {
+	lru.RemoveNode(cache.lists[node.Key], node)
+	lru.InsertNode(cache.t2, node)
+	cache.lists[node.Key] = cache.t2
+}
+
+// insert adds a key that is not currently cached. If the key is
+// remembered by a ghost list, the target size of T1 is adapted
+// and the key goes straight into T2. The caller must hold the
+// cache lock.
+func (cache *ARCCache) insert(key string, value interface{}, ttl int64) {
+	if ghost, ok := cache.ghosts[key]; ok {
+		inFrequent := cache.lists[key] == cache.b2
+		if inFrequent {
+			cache.FrequentGhostHits++
+			cache.Target = max32(0, cache.Target-max32(1, cache.b1.Size/max32(1, cache.b2.Size)))
+		} else {
+			cache.RecentGhostHits++
+			cache.Target = min32(cache.Size, cache.Target+max32(1, cache.b2.Size/max32(1, cache.b1.Size)))
+		}
+
+		lru.RemoveNode(cache.lists[key], ghost)
+		delete(cache.ghosts, key)
+		delete(cache.lists, key)
+
+		if cache.Count >= cache.Size {
+			cache.replace(inFrequent)
+		}
+		cache.store(cache.t2, key, value, ttl)
+		return
+	}
+
+	recent := cache.t1.Size + cache.b1.Size
+	total := recent + cache.t2.Size + cache.b2.Size
+	if recent >= cache.Size {
+		if cache.t1.Size < cache.Size {
+			cache.forgetLast(cache.b1)
+			if cache.Count >= cache.Size {
+				cache.replace(false)
+			}
+		} else {
+			cache.evictLast(cache.t1)
+		}
+	} else if total >= cache.Size {
+		if total >= 2*cache.Size {
+			cache.forgetLast(cache.b2)
+		}
+		if cache.Count >= cache.Size {
+			cache.replace(false)
+		}
+	}
+	cache.store(cache.t1, key, value, ttl)
+}
+
+// replace evicts a key-value pair from T1 or T2, depending on
+// the target size, and remembers its key in the matching ghost list.
+func (cache *ARCCache) replace(inFrequent bool) {
+	t1 := cache.t1.Size
+	if t1 > 0 && (t1 > cache.Target || (inFrequent && t1 == cache.Target)) {
+		cache.demoteLast(cache.t1, cache.b1)
+	} else if cache.t2.Size > 0 {
+		cache.demoteLast(cache.t2, cache.b2)
+	} else {
+		cache.demoteLast(cache.t1, cache.b1)
+	}
+}
+
+// store inserts a new key-value pair at the most recently used
+// end of a cache list.
+func (cache *ARCCache) store(ll *lru.List, key string, value interface{}, ttl int64) {
+	node, _ := lru.Insert(ll, key, value, ttl)
+	cache.Hashtable[key] = node
+	cache.lists[key] = ll
+	cache.Count++
+	cache.Full = cache.Count >= cache.Size
+}
+
+// demoteLast moves the least recently used key-value pair of a
+// cache list into a ghost list, dropping its value.
+func (cache *ARCCache) demoteLast(from *lru.List, to *lru.List) {
+	node, err := lru.RemoveLast(from)
+	if err != nil {
+		return
+	}
+	delete(cache.Hashtable, node.Key)
+	cache.Count--
+	cache.Full = false
+
+	node.Value = nil
+	lru.InsertNode(to, node)
+	cache.ghosts[node.Key] = node
+	cache.lists[node.Key] = to
+}
+
+// evictLast removes the least recently used key-value pair of a
+// cache list without remembering it.
+func (cache *ARCCache) evictLast(from *lru.List) {
+	node, err := lru.RemoveLast(from)
+	if err != nil {
+		return
+	}
+	delete(cache.Hashtable, node.Key)
+	delete(cache.lists, node.Key)
+	cache.Count--
+	cache.Full = false
+}
+
+// forgetLast drops the oldest key from a ghost list.
+func (cache *ARCCache) forgetLast(ghosts *lru.List) {
+	node, err := lru.RemoveLast(ghosts)
+	if err != nil {
+		return
+	}
+	delete(cache.ghosts, node.Key)
+	delete(cache.lists, node.Key)
+}
+
+func min32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// remove removes a resident key-value pair from the cache.
+// The caller must hold the cache lock.
+func (cache *ARCCache) remove(node *lru.Node) {
+	lru.RemoveNode(cache.lists[node.Key], node)
+	delete(cache.lists, node.Key)
+	delete(cache.Hashtable, node.Key)
+	cache.Count--
+	cache.Full = false
+}
+
+// replayOrder returns the resident lists, whose key-value pairs are
+// replayed the recently used list before the frequently used list,
+// each least recently used first. Ghost entries are not replayed.
+func (cache *ARCCache) replayOrder() []*lru.List {
+	return []*lru.List{cache.t1, cache.t2}
+}
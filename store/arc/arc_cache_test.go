@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package arc
+
+import (
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestArc(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewARC(config)
+	cache.Size = int32(2)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+
+	// T1: Dublin
+	// T2: London
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v1.Gobj.Value, "")
+
+	cache.Put(request.NewRequestFromValues("America", "Washington", -1)) // Ireland evicted to B1 here
+
+	// T1: Washington
+	// T2: London
+	// B1: Ireland
+	v2 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v2.Message, "")
+
+	v3 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v3.Gobj.Value, "")
+
+	// Putting Ireland again is a hit on B1 which grows the target
+	// size of T1 and stores Ireland in T2.
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+	utils.AssertEqual(t, cache.RecentGhostHits, uint64(1), "")
+	utils.AssertEqual(t, cache.Target, int32(1), "")
+
+	v4 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, "Dublin", v4.Gobj.Value, "")
+}
+
+func TestArcFrequentGhostHit(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewARC(config)
+	cache.Size = int32(2)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Get(request.NewRequestFromValues("England", "", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+	cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+
+	// T2: Dublin, London
+	cache.Put(request.NewRequestFromValues("America", "Washington", -1)) // England evicted to B2 here
+
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	utils.AssertEqual(t, cache.FrequentGhostHits, uint64(1), "")
+	utils.AssertEqual(t, cache.Target, int32(0), "")
+
+	metrics := cache.PolicyMetrics()
+	utils.AssertEqual(t, metrics["ArcFrequentGhostHits"], int64(1), "")
+	utils.AssertEqual(t, metrics["ArcRecentSize"]+metrics["ArcFrequentSize"], int64(2), "")
+}
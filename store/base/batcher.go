@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// proposal is a command waiting to be proposed to the replication
+// log, and the channel its response is returned on.
+type proposal struct {
+	command *Command
+	done    chan response.CacheResponse
+}
+
+// batcher groups commands applied concurrently into a single log
+// entry, so the number of writes committed per second is not bound
+// by the round trip of each entry. Like Nagle's algorithm, a command
+// made while no batch is being committed is proposed at once, with
+// any already waiting. Otherwise a batch is proposed once it is full,
+// once the batches being committed are, or once the window since its
+// first command has passed. Batches are proposed in order without
+// waiting for the previous one to commit.
+type batcher struct {
+	store     *Store
+	raft      *raft.Raft
+	proposals chan *proposal
+	size      int
+	window    time.Duration
+
+	// inflight is the number of batches proposed but not yet
+	// committed, and idle is signalled when it drops to 0.
+	inflight  int32
+	idle      chan struct{}
+}
+
+func newBatcher(store *Store, ra *raft.Raft) *batcher {
+	return &batcher{
+		store:     store,
+		raft:      ra,
+		proposals: make(chan *proposal, store.Conf.ProposalBatchSize),
+		size:      int(store.Conf.ProposalBatchSize),
+		window:    time.Duration(store.Conf.ProposalBatchWindow) * time.Microsecond,
+		idle:      make(chan struct{}, 1),
+	}
+}
+
+// propose adds a command to the next batch and waits for its
+// response, or for the store to be closed.
+func (b *batcher) propose(c *Command, shutdownCh <-chan struct{}) response.CacheResponse {
+	p := &proposal{command: c, done: make(chan response.CacheResponse, 1)}
+	select {
+	case b.proposals <- p:
+	case <-shutdownCh:
+		return b.store.commitError(raft.ErrRaftShutdown)
+	}
+
+	select {
+	case res := <-p.done:
+		return res
+	case <-shutdownCh:
+		return b.store.commitError(raft.ErrRaftShutdown)
+	}
+}
+
+// run collects commands into batches and proposes them until
+// the store is closed.
+func (b *batcher) run(shutdownCh <-chan struct{}) {
+	for {
+		var batch []*proposal
+		select {
+		case p := <-b.proposals:
+			batch = append(batch, p)
+		case <-shutdownCh:
+			return
+		}
+
+		if atomic.LoadInt32(&b.inflight) == 0 {
+			b.commit(b.drain(batch))
+			continue
+		}
+
+		timer := time.NewTimer(b.window)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case p := <-b.proposals:
+				batch = append(batch, p)
+			case <-b.idle:
+				if atomic.LoadInt32(&b.inflight) == 0 {
+					break collect
+				}
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		b.commit(b.drain(batch))
+	}
+}
+
+// drain adds the commands already waiting to a batch, until it is full.
+func (b *batcher) drain(batch []*proposal) []*proposal {
+	for len(batch) < b.size {
+		select {
+		case p := <-b.proposals:
+			batch = append(batch, p)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// done records that a batch was committed, or failed to be.
+func (b *batcher) done() {
+	if atomic.AddInt32(&b.inflight, -1) == 0 {
+		select {
+		case b.idle <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// commit proposes a batch, then returns each command its own
+// response once the batch is applied. A batch of one command
+// is proposed as that command.
+func (b *batcher) commit(batch []*proposal) {
+	c := batch[0].command
+	if len(batch) > 1 {
+		c = &Command{Cmd: STORE_BATCH, Batch: make([]Command, len(batch))}
+		for i, p := range batch {
+			c.Batch[i] = *p.command
+		}
+	}
+
+	bs, err := encodeCommand(c)
+	if err != nil {
+		for _, p := range batch {
+			p.done <- response.BadCommandResponse(p.command.Cmd)
+		}
+		return
+	}
+
+	atomic.AddInt32(&b.inflight, 1)
+	future := b.raft.Apply(bs, raftTimeout)
+	go func() {
+		err := future.Error()
+		b.done()
+		if err != nil {
+			res := b.store.commitError(err)
+			for _, p := range batch {
+				p.done <- res
+			}
+			return
+		}
+
+		if len(batch) == 1 {
+			res, ok := future.Response().(response.CacheResponse)
+			if !ok {
+				res = response.NewResponseFromMessage("Error commiting to raft cluster 2", 500)
+			}
+			batch[0].done <- res
+			return
+		}
+		responses, ok := future.Response().([]response.CacheResponse)
+		for i, p := range batch {
+			if !ok || i >= len(responses) {
+				p.done <- response.NewResponseFromMessage("Error commiting to raft cluster 2", 500)
+				continue
+			}
+			p.done <- responses[i]
+		}
+	}()
+}
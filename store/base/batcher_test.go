@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestApplyBatch(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+
+	c := Command{Cmd: STORE_BATCH, Batch: []Command{
+		{Cmd: STORE_PUT, Args: request.NewRequestFromValues("England", "London", -1)},
+		{Cmd: STORE_ADD, Args: request.NewRequestFromValues("England", "Manchester", -1)},
+		{Cmd: STORE_DELETE, Args: request.NewRequestFromValues("France", "", -1)},
+		{Cmd: "unknown", Args: request.NewEmptyRequest()},
+	}}
+	b, err := encodeCommand(&c)
+	utils.AssertEqual(t, err, nil, "")
+
+	// Each command is applied in order and has its own response
+	responses, ok := (*fsm)(store).Apply(&raft.Log{Index: 7, Data: b}).([]response.CacheResponse)
+	utils.AssertEqual(t, ok, true, "")
+	utils.AssertEqual(t, len(responses), 4, "")
+	utils.AssertEqual(t, responses[0].Message, lru.STORED, "")
+	utils.AssertEqual(t, responses[1].Message, lru.NOT_STORED, "")
+	utils.AssertEqual(t, responses[2].Message, lru.NOT_FOUND, "")
+	utils.AssertEqual(t, responses[3].Error, "INVALID_COMMAND_ERR", "")
+
+	// Writes are versioned by the entry of their batch
+	utils.AssertEqual(t, store.Cache.Entries()[0].Version, uint64(7), "")
+	x := store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+}
+
+func TestConcurrentWritesAreBatched(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.ProposalBatchSize = 64
+	conf.ProposalBatchWindow = 1000
+
+	ids := []string{"node0", "node1", "node2"}
+	stores := make([]*Store, len(ids))
+	for i, id := range ids {
+		dir, _ := ioutil.TempDir("", "store_test")
+		stores[i] = openClusterNode(t, conf, dir, freeRaftAddr(t), id, i == 0)
+		defer stores[i].Close()
+	}
+	leader := clusterLeader(t, stores[:1])
+	for _, store := range stores[1:] {
+		if err := leader.Join(store.ServerID, store.RaftBind); err != nil {
+			t.Fatalf("failed to join: %s", err)
+		}
+	}
+
+	const writers, writes = 50, 40
+	first := leader.Raft.LastIndex()
+	start := time.Now()
+	var wg sync.WaitGroup
+	failed := make(chan response.CacheResponse, writers*writes)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if x := leader.Execute("put", request.NewRequestFromValues(key, key, -1)); x.Message != lru.STORED {
+					failed <- x
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(failed)
+	for x := range failed {
+		t.Fatalf("failed to put: %+v", x)
+	}
+	t.Logf("%d puts in %s", writers*writes, time.Since(start))
+
+	// The puts took far fewer log entries than there were puts
+	entries := leader.Raft.LastIndex() - first
+	utils.AssertEqual(t, entries < writers*writes/4, true, fmt.Sprintf("%d log entries", entries))
+
+	// Every replica applies every put of every batch
+	replicated := waitFor(10*time.Second, func() bool {
+		for _, store := range stores {
+			if store.CacheSize().Keys != writers*writes {
+				return false
+			}
+		}
+		return true
+	})
+	utils.AssertEqual(t, replicated, true, "")
+	x := stores[2].Execute("get", request.NewRequestFromValues("key-7-3", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "key-7-3", "")
+}
+
+func TestLoneWritesAreNotDelayed(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.ProposalBatchSize = 64
+	conf.ProposalBatchWindow = 2000000
+
+	dir, _ := ioutil.TempDir("", "store_test")
+	store := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer store.Close()
+	clusterLeader(t, []*Store{store})
+
+	// A write made while no batch is being committed is proposed
+	// at once, rather than after the window
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		x := store.Execute("put", request.NewRequestFromValues(key, key, -1))
+		utils.AssertEqual(t, x.Message, lru.STORED, "")
+	}
+	utils.AssertEqual(t, time.Since(start) < time.Second, true, time.Since(start).String())
+}
@@ -0,0 +1,550 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// commandFormat is the version of the binary encoding of the commands
+// in the replication log. It is the first byte of every log entry, so
+// entries of an unknown version are refused. Entries written as JSON
+// before the binary encoding existed start with '{', and are still
+// replayed. Format 2 added the flags and cas of requests and the
+// flags of key-value pairs, and entries of format 1 are still read.
+const commandFormat = 2
+
+// entriesFormat is the version of the binary encoding of the
+// key-value pairs in a Raft snapshot. Format 2 added their flags,
+// and key-value pairs of format 1 are still read.
+const entriesFormat = 2
+
+// Value type tags. Each value is written with the tag of its type, so
+// it is decoded with exactly the type it was written with. Values of
+// any other type, such as the objects and arrays of JSON requests,
+// are written as JSON.
+const (
+	valueNil byte = iota
+	valueString
+	valueBytes
+	valueBool
+	valueInt
+	valueInt8
+	valueInt16
+	valueInt32
+	valueInt64
+	valueUint
+	valueUint8
+	valueUint16
+	valueUint32
+	valueUint64
+	valueFloat32
+	valueFloat64
+	valueJSON
+)
+
+var errTruncated = errors.New("log entry is truncated")
+
+// encodeCommand encodes a command as a log entry.
+func encodeCommand(c *Command) ([]byte, error) {
+	e := &encoder{buf: make([]byte, 0, 64)}
+	e.byte(commandFormat)
+	e.command(c)
+	return e.buf, e.err
+}
+
+// decodeCommand decodes a log entry written by encodeCommand,
+// or a JSON log entry written by an earlier version.
+func decodeCommand(data []byte) (*Command, error) {
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+
+	var c Command
+	if data[0] == '{' {
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, err
+		}
+		return &c, nil
+	}
+	if data[0] != 1 && data[0] != commandFormat {
+		return nil, fmt.Errorf("unsupported log entry format %d", data[0])
+	}
+
+	d := &decoder{data: data[1:], format: data[0]}
+	d.command(&c)
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d unexpected bytes after log entry", len(d.data))
+	}
+	return &c, d.err
+}
+
+// encodeEntries encodes the key-value pairs of a snapshot.
+func encodeEntries(entries []lru.Entry) ([]byte, error) {
+	e := &encoder{buf: make([]byte, 0, 64*len(entries)+8)}
+	e.byte(entriesFormat)
+	e.uvarint(uint64(len(entries)))
+	for i := range entries {
+		e.entry(&entries[i])
+	}
+	return e.buf, e.err
+}
+
+// decodeEntries decodes the key-value pairs written by encodeEntries.
+func decodeEntries(data []byte) ([]lru.Entry, error) {
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+	if data[0] != 1 && data[0] != entriesFormat {
+		return nil, fmt.Errorf("unsupported snapshot entries format %d", data[0])
+	}
+
+	d := &decoder{data: data[1:], format: data[0]}
+	entries := make([]lru.Entry, d.count())
+	for i := range entries {
+		d.entry(&entries[i])
+	}
+	return entries, d.err
+}
+
+// EncodeRequest encodes a request in the binary format of the log.
+// Nodes pass requests on to each other in this format, as it keeps
+// every value as it is, while JSON does not keep bytes that are not
+// valid UTF-8.
+func EncodeRequest(r *request.CacheRequest) ([]byte, error) {
+	e := &encoder{buf: make([]byte, 0, 64)}
+	e.byte(commandFormat)
+	e.request(r)
+	return e.buf, e.err
+}
+
+// DecodeRequest decodes a request written by EncodeRequest.
+func DecodeRequest(data []byte) (request.CacheRequest, error) {
+	var r request.CacheRequest
+	if len(data) == 0 {
+		return r, errTruncated
+	}
+	if data[0] != commandFormat {
+		return r, fmt.Errorf("unsupported request format %d", data[0])
+	}
+	d := &decoder{data: data[1:], format: data[0]}
+	d.request(&r)
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d unexpected bytes after request", len(d.data))
+	}
+	return r, d.err
+}
+
+// EncodeResponse encodes the response to a request passed on in
+// the binary format.
+func EncodeResponse(res *response.CacheResponse) ([]byte, error) {
+	e := &encoder{buf: make([]byte, 0, 64)}
+	e.byte(commandFormat)
+	e.string(res.Gobj.Key)
+	e.value(res.Gobj.Value)
+	e.varint(res.Gobj.TTL)
+	e.uvarint(uint64(res.Gobj.Flags))
+	e.varint(int64(res.Status))
+	e.string(res.Message)
+	e.string(res.Error)
+	e.uvarint(res.Version)
+	return e.buf, e.err
+}
+
+// DecodeResponse decodes a response written by EncodeResponse.
+func DecodeResponse(data []byte) (response.CacheResponse, error) {
+	var res response.CacheResponse
+	if len(data) == 0 {
+		return res, errTruncated
+	}
+	if data[0] != commandFormat {
+		return res, fmt.Errorf("unsupported response format %d", data[0])
+	}
+	d := &decoder{data: data[1:], format: data[0]}
+	res.Gobj.Key = d.string()
+	res.Gobj.Value = d.value()
+	res.Gobj.TTL = d.varint()
+	res.Gobj.Flags = uint32(d.uvarint())
+	res.Status = int32(d.varint())
+	res.Message = d.string()
+	res.Error = d.string()
+	res.Version = d.uvarint()
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d unexpected bytes after response", len(d.data))
+	}
+	return res, d.err
+}
+
+// encoder appends values to a buffer. The first value that
+// cannot be encoded sets err, and later values are ignored.
+type encoder struct {
+	buf []byte
+	err error
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uvarint(u uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], u)]...)
+}
+
+func (e *encoder) varint(i int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], i)]...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *encoder) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.byte(valueNil)
+	case string:
+		e.byte(valueString)
+		e.string(v)
+	case []byte:
+		e.byte(valueBytes)
+		e.bytes(v)
+	case bool:
+		e.byte(valueBool)
+		e.bool(v)
+	case int:
+		e.byte(valueInt)
+		e.varint(int64(v))
+	case int8:
+		e.byte(valueInt8)
+		e.varint(int64(v))
+	case int16:
+		e.byte(valueInt16)
+		e.varint(int64(v))
+	case int32:
+		e.byte(valueInt32)
+		e.varint(int64(v))
+	case int64:
+		e.byte(valueInt64)
+		e.varint(v)
+	case uint:
+		e.byte(valueUint)
+		e.uvarint(uint64(v))
+	case uint8:
+		e.byte(valueUint8)
+		e.uvarint(uint64(v))
+	case uint16:
+		e.byte(valueUint16)
+		e.uvarint(uint64(v))
+	case uint32:
+		e.byte(valueUint32)
+		e.uvarint(uint64(v))
+	case uint64:
+		e.byte(valueUint64)
+		e.uvarint(v)
+	case float32:
+		e.byte(valueFloat32)
+		e.buf = append(e.buf, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(e.buf[len(e.buf)-4:], math.Float32bits(v))
+	case float64:
+		e.byte(valueFloat64)
+		e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(v))
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			if e.err == nil {
+				e.err = fmt.Errorf("cannot encode value of type %T: %s", v, err)
+			}
+			return
+		}
+		e.byte(valueJSON)
+		e.bytes(b)
+	}
+}
+
+func (e *encoder) request(r *request.CacheRequest) {
+	e.string(r.Gobj.Key)
+	e.value(r.Gobj.Value)
+	e.varint(r.Gobj.TTL)
+	e.uvarint(uint64(r.Gobj.Flags))
+	e.uvarint(r.Version)
+	e.uvarint(r.Cas)
+	e.varint(r.Timestamp)
+	e.string(r.Consistency)
+}
+
+func (e *encoder) entry(entry *lru.Entry) {
+	e.string(entry.Key)
+	e.value(entry.Value)
+	e.varint(entry.TTL)
+	e.varint(entry.CreatedAt)
+	e.uvarint(entry.Version)
+	e.uvarint(uint64(entry.Flags))
+}
+
+func (e *encoder) migration(m *Migration) {
+	e.bool(m != nil)
+	if m == nil {
+		return
+	}
+	e.varint(int64(m.Slot))
+	e.varint(int64(m.From))
+	e.varint(int64(m.To))
+	e.string(m.State)
+	e.varint(int64(m.Moved))
+}
+
+func (e *encoder) command(c *Command) {
+	e.string(c.Cmd)
+	e.request(&c.Args)
+	e.uvarint(uint64(len(c.Entries)))
+	for i := range c.Entries {
+		e.entry(&c.Entries[i])
+	}
+	e.migration(c.Migration)
+	e.uvarint(uint64(len(c.Batch)))
+	for i := range c.Batch {
+		e.command(&c.Batch[i])
+	}
+}
+
+// decoder reads values from a buffer written in the given format.
+// The first value that cannot be decoded sets err, and later values
+// decode as zero values.
+type decoder struct {
+	data   []byte
+	format byte
+	err    error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) < 1 {
+		d.fail(errTruncated)
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	u, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.data = d.data[n:]
+	return u
+}
+
+func (d *decoder) varint() int64 {
+	i, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.data = d.data[n:]
+	return i
+}
+
+// count reads the length of a list. Every element takes at least a
+// byte, so a corrupt length cannot allocate more than the entry holds.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail(errTruncated)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) fixed(n int) []byte {
+	if len(d.data) < n {
+		d.fail(errTruncated)
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) value() interface{} {
+	switch tag := d.byte(); tag {
+	case valueNil:
+		return nil
+	case valueString:
+		return d.string()
+	case valueBytes:
+		return d.bytes()
+	case valueBool:
+		return d.bool()
+	case valueInt:
+		return int(d.varint())
+	case valueInt8:
+		return int8(d.varint())
+	case valueInt16:
+		return int16(d.varint())
+	case valueInt32:
+		return int32(d.varint())
+	case valueInt64:
+		return d.varint()
+	case valueUint:
+		return uint(d.uvarint())
+	case valueUint8:
+		return uint8(d.uvarint())
+	case valueUint16:
+		return uint16(d.uvarint())
+	case valueUint32:
+		return uint32(d.uvarint())
+	case valueUint64:
+		return d.uvarint()
+	case valueFloat32:
+		return math.Float32frombits(binary.LittleEndian.Uint32(d.fixed(4)))
+	case valueFloat64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(d.fixed(8)))
+	case valueJSON:
+		var v interface{}
+		if err := json.Unmarshal(d.bytes(), &v); err != nil && d.err == nil {
+			d.fail(fmt.Errorf("bad JSON value: %s", err))
+		}
+		return v
+	default:
+		d.fail(fmt.Errorf("unknown value type %d", tag))
+		return nil
+	}
+}
+
+func (d *decoder) request(r *request.CacheRequest) {
+	r.Gobj.Key = d.string()
+	r.Gobj.Value = d.value()
+	r.Gobj.TTL = d.varint()
+	if d.format >= 2 {
+		r.Gobj.Flags = uint32(d.uvarint())
+	}
+	r.Version = d.uvarint()
+	if d.format >= 2 {
+		r.Cas = d.uvarint()
+	}
+	r.Timestamp = d.varint()
+	r.Consistency = d.string()
+}
+
+func (d *decoder) entry(entry *lru.Entry) {
+	entry.Key = d.string()
+	entry.Value = d.value()
+	entry.TTL = d.varint()
+	entry.CreatedAt = d.varint()
+	entry.Version = d.uvarint()
+	if d.format >= 2 {
+		entry.Flags = uint32(d.uvarint())
+	}
+}
+
+func (d *decoder) migration() *Migration {
+	if !d.bool() {
+		return nil
+	}
+	return &Migration{
+		Slot:  int(d.varint()),
+		From:  int(d.varint()),
+		To:    int(d.varint()),
+		State: d.string(),
+		Moved: int(d.varint()),
+	}
+}
+
+func (d *decoder) command(c *Command) {
+	c.Cmd = d.string()
+	d.request(&c.Args)
+	if n := d.count(); n > 0 {
+		c.Entries = make([]lru.Entry, n)
+		for i := range c.Entries {
+			d.entry(&c.Entries[i])
+		}
+	}
+	c.Migration = d.migration()
+	if n := d.count(); n > 0 {
+		c.Batch = make([]Command, n)
+		for i := range c.Batch {
+			d.command(&c.Batch[i])
+		}
+	}
+}
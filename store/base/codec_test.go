@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/object"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestCommandCodec(t *testing.T) {
+	values := []interface{}{
+		nil, "London", []byte{0, 1, 255}, true, -3, int8(-8), int16(16), int32(-32), int64(1 << 40),
+		uint(3), uint8(8), uint16(16), uint32(32), uint64(1 << 63), float32(1.5), 2.25,
+		map[string]interface{}{"city": "Dublin", "population": 1.2e6},
+	}
+
+	// Every value is decoded with the type it was written with
+	for _, value := range values {
+		args := request.NewRequestFromValues("England", value, 60)
+		args.Version = 12
+		args.Timestamp = 1600000000
+		args.Consistency = request.CONSISTENCY_LEADER
+		args.Gobj.Flags = 7
+		args.Cas = 9
+		c := &Command{Cmd: STORE_PUT, Args: args}
+
+		b, err := encodeCommand(c)
+		utils.AssertEqual(t, err, nil, "")
+		decoded, err := decodeCommand(b)
+		utils.AssertEqual(t, err, nil, "")
+		utils.AssertEqual(t, reflect.DeepEqual(decoded, c), true, "")
+	}
+
+	c := &Command{
+		Cmd:       STORE_BATCH,
+		Batch:     []Command{
+			{Cmd: STORE_IMPORT, Args: request.NewEmptyRequest(), Entries: []lru.Entry{{Key: "France", Value: []byte("Paris"), TTL: -1, CreatedAt: 7, Version: 3, Flags: 1}}},
+			{Cmd: STORE_SET_MIGRATION, Args: request.NewEmptyRequest(), Migration: &Migration{Slot: 5, From: 1, To: 2, State: MIGRATION_COPYING, Moved: 9}},
+		},
+	}
+	b, err := encodeCommand(c)
+	utils.AssertEqual(t, err, nil, "")
+	decoded, err := decodeCommand(b)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(decoded, c), true, "")
+
+	// The binary encoding is smaller than JSON
+	put := &Command{Cmd: STORE_PUT, Args: request.NewRequestFromValues("England", "London", -1)}
+	b, _ = encodeCommand(put)
+	j, _ := json.Marshal(put)
+	utils.AssertEqual(t, len(b) < len(j)/2, true, "")
+}
+
+func TestDecodeCommandErrors(t *testing.T) {
+	// Entries written as JSON before the binary encoding are replayed
+	j, _ := json.Marshal(&Command{Cmd: STORE_PUT, Args: request.NewRequestFromValues("England", "London", -1)})
+	c, err := decodeCommand(j)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, c.Args.Gobj.Value, "London", "")
+
+	// Entries of format 1, which had no flags or cas, are replayed
+	c, err = decodeCommand([]byte{1, 3, 'p', 'u', 't', 1, 'a', valueString, 1, 'b', 1, 4, 6, 0, 0, 0, 0})
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(c, &Command{Cmd: STORE_PUT, Args: request.CacheRequest{
+		Gobj:      object.NewCacheObjectFromParams("a", "b", -1),
+		Version:   4,
+		Timestamp: 3,
+	}}), true, "")
+
+	b, _ := encodeCommand(&Command{Cmd: STORE_PUT, Args: request.NewRequestFromValues("England", "London", -1)})
+	for _, data := range [][]byte{nil, {99}, b[:len(b)-3], append(append([]byte{}, b...), 0)} {
+		_, err := decodeCommand(data)
+		utils.AssertEqual(t, err != nil, true, "")
+	}
+
+	// An entry of an unknown format is an error, not a panic
+	conf := config.InitializeConfiguration()
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+	res, ok := (*fsm)(store).Apply(&raft.Log{Index: 1, Data: []byte{99, 1, 2}}).(response.CacheResponse)
+	utils.AssertEqual(t, ok, true, "")
+	utils.AssertEqual(t, res.Error, response.INVALID_ENTRY_ERR, "")
+}
+
+func TestRequestAndResponseCodec(t *testing.T) {
+	// Requests passed on between nodes keep bytes that are not UTF-8
+	args := request.NewRequestFromValues("England", []byte{0xff, 0xfe, 0}, 60)
+	args.Gobj.Flags = 7
+	args.Cas = 3
+	b, err := EncodeRequest(&args)
+	utils.AssertEqual(t, err, nil, "")
+	r, err := DecodeRequest(b)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(r, args), true, "")
+
+	res := response.NewResponseFromValue("London\xff")
+	res.Gobj.Key = "England"
+	res.Gobj.Flags = 7
+	res.Version = 12
+	b, err = EncodeResponse(&res)
+	utils.AssertEqual(t, err, nil, "")
+	decoded, err := DecodeResponse(b)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(decoded, res), true, "")
+
+	for _, data := range [][]byte{nil, {99}, b[:len(b)-1], append(append([]byte{}, b...), 0)} {
+		_, err := DecodeResponse(data)
+		utils.AssertEqual(t, err != nil, true, "")
+	}
+}
+
+func TestSnapshotKeepsValueTypes(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	leader := NewStore(LRU_TYPE)
+	leader.BuildStore(conf)
+	leader.Cache.Put(request.NewRequestFromValues("England", []byte("London"), -1))
+	leader.Cache.Put(request.NewRequestFromValues("Ireland", int64(5), -1))
+	flagged := request.NewRequestFromValues("Wales", "Cardiff", -1)
+	flagged.Gobj.Flags = 3
+	leader.Cache.Put(flagged)
+
+	snapshot, err := (*fsm)(leader).Snapshot()
+	utils.AssertEqual(t, err, nil, "")
+	sink := &memorySink{}
+	utils.AssertEqual(t, snapshot.Persist(sink), nil, "")
+
+	follower := NewStore(LRU_TYPE)
+	follower.BuildStore(conf)
+	err = (*fsm)(follower).Restore(ioutil.NopCloser(bytes.NewReader(sink.Bytes())))
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(follower.Cache.Entries(), leader.Cache.Entries()), true, "")
+
+	// Snapshots of format 1 are still restored
+	bs := []byte(`{"Format": 1, "Policy": "LRU", "Entries": [{"Key": "France", "Value": "Paris", "TTL": -1}]}`)
+	err = (*fsm)(follower).Restore(ioutil.NopCloser(bytes.NewReader(bs)))
+	utils.AssertEqual(t, err, nil, "")
+	x := follower.Execute("get", request.NewRequestFromValues("France", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "Paris", "")
+}
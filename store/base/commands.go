@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/cache"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+const (
+	EXISTS      = "EXISTS"      // A cas found the key-value pair at another version
+	NON_NUMERIC = "NON_NUMERIC" // An incr or decr found a value that is not a number
+)
+
+// conditionalCommands are the replicated commands that may leave
+// the cache as it was, which they report with a status of 0.
+var conditionalCommands = map[string]bool{
+	STORE_EXPIRE:  true,
+	STORE_REPLACE: true,
+	STORE_TOUCH:   true,
+	STORE_CAS:     true,
+	STORE_INCR:    true,
+	STORE_DECR:    true,
+}
+
+// storedCommands are the commands that store a key-value pair,
+// which is versioned by the log entry of the command.
+var storedCommands = map[string]bool{
+	STORE_PUT:     true,
+	STORE_ADD:     true,
+	STORE_REPLACE: true,
+	STORE_TOUCH:   true,
+	STORE_CAS:     true,
+	STORE_INCR:    true,
+	STORE_DECR:    true,
+}
+
+// derivedCommands are the commands that store a key-value pair
+// derived from the one they found, and return the pair they stored.
+var derivedCommands = map[string]bool{
+	STORE_TOUCH: true,
+	STORE_INCR:  true,
+	STORE_DECR:  true,
+}
+
+// replace writes a key-value pair if the key holds one that has not
+// expired. The key is checked as the write is applied, so no other
+// write can come between them.
+func (store *Store) replace(args request.CacheRequest) response.CacheResponse {
+	c := store.cache()
+	if current, ok := c.Peek(args.Gobj.Key); !ok || current.Expired(lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_STORED, 0)
+	}
+	return c.Put(args)
+}
+
+// cas writes a key-value pair if the key holds one that has not
+// expired and is at the version given by the Cas of the request.
+func (store *Store) cas(args request.CacheRequest) response.CacheResponse {
+	c := store.cache()
+	current, ok := c.Peek(args.Gobj.Key)
+	if !ok || current.Expired(lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_FOUND, 0)
+	}
+	if current.Version != args.Cas {
+		return response.NewResponseFromMessage(EXISTS, 0)
+	}
+	return c.Put(args)
+}
+
+// touch sets the TTL of a key-value pair that has not expired,
+// counted from the time of the touch, and returns the pair.
+func (store *Store) touch(args request.CacheRequest) response.CacheResponse {
+	c := store.cache()
+	current, ok := c.Peek(args.Gobj.Key)
+	if !ok || current.Expired(lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_FOUND, 0)
+	}
+	args.Gobj.Value = current.Value
+	args.Gobj.Flags = current.Flags
+	return storeDerived(c, args)
+}
+
+func (store *Store) incr(args request.CacheRequest) response.CacheResponse {
+	return store.counter(args, false)
+}
+
+func (store *Store) decr(args request.CacheRequest) response.CacheResponse {
+	return store.counter(args, true)
+}
+
+// counter adds the amount given as the value of the request to a
+// key-value pair holding an unsigned decimal number, or subtracts
+// it, and returns the pair. As in memcached, an increment wraps
+// around at 2^64 and a decrement stops at 0. The pair keeps its
+// flags and the time it expires.
+func (store *Store) counter(args request.CacheRequest, decr bool) response.CacheResponse {
+	c := store.cache()
+	now := lru.RequestTime(args)
+	current, ok := c.Peek(args.Gobj.Key)
+	if !ok || current.Expired(now) {
+		return response.NewResponseFromMessage(lru.NOT_FOUND, 0)
+	}
+	n, ok := ParseCounter(current.Value)
+	amount, valid := ParseCounter(args.Gobj.Value)
+	if !ok || !valid {
+		return response.NewResponseFromMessage(NON_NUMERIC, 0)
+	}
+
+	if !decr {
+		n += amount
+	} else if amount > n {
+		n = 0
+	} else {
+		n -= amount
+	}
+	args.Gobj.Value = strconv.FormatUint(n, 10)
+	args.Gobj.Flags = current.Flags
+	if args.Gobj.TTL = -1; current.TTL != -1 {
+		args.Gobj.TTL = current.CreatedAt + current.TTL - now
+	}
+	return storeDerived(c, args)
+}
+
+// storeDerived puts a key-value pair and returns it.
+func storeDerived(c cache.Cache, args request.CacheRequest) response.CacheResponse {
+	if res := c.Put(args); res.Status != 1 {
+		return res
+	}
+	res := response.NewResponseFromValue(args.Gobj.Value)
+	res.Gobj = args.Gobj
+	return res
+}
+
+// ParseCounter returns the unsigned number held by a value, which
+// is a decimal string as stored by incr, or a number.
+func ParseCounter(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case string:
+		n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		return n, err == nil
+	case []byte:
+		return ParseCounter(string(v))
+	case uint64:
+		return v, true
+	case uint32:
+		return uint64(v), true
+	case uint:
+		return uint64(v), true
+	case int64:
+		return uint64(v), v >= 0
+	case int32:
+		return uint64(v), v >= 0
+	case int:
+		return uint64(v), v >= 0
+	case float64:
+		return uint64(v), v >= 0 && v <= math.MaxUint64 && v == math.Trunc(v)
+	}
+	return 0, false
+}
+
+// ttl returns the number of seconds until a key-value pair expires,
+// or -1 if it does not, without counting as a use of it.
+func (store *Store) ttl(args request.CacheRequest) response.CacheResponse {
+	now := time.Now().Unix()
+	current, ok := store.cache().Peek(args.Gobj.Key)
+	if !ok || current.Expired(now) {
+		return response.NewCacheMissResponse()
+	}
+	if current.TTL == -1 {
+		return response.NewResponseFromValue(int64(-1))
+	}
+	return response.NewResponseFromValue(current.CreatedAt + current.TTL - now)
+}
+
+// gets reads a key-value pair as get does, and returns it with its
+// flags, the seconds until it expires, or -1, and its version.
+func (store *Store) gets(args request.CacheRequest) response.CacheResponse {
+	c := store.cache()
+	if res := c.Get(args); res.Status != 1 {
+		return res
+	}
+	now := time.Now().Unix()
+	current, ok := c.Peek(args.Gobj.Key)
+	if !ok || current.Expired(now) {
+		return response.NewCacheMissResponse()
+	}
+
+	res := response.NewResponseFromValue(current.Value)
+	res.Gobj.Key = current.Key
+	res.Gobj.Flags = current.Flags
+	if current.TTL != -1 {
+		res.Gobj.TTL = current.CreatedAt + current.TTL - now
+	}
+	res.Version = current.Version
+	return res
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package base
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestConditionalCommands(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	dir, _ := ioutil.TempDir("", "store_test")
+	store := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer store.Close()
+	clusterLeader(t, []*Store{store})
+
+	// Keys that do not exist are not replaced or touched
+	x := store.Execute("replace", request.NewRequestFromValues("England", "London", -1))
+	utils.AssertEqual(t, x.Message, lru.NOT_STORED, "")
+	x = store.Execute("touch", request.NewRequestFromValues("England", nil, 60))
+	utils.AssertEqual(t, x.Message, lru.NOT_FOUND, "")
+	x = store.Execute("ttl", request.NewRequestFromValues("England", nil, -1))
+	utils.AssertEqual(t, x.Status, int32(0), "")
+
+	store.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	x = store.Execute("ttl", request.NewRequestFromValues("England", nil, -1))
+	utils.AssertEqual(t, x.Gobj.Value, int64(-1), "")
+
+	x = store.Execute("replace", request.NewRequestFromValues("England", "Manchester", -1))
+	utils.AssertEqual(t, x.Status, int32(1), "")
+	utils.AssertEqual(t, store.Execute("get", request.NewRequestFromValues("England", nil, -1)).Gobj.Value, "Manchester", "")
+
+	// A touch keeps the value and returns it
+	x = store.Execute("touch", request.NewRequestFromValues("England", nil, 60))
+	utils.AssertEqual(t, x.Gobj.Value, "Manchester", "")
+	x = store.Execute("ttl", request.NewRequestFromValues("England", nil, -1))
+	remaining, _ := x.Gobj.Value.(int64)
+	utils.AssertEqual(t, remaining >= 59 && remaining <= 60, true, "")
+	utils.AssertEqual(t, store.Execute("get", request.NewRequestFromValues("England", nil, -1)).Gobj.Value, "Manchester", "")
+}
+
+func TestCasAndCounters(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	dir, _ := ioutil.TempDir("", "store_test")
+	store := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer store.Close()
+	clusterLeader(t, []*Store{store})
+
+	// gets returns the flags and version of a key-value pair
+	args := request.NewRequestFromValues("England", "London", 60)
+	args.Gobj.Flags = 4
+	store.Execute("put", args)
+	x := store.Execute("gets", request.NewRequestFromValues("England", nil, -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+	utils.AssertEqual(t, x.Gobj.Flags, uint32(4), "")
+	utils.AssertEqual(t, x.Gobj.TTL >= 59 && x.Gobj.TTL <= 60, true, "")
+	version := x.Version
+	utils.AssertEqual(t, version > 0, true, "")
+
+	// A cas is only stored at the version it read
+	cas := request.NewRequestFromValues("England", "Manchester", -1)
+	cas.Cas = version + 1
+	utils.AssertEqual(t, store.Execute("cas", cas).Message, EXISTS, "")
+	cas.Cas = version
+	utils.AssertEqual(t, store.Execute("cas", cas).Status, int32(1), "")
+	utils.AssertEqual(t, store.Execute("cas", cas).Message, EXISTS, "")
+	utils.AssertEqual(t, store.Execute("get", request.NewRequestFromValues("England", nil, -1)).Gobj.Value, "Manchester", "")
+	cas.Gobj.Key = "France"
+	utils.AssertEqual(t, store.Execute("cas", cas).Message, lru.NOT_FOUND, "")
+
+	// Counters keep their flags and expiry, and decrements stop at 0
+	args = request.NewRequestFromValues("Ireland", "10", 60)
+	args.Gobj.Flags = 2
+	store.Execute("put", args)
+	x = store.Execute("incr", request.NewRequestFromValues("Ireland", uint64(5), -1))
+	utils.AssertEqual(t, x.Gobj.Value, "15", "")
+	x = store.Execute("decr", request.NewRequestFromValues("Ireland", uint64(20), -1))
+	utils.AssertEqual(t, x.Gobj.Value, "0", "")
+	x = store.Execute("gets", request.NewRequestFromValues("Ireland", nil, -1))
+	utils.AssertEqual(t, x.Gobj.Value, "0", "")
+	utils.AssertEqual(t, x.Gobj.Flags, uint32(2), "")
+	utils.AssertEqual(t, x.Gobj.TTL >= 59 && x.Gobj.TTL <= 60, true, "")
+
+	store.Execute("put", request.NewRequestFromValues("Ireland", "18446744073709551615", -1))
+	x = store.Execute("incr", request.NewRequestFromValues("Ireland", uint64(2), -1))
+	utils.AssertEqual(t, x.Gobj.Value, "1", "")
+
+	utils.AssertEqual(t, store.Execute("incr", request.NewRequestFromValues("England", uint64(1), -1)).Message, NON_NUMERIC, "")
+	utils.AssertEqual(t, store.Execute("incr", request.NewRequestFromValues("Wales", uint64(1), -1)).Message, lru.NOT_FOUND, "")
+}
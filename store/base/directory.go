@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// SetPeerGroups records the data groups hosted by the node with the
+// given Raft ID. In a sharded cluster it is called on the leader of
+// the meta group, whose directory every node routes requests with.
+func (store *Store) SetPeerGroups(nodeID string, groups []int) error {
+	res := store.apply(&Command{
+		Cmd: STORE_SET_GROUPS,
+		Args: request.NewRequestFromValues(nodeID, FormatGroups(groups), -1),
+	})
+	if res.Error == response.NOT_LEADER_ERR {
+		return raft.ErrNotLeader
+	} else if res.Status != 1 {
+		return fmt.Errorf("failed to record the groups of %s: %s", nodeID, res.Message)
+	}
+	return nil
+}
+
+// setGroups applies a replicated list of the groups a node hosts.
+func (store *Store) setGroups(args request.CacheRequest) response.CacheResponse {
+	value, ok := args.Gobj.Value.(string)
+	if !ok {
+		return response.NewResponseFromMessage("Groups must be a string", 0)
+	}
+	groups, err := ParseGroups(value)
+	if err != nil {
+		return response.NewResponseFromMessage(err.Error(), 0)
+	}
+
+	store.mux.Lock()
+	if store.groups == nil {
+		store.groups = make(map[string][]int)
+	}
+	store.groups[args.Gobj.Key] = groups
+	store.mux.Unlock()
+
+	return response.NewResponseFromMessage("OK", 1)
+}
+
+// peerGroups returns a copy of the groups hosted by each node.
+func (store *Store) peerGroups() map[string][]int {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	groups := make(map[string][]int, len(store.groups))
+	for id, g := range store.groups {
+		groups[id] = append([]int(nil), g...)
+	}
+	return groups
+}
+
+// GroupMembers returns the HTTP addresses of the nodes the directory
+// records as hosting the given group, ordered by their Raft ID.
+func (store *Store) GroupMembers(group int) []string {
+	peers := store.peerHTTPAddrs()
+	ids := []string{}
+	for id, groups := range store.peerGroups() {
+		for _, g := range groups {
+			if g == group && peers[id] != "" {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	members := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, peers[id])
+	}
+	return members
+}
+
+// FormatGroups formats a list of groups as the comma separated
+// list accepted by ParseGroups.
+func FormatGroups(groups []int) string {
+	parts := make([]string, 0, len(groups))
+	for _, g := range groups {
+		parts = append(parts, strconv.Itoa(g))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseGroups parses a comma separated list of groups.
+func ParseGroups(s string) ([]int, error) {
+	groups := []int{}
+	if strings.TrimSpace(s) == "" {
+		return groups, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		g, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || g < 0 {
+			return nil, fmt.Errorf("bad group %q", part)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+func sameGroups(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
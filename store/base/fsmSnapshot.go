@@ -1,24 +1,141 @@
 package base
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
 	"io"
-	
+	"sync/atomic"
+
 	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
 )
-type fsmSnapshot struct{}
 
-// Snapshot returns a snapshot of the key-value store.
+// fsmSnapshotFormat is the version of the format Persist writes.
+// Restore also reads snapshots of format 1, which held the key-value
+// pairs as JSON, and refuses snapshots written in any other format.
+const fsmSnapshotFormat = 2
+
+// fsmSnapshotState is the serialized state of the stores cache.
+type fsmSnapshotState struct {
+	// Format is the version of the snapshot format
+	Format     int
+
+	// Policy is the cache policy of the node that took the snapshot
+	Policy     string
+
+	// Entries are the key-value pairs in the cache, the next to be
+	// evicted first, so replaying them in order rebuilds recency.
+	// From format 2 they are binary encoded in EntryData instead,
+	// so their values keep their types.
+	Entries    []lru.Entry `json:",omitempty"`
+	EntryData  []byte      `json:",omitempty"`
+
+	// Peers maps the Raft ID of each node to its HTTP address
+	Peers      map[string]string
+
+	// Groups maps the Raft ID of each node to the data
+	// groups it hosts, in the meta group of a sharded cluster
+	Groups     map[string][]int
+
+	// Slots maps each slot moved between data groups to the group
+	// that owns it, and Migrations each such slot to its latest
+	// migration, in the meta group of a sharded cluster
+	Slots      map[int]int
+	Migrations map[int]Migration
+
+	// Checkpoints maps each cluster replicating to this one to
+	// the index of the last of its log entries applied here
+	Checkpoints map[string]uint64 `json:",omitempty"`
+}
+
+type fsmSnapshot struct {
+	state fsmSnapshotState
+}
+
+// Snapshot returns a snapshot of the key-value store. The cache
+// is copied here, as Persist runs concurrently with Apply.
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	// TODO: implement
-	log.Printf("snapshot")
-	return &fsmSnapshot{}, nil
+	store := (*Store)(f)
+	// The log is compacted after each snapshot, and the journal
+	// keeps no more of it than the log did before.
+	if store.logStore != nil {
+		if first, err := store.logStore.FirstIndex(); err == nil {
+			store.journalMux.Lock()
+			store.trimJournal(first, atomic.LoadUint64(&store.applied))
+			store.journalMux.Unlock()
+		}
+	}
+	data, err := encodeEntries(store.cache().Entries())
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{
+		state: fsmSnapshotState{
+			Format:      fsmSnapshotFormat,
+			Policy:      store.policy,
+			EntryData:   data,
+			Peers:       store.peerHTTPAddrs(),
+			Groups:      store.peerGroups(),
+			Slots:       store.slotOwners(),
+			Migrations:  store.slotMigrations(),
+			Checkpoints: store.replicationCheckpoints(),
+		},
+	}, nil
 }
 
-// Restore stores the key-value store to a previous state.
+// Restore stores the key-value store to a previous state. The
+// snapshot is replayed into a new cache which then replaces the
+// stores cache, so the store never serves a partial restore.
+// Snapshots hold the key-value pairs in the order their policy
+// evicts them, but not the rest of the state of the policy, so the
+// LFU frequencies, the ARC lists and target size and the TinyLFU
+// sketch start over, and are learnt again from the reads that follow.
 func (f *fsm) Restore(rc io.ReadCloser) error {
-	// TODO: implement
-	log.Printf("restore [%v]", rc)
+	defer rc.Close()
+
+	var state fsmSnapshotState
+	if err := json.NewDecoder(rc).Decode(&state); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %s", err)
+	}
+	switch state.Format {
+	case 1:
+	case fsmSnapshotFormat:
+		entries, err := decodeEntries(state.EntryData)
+		if err != nil {
+			return fmt.Errorf("failed to decode snapshot: %s", err)
+		}
+		state.Entries = entries
+	default:
+		return fmt.Errorf("unsupported snapshot format %d", state.Format)
+	}
+
+	store := (*Store)(f)
+	c := store.newCacheFromPolicy(store.policy)
+	if c == nil {
+		return fmt.Errorf("unknown cache policy %q", store.policy)
+	}
+
+	// Each key-value pair keeps its original creation time and
+	// version, so it expires as it would have on the leader.
+	for _, entry := range state.Entries {
+		args := request.NewRequestFromValues(entry.Key, entry.Value, entry.TTL)
+		args.Gobj.Flags = entry.Flags
+		args.Version = entry.Version
+		args.Timestamp = entry.CreatedAt
+		c.Put(args)
+	}
+
+	store.swapCache(c)
+	store.resetJournal()
+
+	store.mux.Lock()
+	store.peers = state.Peers
+	store.groups = state.Groups
+	store.slots = state.Slots
+	store.migrations = state.Migrations
+	store.checkpoints = state.Checkpoints
+	store.mux.Unlock()
 	return nil
 }
 
@@ -26,10 +143,8 @@ func (f *fsm) Restore(rc io.ReadCloser) error {
 // parameters: (a raft.SnapshotSink used to write snapshots)
 // returns: error
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
-	// TODO: implement
 	err := func() error {
-		b := []byte("hello from persist")
-		if _, err := sink.Write(b); err != nil {
+		if err := json.NewEncoder(sink).Encode(&f.state); err != nil {
 			return err
 		}
 		return sink.Close()
@@ -40,4 +155,4 @@ func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	return err
 }
 
-func (f *fsmSnapshot) Release() {}
\ No newline at end of file
+func (f *fsmSnapshot) Release() {}
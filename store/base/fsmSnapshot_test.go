@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// memorySink is a raft.SnapshotSink that keeps the snapshot in memory
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "memory" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+func TestFsmSnapshotRestore(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.KeyspaceSize = 3
+
+	leader := NewStore(LRU_TYPE)
+	leader.BuildStore(conf)
+
+	for i, kv := range [][]string{{"England", "London"}, {"Ireland", "Dublin"}, {"France", "Paris"}} {
+		args := request.NewRequestFromValues(kv[0], kv[1], 60)
+		args.Version = uint64(i + 1)
+		args.Timestamp = time.Now().Unix() - 10 + int64(i)
+		leader.Cache.Put(args)
+	}
+	// England becomes the most recently used
+	leader.Cache.Get(request.NewRequestFromValues("England", "", -1))
+	leader.setPeer(request.NewRequestFromValues("node0", "127.0.0.1:7991", -1))
+
+	snapshot, err := (*fsm)(leader).Snapshot()
+	utils.AssertEqual(t, err, nil, "")
+	sink := &memorySink{}
+	utils.AssertEqual(t, snapshot.Persist(sink), nil, "")
+
+	follower := NewStore(LRU_TYPE)
+	follower.BuildStore(conf)
+	follower.Cache.Put(request.NewRequestFromValues("Italy", "Rome", -1))
+	err = (*fsm)(follower).Restore(ioutil.NopCloser(bytes.NewReader(sink.Bytes())))
+	utils.AssertEqual(t, err, nil, "")
+
+	// The follower has exactly the leaders keys, values, TTLs,
+	// creation times, versions and recency order.
+	utils.AssertEqual(t, reflect.DeepEqual(follower.Cache.Entries(), leader.Cache.Entries()), true, "")
+	utils.AssertEqual(t, follower.peerHTTPAddrs()["node0"], "127.0.0.1:7991", "")
+
+	x := follower.Execute("get", request.NewRequestFromValues("Italy", "", -1))
+	utils.AssertEqual(t, x.Message, lru.CACHE_MISS, "")
+
+	// Ireland is the least recently used, so it is evicted first
+	follower.Cache.Put(request.NewRequestFromValues("Spain", "Madrid", -1))
+	x = follower.Execute("get", request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, x.Message, lru.CACHE_MISS, "")
+	x = follower.Execute("get", request.NewRequestFromValues("France", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "Paris", "")
+}
+
+func TestFsmRestoreUnknownFormat(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+	store.Cache.Put(request.NewRequestFromValues("England", "London", -1))
+
+	bs := []byte(`{"Format": 99, "Policy": "LRU", "Entries": []}`)
+	err := (*fsm)(store).Restore(ioutil.NopCloser(bytes.NewReader(bs)))
+	utils.AssertEqual(t, err != nil, true, "")
+
+	// A failed restore leaves the cache untouched
+	x := store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+}
+
+// freeRaftAddr returns a local address no one is listening on
+func freeRaftAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestJoinAfterLogCompaction(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	compact := func(c *raft.Config) {
+		c.TrailingLogs = 0
+	}
+
+	leader := NewStore(LRU_TYPE)
+	leader.BuildStore(conf)
+	leader.RaftDir, _ = ioutil.TempDir("", "store_test")
+	leader.RaftBind = freeRaftAddr(t)
+	leader.configureRaft = compact
+	if err := leader.Open(true, "node0"); err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer leader.Raft.Shutdown()
+	if !waitFor(10*time.Second, leader.IsLeader) {
+		t.Fatalf("no leader elected")
+	}
+
+	leader.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	leader.Execute("put", request.NewRequestFromValues("Ireland", "Dublin", 60))
+	leader.Execute("put", request.NewRequestFromValues("France", "Paris", -1))
+	leader.Execute("delete", request.NewRequestFromValues("France", "", -1))
+
+	// Compact the log, so the follower can only catch up
+	// by installing the snapshot.
+	if err := leader.Raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+
+	follower := NewStore(LRU_TYPE)
+	follower.BuildStore(conf)
+	follower.RaftDir, _ = ioutil.TempDir("", "store_test")
+	follower.RaftBind = freeRaftAddr(t)
+	if err := follower.Open(false, "node1"); err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer follower.Raft.Shutdown()
+
+	if err := leader.Join("node1", follower.RaftBind); err != nil {
+		t.Fatalf("failed to join: %s", err)
+	}
+
+	caughtUp := waitFor(10*time.Second, func() bool {
+		return len(follower.cache().Entries()) == 2
+	})
+	utils.AssertEqual(t, caughtUp, true, "")
+	utils.AssertEqual(t, reflect.DeepEqual(follower.cache().Entries(), leader.cache().Entries()), true, "")
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ServerStatus is the status of a server in the cluster.
+type ServerStatus struct {
+	// ID is the Raft ID of the server
+	ID           string
+
+	// RaftAddr is the address the server serves Raft on
+	RaftAddr     string
+
+	// HTTPAddr is the address the server serves clients on,
+	// or empty if the server never gave it.
+	HTTPAddr     string
+
+	// Suffrage is one of Voter, Nonvoter or Staging. Nonvoters
+	// are learners, which replicate the log but do not vote.
+	Suffrage     string
+
+	// Leader reports if the server is the leader
+	Leader       bool
+
+	// LastContact is the time, in milliseconds, since the server
+	// last heard from the leader. It is 0 for the leader, and -1
+	// if the server never heard from a leader or did not answer.
+	LastContact  int64
+
+	// AppliedIndex is the index of the last log entry the
+	// server applied to its cache.
+	AppliedIndex uint64
+
+	// Reachable reports if the status was read from the server
+	// itself, rather than only from the cluster configuration.
+	Reachable    bool
+}
+
+// addServer adds a node to the cluster with the given suffrage.
+// A voter that joins again, such as after a restart, stays a voter
+// even if it asks to join as a learner. It must be called on the leader.
+func (store *Store) addServer(nodeID string, addr string, suffrage raft.ServerSuffrage) error {
+	fmt.Printf("received join request for remote node %s at %s\n", nodeID, addr)
+
+	configFuture := store.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		fmt.Printf("failed to get raft configuration: %v", err)
+		return err
+	}
+
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(nodeID) && srv.Suffrage == raft.Voter {
+			suffrage = raft.Voter
+		}
+		// If a node already exists with either the joining node's ID or address,
+		// that node may need to be removed from the config first.
+		if srv.ID == raft.ServerID(nodeID) || srv.Address == raft.ServerAddress(addr) {
+			// However if *both* the ID and the address are the same, then nothing -- not even
+			// a join operation -- is needed, unless a learner joins as a voter, which
+			// promotes it without removing it.
+			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(nodeID) {
+				if srv.Suffrage == suffrage {
+					fmt.Printf("node %s at %s already member of cluster, ignoring join request", nodeID, addr)
+					return nil
+				}
+				continue
+			}
+
+			future := store.Raft.RemoveServer(srv.ID, 0, 0)
+			if err := future.Error(); err != nil {
+				return fmt.Errorf("error removing existing node %s at %s: %s", nodeID, addr, err)
+			}
+		}
+	}
+
+	var f raft.IndexFuture
+	if suffrage == raft.Nonvoter {
+		f = store.Raft.AddNonvoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
+	} else {
+		f = store.Raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
+	}
+	if f.Error() != nil {
+		return f.Error()
+	}
+	fmt.Printf("node %s at %s joined successfully as a %s", nodeID, addr, suffrage)
+	return nil
+}
+
+// AddLearner joins a node to the cluster as a learner. Learners
+// replicate the log, so they can serve stale reads, but do not vote
+// in elections or count towards committing writes.
+func (store *Store) AddLearner(nodeID string, addr string) error {
+	return store.addServer(nodeID, addr, raft.Nonvoter)
+}
+
+// Promote makes a learner a voter. The leader catches it up before
+// it counts towards the quorum. It must be called on the leader.
+func (store *Store) Promote(nodeID string) error {
+	configFuture := store.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID != raft.ServerID(nodeID) {
+			continue
+		}
+		if srv.Suffrage == raft.Voter {
+			return nil
+		}
+		if err := store.Raft.AddVoter(srv.ID, srv.Address, 0, 0).Error(); err != nil {
+			return err
+		}
+		fmt.Printf("node %s at %s promoted to a voter", srv.ID, srv.Address)
+		return nil
+	}
+	return fmt.Errorf("node %s is not a member of the cluster", nodeID)
+}
+
+// Remove removes a node from the cluster. It must be called on the
+// leader. A leader that removes itself steps down once the removal
+// is committed.
+func (store *Store) Remove(nodeID string) error {
+	configFuture := store.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(nodeID) {
+			if err := store.Raft.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+				return err
+			}
+			fmt.Printf("node %s at %s removed successfully", srv.ID, srv.Address)
+			return nil
+		}
+	}
+	return fmt.Errorf("node %s is not a member of the cluster", nodeID)
+}
+
+// PeersList returns the IDs of the servers in the cluster,
+// in the order of the cluster configuration.
+func (store *Store) PeersList() ([]string, error) {
+	configFuture := store.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	peers := []string{}
+	for _, srv := range configFuture.Configuration().Servers {
+		peers = append(peers, string(srv.ID))
+	}
+	return peers, nil
+}
+
+// NodeStatus returns the status of this node as seen by itself.
+func (store *Store) NodeStatus() ServerStatus {
+	status := ServerStatus{
+		ID:           store.ServerID,
+		RaftAddr:     store.RaftBind,
+		HTTPAddr:     store.HTTPAddr,
+		Leader:       store.Raft.State() == raft.Leader,
+		LastContact:  -1,
+		AppliedIndex: store.Raft.AppliedIndex(),
+		Reachable:    true,
+	}
+
+	if status.Leader {
+		status.LastContact = 0
+	} else if last := store.Raft.LastContact(); !last.IsZero() {
+		status.LastContact = int64(time.Since(last) / time.Millisecond)
+	}
+	return status
+}
+
+// Status returns the status of every server in the cluster
+// configuration. Only this node's last contact and applied index
+// are known locally, the caller fills in those of the other servers
+// by asking them for their NodeStatus.
+func (store *Store) Status() ([]ServerStatus, error) {
+	configFuture := store.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	leader := store.Raft.Leader()
+	peers := store.peerHTTPAddrs()
+	servers := []ServerStatus{}
+	for _, srv := range configFuture.Configuration().Servers {
+		status := ServerStatus{
+			ID:          string(srv.ID),
+			RaftAddr:    string(srv.Address),
+			HTTPAddr:    peers[string(srv.ID)],
+			Suffrage:    srv.Suffrage.String(),
+			Leader:      srv.Address == leader,
+			LastContact: -1,
+		}
+		if string(srv.ID) == store.ServerID {
+			local := store.NodeStatus()
+			local.Suffrage = status.Suffrage
+			local.RaftAddr = status.RaftAddr
+			status = local
+		}
+		servers = append(servers, status)
+	}
+	return servers, nil
+}
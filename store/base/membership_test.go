@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestMembership(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	ids := []string{"node0", "node1", "node2"}
+	stores := make([]*Store, len(ids))
+	for i, id := range ids {
+		dir, _ := ioutil.TempDir("", "store_test")
+		stores[i] = openClusterNode(t, conf, dir, freeRaftAddr(t), id, i == 0)
+		defer stores[i].Close()
+	}
+	leader := clusterLeader(t, stores[:1])
+
+	if err := leader.Join("node1", stores[1].RaftBind); err != nil {
+		t.Fatalf("failed to join: %s", err)
+	}
+	if err := leader.AddLearner("node2", stores[2].RaftBind); err != nil {
+		t.Fatalf("failed to add learner: %s", err)
+	}
+
+	peers, err := leader.PeersList()
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(peers, ids), true, "")
+	utils.AssertEqual(t, GetNumericalID("node2", peers), 2, "")
+
+	servers, err := leader.Status()
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, len(servers), 3, "")
+	utils.AssertEqual(t, servers[0].Leader, true, "")
+	utils.AssertEqual(t, servers[0].Reachable, true, "")
+	utils.AssertEqual(t, servers[0].LastContact, int64(0), "")
+	utils.AssertEqual(t, servers[0].HTTPAddr, leader.HTTPAddr, "")
+	utils.AssertEqual(t, servers[1].Suffrage, "Voter", "")
+	utils.AssertEqual(t, servers[1].Leader, false, "")
+	utils.AssertEqual(t, servers[2].Suffrage, "Nonvoter", "")
+	utils.AssertEqual(t, servers[2].RaftAddr, stores[2].RaftBind, "")
+
+	// The learner replicates the log, and hears from the leader
+	caughtUp := waitFor(10*time.Second, func() bool {
+		status := stores[2].NodeStatus()
+		return status.AppliedIndex >= leader.Raft.LastIndex() && status.LastContact >= 0
+	})
+	utils.AssertEqual(t, caughtUp, true, "")
+	utils.AssertEqual(t, stores[2].NodeStatus().Leader, false, "")
+
+	// Only the leader changes the membership
+	utils.AssertEqual(t, stores[1].Remove("node2"), raft.ErrNotLeader, "")
+
+	if err := leader.Remove("node1"); err != nil {
+		t.Fatalf("failed to remove: %s", err)
+	}
+	peers, _ = leader.PeersList()
+	utils.AssertEqual(t, reflect.DeepEqual(peers, []string{"node0", "node2"}), true, "")
+	utils.AssertEqual(t, leader.Remove("node1") != nil, true, "")
+
+	// The leader can still commit writes on its own, as the
+	// learner does not count towards the quorum.
+	utils.AssertEqual(t, leader.Raft.Barrier(raftTimeout).Error(), nil, "")
+
+	// Learners serve stale reads, but not reads only the leader serves
+	leader.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	served := waitFor(10*time.Second, func() bool {
+		x := stores[2].Execute("get", request.NewRequestFromValues("England", "", -1))
+		return x.Gobj.Value == "London"
+	})
+	utils.AssertEqual(t, served, true, "")
+	args := request.NewRequestFromValues("England", "", -1)
+	args.Consistency = request.CONSISTENCY_LEADER
+	utils.AssertEqual(t, stores[2].Execute("get", args).Error, response.NOT_LEADER_ERR, "")
+
+	// A promoted learner votes
+	utils.AssertEqual(t, leader.Promote("node2"), nil, "")
+	utils.AssertEqual(t, leader.Promote("node1") != nil, true, "")
+	servers, err = leader.Status()
+	utils.AssertEqual(t, err, nil, "")
+	for _, server := range servers {
+		utils.AssertEqual(t, server.Suffrage, "Voter", "")
+	}
+	utils.AssertEqual(t, leader.Raft.Barrier(raftTimeout).Error(), nil, "")
+
+	// A promoted learner that joins again as a learner,
+	// such as after a restart, stays a voter
+	utils.AssertEqual(t, leader.AddLearner("node2", stores[2].RaftBind), nil, "")
+	servers, _ = leader.Status()
+	utils.AssertEqual(t, servers[1].ID, "node2", "")
+	utils.AssertEqual(t, servers[1].Suffrage, "Voter", "")
+}
+
+func TestMembershipWithTinyLFU(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	store := NewStore(WTINYLFU_TYPE)
+	store.BuildStore(conf)
+	store.RaftDir, _ = ioutil.TempDir("", "store_test")
+	store.RaftBind = freeRaftAddr(t)
+	if err := store.Open(true, "node0"); err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer store.Close()
+	clusterLeader(t, []*Store{store})
+
+	// Every policy evicts per replica, so any may be replicated
+	utils.AssertEqual(t, store.AddLearner("node1", freeRaftAddr(t)), nil, "")
+	servers, err := store.Status()
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, len(servers), 2, "")
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// Migration states
+const (
+	MIGRATION_COPYING  = "copying"  // The keys of the slot are being copied to the new group
+	MIGRATION_COMPLETE = "complete" // The new group owns the slot
+)
+
+// Migration is the progress of moving a slot from one data group to
+// another. It is recorded in the meta group of a sharded cluster, so
+// every node routes requests for the slot the same way, and so a new
+// leader of the meta group can resume the migration.
+type Migration struct {
+	Slot  int
+	From  int
+	To    int
+	State string
+
+	// Moved is the number of key-value pairs moved so far
+	Moved int
+}
+
+// Release is the result of releasing key-value pairs copied to
+// another group.
+type Release struct {
+	// Released is the number of key-value pairs removed
+	Released int
+
+	// Missing are the keys no longer held, which were deleted or
+	// expired since they were copied. Those written again since are
+	// neither removed nor missing, and are copied again.
+	Missing  []string
+}
+
+// Contains reports if the stores cache holds a key-value pair for
+// the key. On a follower it may lag the leader.
+func (store *Store) Contains(key string) bool {
+	return store.cache().Contains(key)
+}
+
+// SlotBatch is a batch of the key-value pairs of a slot.
+type SlotBatch struct {
+	Entries []lru.Entry
+
+	// Cursor is given to read the next batch of the scan,
+	// and is 0 once the scan has been read to the end.
+	Cursor  int
+}
+
+// SlotEntries returns a batch of up to limit of the key-value pairs
+// of a slot, whose keys match, read from cursor. A cursor of 0 scans
+// the cache for the keys of the slot, which the batches after it are
+// read from in turn, so the cache is scanned once for every batch of
+// the scan rather than once for each. Keys removed since the scan are
+// skipped, and keys written since are found by the next scan.
+func (store *Store) SlotEntries(slot int, match func(key string) bool, cursor int, limit int) SlotBatch {
+	store.scanMux.Lock()
+	keys, ok := store.scans[slot]
+	store.scanMux.Unlock()
+	if cursor == 0 || !ok || cursor > len(keys) {
+		keys = []string{}
+		for _, entry := range store.cache().Entries() {
+			if match(entry.Key) {
+				keys = append(keys, entry.Key)
+			}
+		}
+		cursor = 0
+	}
+
+	batch := SlotBatch{Entries: []lru.Entry{}}
+	for ; cursor < len(keys) && len(batch.Entries) < limit; cursor++ {
+		if entry, ok := store.cache().Peek(keys[cursor]); ok {
+			batch.Entries = append(batch.Entries, entry)
+		}
+	}
+
+	store.scanMux.Lock()
+	defer store.scanMux.Unlock()
+	if cursor == len(keys) {
+		delete(store.scans, slot)
+		return batch
+	}
+	if store.scans == nil {
+		store.scans = make(map[int][]string)
+	}
+	store.scans[slot] = keys
+	batch.Cursor = cursor
+	return batch
+}
+
+// ImportEntries writes a batch of key-value pairs copied from another
+// group through a single log entry. Each keeps its TTL and creation
+// time, and is versioned by the log entry, whose index is returned.
+// It must be called on the leader.
+func (store *Store) ImportEntries(entries []lru.Entry) (uint64, error) {
+	res := store.apply(&Command{Cmd: STORE_IMPORT, Entries: entries})
+	if res.Error == response.NOT_LEADER_ERR {
+		return 0, raft.ErrNotLeader
+	} else if res.Status != 1 {
+		return 0, fmt.Errorf("failed to import key-value pairs: %s", res.Message)
+	}
+	version, _ := res.Gobj.Value.(uint64)
+	return version, nil
+}
+
+// importEntries applies a replicated batch of key-value pairs.
+func (store *Store) importEntries(entries []lru.Entry, version uint64) response.CacheResponse {
+	c := store.cache()
+	for _, entry := range entries {
+		args := request.NewRequestFromValues(entry.Key, entry.Value, entry.TTL)
+		args.Gobj.Flags = entry.Flags
+		args.Version = version
+		args.Timestamp = entry.CreatedAt
+		c.Put(args)
+	}
+	return response.NewResponseFromValue(version)
+}
+
+// ReleaseEntries removes the key-value pairs of a batch copied to
+// another group, each only if it is still at the version it was
+// copied at. It must be called on the leader.
+func (store *Store) ReleaseEntries(entries []lru.Entry) (Release, error) {
+	res := store.apply(&Command{Cmd: STORE_RELEASE, Entries: entries})
+	if res.Error == response.NOT_LEADER_ERR {
+		return Release{}, raft.ErrNotLeader
+	} else if res.Status != 1 {
+		return Release{}, fmt.Errorf("failed to release key-value pairs: %s", res.Message)
+	}
+	release, _ := res.Gobj.Value.(Release)
+	return release, nil
+}
+
+// releaseEntries applies a replicated release of key-value pairs.
+func (store *Store) releaseEntries(entries []lru.Entry) response.CacheResponse {
+	c := store.cache()
+	release := Release{Missing: []string{}}
+	for _, entry := range entries {
+		if !c.Contains(entry.Key) {
+			release.Missing = append(release.Missing, entry.Key)
+		} else if c.ExpireByKey(entry.Key, entry.Version).Status == 1 {
+			release.Released++
+		}
+	}
+	return response.NewResponseFromValue(release)
+}
+
+// SetMigration records the progress of a slot migration. Recording
+// it complete moves the slot to its new group. It must be called on
+// the leader of the meta group.
+func (store *Store) SetMigration(m Migration) error {
+	res := store.apply(&Command{Cmd: STORE_SET_MIGRATION, Migration: &m})
+	if res.Error == response.NOT_LEADER_ERR {
+		return raft.ErrNotLeader
+	} else if res.Status != 1 {
+		return fmt.Errorf("failed to record the migration of slot %d: %s", m.Slot, res.Message)
+	}
+	return nil
+}
+
+// setMigration applies a replicated migration. The slot moves
+// in the same log entry that completes its migration, so no
+// node sees the migration complete and the slot unmoved.
+func (store *Store) setMigration(m *Migration) response.CacheResponse {
+	if m == nil {
+		return response.NewResponseFromMessage("Migration must be set", 0)
+	}
+
+	store.mux.Lock()
+	if store.migrations == nil {
+		store.migrations = make(map[int]Migration)
+	}
+	if store.slots == nil {
+		store.slots = make(map[int]int)
+	}
+	store.migrations[m.Slot] = *m
+	if m.State == MIGRATION_COMPLETE {
+		store.slots[m.Slot] = m.To
+	}
+	store.mux.Unlock()
+
+	return response.NewResponseFromMessage("OK", 1)
+}
+
+// Migrations returns the migrations of every slot that has
+// been moved, or is being moved, ordered by slot.
+func (store *Store) Migrations() []Migration {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	migrations := make([]Migration, 0, len(store.migrations))
+	for _, m := range store.migrations {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Slot < migrations[j].Slot
+	})
+	return migrations
+}
+
+// SlotMigration returns the latest migration of a slot, if any.
+func (store *Store) SlotMigration(slot int) (Migration, bool) {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	m, ok := store.migrations[slot]
+	return m, ok
+}
+
+// SlotOwner returns the group a slot was moved to, if it was moved.
+func (store *Store) SlotOwner(slot int) (int, bool) {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	group, ok := store.slots[slot]
+	return group, ok
+}
+
+// slotOwners returns a copy of the groups slots were moved to.
+func (store *Store) slotOwners() map[int]int {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	slots := make(map[int]int, len(store.slots))
+	for slot, group := range store.slots {
+		slots[slot] = group
+	}
+	return slots
+}
+
+// slotMigrations returns a copy of the migration of every slot.
+func (store *Store) slotMigrations() map[int]Migration {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	migrations := make(map[int]Migration, len(store.migrations))
+	for slot, m := range store.migrations {
+		migrations[slot] = m
+	}
+	return migrations
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestSlotMigration(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	dir, _ := ioutil.TempDir("", "store_test")
+	from := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer from.Close()
+	dir, _ = ioutil.TempDir("", "store_test")
+	to := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer to.Close()
+	clusterLeader(t, []*Store{from})
+	clusterLeader(t, []*Store{to})
+
+	from.Execute("put", request.NewRequestFromValues("England", "London", 60))
+	from.Execute("put", request.NewRequestFromValues("Ireland", "Dublin", -1))
+	from.Execute("put", request.NewRequestFromValues("France", "Paris", -1))
+
+	batch := from.SlotEntries(7, func(key string) bool {
+		return key != "France"
+	}, 0, 10)
+	entries := batch.Entries
+	utils.AssertEqual(t, len(entries), 2, "")
+	utils.AssertEqual(t, batch.Cursor, 0, "")
+
+	// Copies keep their TTL and creation time
+	version, err := to.ImportEntries(entries)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, version > 0, true, "")
+	utils.AssertEqual(t, to.Contains("England"), true, "")
+	copied := to.cache().Entries()
+	utils.AssertEqual(t, len(copied), 2, "")
+	for i := range copied {
+		utils.AssertEqual(t, copied[i].TTL, entries[i].TTL, "")
+		utils.AssertEqual(t, copied[i].CreatedAt, entries[i].CreatedAt, "")
+		utils.AssertEqual(t, copied[i].Version, version, "")
+	}
+
+	// Ireland is written again and England deleted while they are
+	// copied, so only the latter is reported missing, and Ireland
+	// is left to be copied again.
+	from.Execute("put", request.NewRequestFromValues("Ireland", "Dublin 2", -1))
+	from.Execute("delete", request.NewRequestFromValues("England", "", -1))
+	release, err := from.ReleaseEntries(entries)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, release.Released, 0, "")
+	utils.AssertEqual(t, reflect.DeepEqual(release.Missing, []string{"England"}), true, "")
+	utils.AssertEqual(t, from.Contains("Ireland"), true, "")
+
+	// Copies written since they were imported are not released
+	to.Execute("put", request.NewRequestFromValues("Ireland", "Dublin 3", -1))
+	release, err = to.ReleaseEntries([]lru.Entry{{Key: "England", Version: version}, {Key: "Ireland", Version: version}})
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, release.Released, 1, "")
+	utils.AssertEqual(t, to.Contains("England"), false, "")
+	utils.AssertEqual(t, to.Contains("Ireland"), true, "")
+
+	// Clients cannot send the internal commands
+	x := from.Execute(STORE_IMPORT, request.NewEmptyRequest())
+	utils.AssertEqual(t, x.Error, "INVALID_COMMAND_ERR", "")
+	x = from.Execute(STORE_SET_MIGRATION, request.NewEmptyRequest())
+	utils.AssertEqual(t, x.Error, "INVALID_COMMAND_ERR", "")
+}
+
+func TestSetMigration(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	dir, _ := ioutil.TempDir("", "store_test")
+	meta := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer meta.Close()
+	clusterLeader(t, []*Store{meta})
+
+	m := Migration{Slot: 7, From: 1, To: 2, State: MIGRATION_COPYING}
+	utils.AssertEqual(t, meta.SetMigration(m), nil, "")
+	_, moved := meta.SlotOwner(7)
+	utils.AssertEqual(t, moved, false, "")
+
+	// The slot moves with the entry that completes its migration
+	m.State = MIGRATION_COMPLETE
+	m.Moved = 3
+	utils.AssertEqual(t, meta.SetMigration(m), nil, "")
+	owner, moved := meta.SlotOwner(7)
+	utils.AssertEqual(t, moved, true, "")
+	utils.AssertEqual(t, owner, 2, "")
+	utils.AssertEqual(t, reflect.DeepEqual(meta.Migrations(), []Migration{m}), true, "")
+
+	// Moved slots survive a snapshot
+	snapshot, err := (*fsm)(meta).Snapshot()
+	utils.AssertEqual(t, err, nil, "")
+	sink := &memorySink{}
+	utils.AssertEqual(t, snapshot.Persist(sink), nil, "")
+
+	follower := NewStore(LRU_TYPE)
+	follower.BuildStore(conf)
+	err = (*fsm)(follower).Restore(ioutil.NopCloser(bytes.NewReader(sink.Bytes())))
+	utils.AssertEqual(t, err, nil, "")
+	owner, _ = follower.SlotOwner(7)
+	utils.AssertEqual(t, owner, 2, "")
+	latest, _ := follower.SlotMigration(7)
+	utils.AssertEqual(t, latest, m, "")
+}
+
+func TestSlotEntriesCursor(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+	for _, key := range []string{"England", "Ireland", "France", "Spain"} {
+		store.Cache.Put(request.NewRequestFromValues(key, key, -1))
+	}
+	match := func(key string) bool {
+		return key != "France"
+	}
+
+	// Batches are read from the keys found by the scan, skipping
+	// those removed since
+	batch := store.SlotEntries(7, match, 0, 1)
+	utils.AssertEqual(t, len(batch.Entries), 1, "")
+	utils.AssertEqual(t, batch.Cursor, 1, "")
+	store.Cache.DeleteByKey("Ireland")
+	store.Cache.Put(request.NewRequestFromValues("Wales", "Wales", -1))
+	batch = store.SlotEntries(7, match, batch.Cursor, 5)
+	utils.AssertEqual(t, len(batch.Entries), 1, "")
+	utils.AssertEqual(t, batch.Entries[0].Key, "Spain", "")
+	utils.AssertEqual(t, batch.Cursor, 0, "")
+
+	// A new scan finds the keys written since
+	batch = store.SlotEntries(7, match, 0, 5)
+	utils.AssertEqual(t, len(batch.Entries), 3, "")
+	utils.AssertEqual(t, batch.Cursor, 0, "")
+
+	// A cursor the node did not hand out starts a new scan
+	batch = store.SlotEntries(8, match, 2, 5)
+	utils.AssertEqual(t, len(batch.Entries), 3, "")
+}
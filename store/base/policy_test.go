@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/arc"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestValidatePolicy(t *testing.T) {
+	for _, policy := range []string{LRU_TYPE, LFU_TYPE, MRU_TYPE, ARC_TYPE, TLRU_TYPE, WTINYLFU_TYPE} {
+		utils.AssertEqual(t, ValidatePolicy(policy), nil, "")
+	}
+	utils.AssertEqual(t, ValidatePolicy("FIFO") != nil, true, "")
+	utils.AssertEqual(t, ValidatePolicy("") != nil, true, "")
+}
+
+func TestBuildStoreFromSnapshotWithPolicy(t *testing.T) {
+	conf := config.InitializeConfiguration()
+
+	// Take the snapshot with the LRU policy.
+	snapshotCache := lru.NewLRU(conf)
+	snapshotCache.Put(request.NewRequestFromValues("England", "London", -1))
+	snapshotCache.Put(request.NewRequestFromValues("Ireland", "Dublin", 60))
+	snapshotCache.Hashtable["Ireland"].CreatedAt -= 120
+	bs, _ := json.Marshal(snapshotCache.Hashtable)
+	bs = []byte(`{"Hashtable": ` + string(bs) + `}`)
+
+	// Restore it into a store using the ARC policy.
+	store := NewStore(ARC_TYPE)
+	store.BuildStore(conf)
+	store.BuildStoreFromSnapshot(&bs)
+
+	_, ok := store.Cache.(*arc.ARCCache)
+	utils.AssertEqual(t, ok, true, "")
+
+	x := store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+
+	// Ireland expired before the snapshot was restored.
+	x = store.Execute("get", request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, x.Message, lru.CACHE_MISS, "")
+}
+
+func TestBuildStoreFromSnapshotSharded(t *testing.T) {
+	conf := config.InitializeConfiguration()
+
+	snapshotCache := lru.NewLRU(conf)
+	snapshotCache.Put(request.NewRequestFromValues("England", "London", -1))
+	bs, _ := json.Marshal(snapshotCache)
+
+	// An unsharded LRU snapshot restores into a sharded LRU store.
+	conf.ShardCount = 4
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+
+	_, ok := store.Cache.(*lru.ShardedLRUCache)
+	utils.AssertEqual(t, ok, true, "")
+
+	store.BuildStoreFromSnapshot(&bs)
+
+	_, ok = store.Cache.(*lru.ShardedLRUCache)
+	utils.AssertEqual(t, ok, true, "")
+
+	x := store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+}
+
+func TestLazyExpirationWithPolicy(t *testing.T) {
+	for _, policy := range []string{LRU_TYPE, LFU_TYPE, MRU_TYPE, ARC_TYPE, TLRU_TYPE, WTINYLFU_TYPE} {
+		conf := config.InitializeConfiguration()
+		store := NewStore(policy)
+		store.BuildStore(conf)
+
+		store.Cache.Put(request.NewRequestFromValues("England", "London", 60))
+		(*store.Cache.GetHashtableReference())["England"].CreatedAt -= 120
+
+		// Reads hide the expired key-value pair, and the write
+		// that finds it removes it
+		x := store.Execute("get", request.NewRequestFromValues("England", "", -1))
+		utils.AssertEqual(t, x.Message, lru.CACHE_MISS, policy)
+		utils.AssertEqual(t, store.Cache.CountKeys(request.NewEmptyRequest()).Gobj.Value, int32(1), policy)
+		utils.AssertEqual(t, atomic.LoadUint64(&store.appMetrics.Removed), uint64(0), policy)
+
+		x = store.Cache.Add(request.NewRequestFromValues("England", "Manchester", 60))
+		utils.AssertEqual(t, x.Status, int32(1), policy)
+		utils.AssertEqual(t, store.Cache.CountKeys(request.NewEmptyRequest()).Gobj.Value, int32(1), policy)
+		utils.AssertEqual(t, atomic.LoadUint64(&store.appMetrics.Removed), uint64(1), policy)
+	}
+}
+
+func TestCacheWithPolicy(t *testing.T) {
+	for _, policy := range []string{LRU_TYPE, LFU_TYPE, MRU_TYPE, ARC_TYPE, TLRU_TYPE, WTINYLFU_TYPE} {
+		conf := config.InitializeConfiguration()
+		store := NewStore(policy)
+		store.BuildStore(conf)
+		c := store.Cache
+
+		c.Put(request.NewRequestFromValues("England", "London", -1))
+		c.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+		x := c.Add(request.NewRequestFromValues("England", "Leeds", -1))
+		utils.AssertEqual(t, x.Message, lru.NOT_STORED, policy)
+
+		// Lookups by key do not count as a use
+		utils.AssertEqual(t, c.Contains("England"), true, policy)
+		entry, ok := c.Peek("England")
+		utils.AssertEqual(t, ok, true, policy)
+		utils.AssertEqual(t, entry.Value, "London", policy)
+		utils.AssertEqual(t, len(c.Entries()), 2, policy)
+
+		// Expirations only remove the version they were decided for
+		utils.AssertEqual(t, c.ExpireByKey("England", entry.Version+1).Message, lru.NOT_FOUND, policy)
+		utils.AssertEqual(t, c.ExpireByKey("England", entry.Version).Message, lru.REMOVED, policy)
+		utils.AssertEqual(t, c.Contains("England"), false, policy)
+
+		utils.AssertEqual(t, c.Delete(request.NewRequestFromValues("Ireland", "", -1)).Message, lru.REMOVED, policy)
+		utils.AssertEqual(t, c.Delete(request.NewRequestFromValues("Ireland", "", -1)).Message, lru.NOT_FOUND, policy)
+
+		c.Put(request.NewRequestFromValues("France", "Paris", -1))
+		utils.AssertEqual(t, c.Flush(request.NewEmptyRequest()).Message, lru.FLUSHED, policy)
+		utils.AssertEqual(t, c.CountKeys(request.NewEmptyRequest()).Gobj.Value, int32(0), policy)
+	}
+}
@@ -0,0 +1,343 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package base
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/store/object"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// ErrNotInLog is returned when the log no longer holds the entries
+// after a replication position, as they were compacted into a Raft
+// snapshot, or the log was lost and restarted. The standby cluster
+// must then be sent the whole cache with ReplicationSnapshot.
+var ErrNotInLog = errors.New("the log no longer holds the entries after the replication position")
+
+// ReplicationBatch is a batch of writes shipped to a standby cluster.
+type ReplicationBatch struct {
+	// Source is the name of the cluster the writes were made in
+	Source   string
+
+	// Position is the index of the last log entry of the source
+	// the batch holds the writes of. The standby records it once
+	// the writes are applied, unless it is 0.
+	Position uint64
+
+	// Writes are the commands of the batch that write key-value
+	// pairs, each timed by the leader of the source, in log order
+	Writes   []Command
+}
+
+// replicatedCommands are the commands shipped to a standby cluster.
+// Every write that stored a key-value pair is shipped as a put of the
+// pair it stored, so the standby does not check conditions or derive
+// values again. Expirations are not shipped, as the standby expires
+// key-value pairs itself, nor are the key-value pairs moved between
+// groups, which were shipped by the group they were first written to.
+var replicatedCommands = map[string]bool{
+	STORE_PUT:    true,
+	STORE_DELETE: true,
+	STORE_FLUSH:  true,
+}
+
+// journalEntry holds the writes of a log entry shipped to a
+// standby cluster, as they were applied.
+type journalEntry struct {
+	index  uint64
+	writes []Command
+}
+
+// EncodeReplicationBatch encodes a batch in the binary format of the
+// log, so its values keep their types when shipped to the standby.
+func EncodeReplicationBatch(b *ReplicationBatch) ([]byte, error) {
+	return encodeCommand(b.command())
+}
+
+// DecodeReplicationBatch decodes a batch written by EncodeReplicationBatch.
+func DecodeReplicationBatch(data []byte) (*ReplicationBatch, error) {
+	c, err := decodeCommand(data)
+	if err != nil {
+		return nil, err
+	}
+	if c.Cmd != STORE_REPLICATE {
+		return nil, fmt.Errorf("%s is not a replication batch", c.Cmd)
+	}
+	for i := range c.Batch {
+		if !replicatedCommands[c.Batch[i].Cmd] {
+			return nil, fmt.Errorf("%s cannot be replicated", c.Batch[i].Cmd)
+		}
+	}
+	return &ReplicationBatch{Source: c.Args.Gobj.Key, Position: c.Args.Version, Writes: c.Batch}, nil
+}
+
+// command returns the replicate command applying the batch. The
+// source and position are carried as the key and version of its
+// arguments, so the command is encoded like any other.
+func (b *ReplicationBatch) command() *Command {
+	args := request.NewEmptyRequest()
+	args.Gobj.Key = b.Source
+	args.Version = b.Position
+	return &Command{Cmd: STORE_REPLICATE, Args: args, Batch: b.Writes}
+}
+
+// ReplicationLog returns the writes of the log entries after the
+// given position that were applied to the cache, up to about limit
+// writes, and the index of the last entry read. Writes this store
+// replicated from another cluster are not returned, so two clusters
+// can replicate to each other.
+func (store *Store) ReplicationLog(position uint64, limit int) ([]Command, uint64, error) {
+	applied := atomic.LoadUint64(&store.applied)
+	if position > store.Raft.LastIndex() {
+		return nil, position, ErrNotInLog
+	}
+	if position >= applied {
+		return nil, position, nil
+	}
+	first, err := store.logStore.FirstIndex()
+	if err != nil {
+		return nil, position, err
+	}
+
+	store.journalMux.Lock()
+	defer store.journalMux.Unlock()
+
+	store.trimJournal(first, applied)
+	if store.journalFrom == 0 || position+1 < store.journalFrom {
+		return nil, position, ErrNotInLog
+	}
+
+	// Entries after applied may be partly applied, and are not read
+	journal := store.journal
+	i := sort.Search(len(journal), func(i int) bool {
+		return journal[i].index > position
+	})
+	writes := []Command{}
+	index := applied
+	for ; i < len(journal) && journal[i].index <= applied; i++ {
+		if len(writes) >= limit && len(writes) > 0 {
+			index = journal[i-1].index
+			break
+		}
+		writes = append(writes, journal[i].writes...)
+	}
+	return writes, index, nil
+}
+
+// openJournal records that the journal holds the writes of every
+// entry from the given index on, as when the store is opened every
+// entry after its latest snapshot is applied again.
+func (store *Store) openJournal(from uint64) {
+	if len(store.Conf.ReplicationTargets) == 0 {
+		return
+	}
+	store.journalMux.Lock()
+	if store.journalFrom == 0 || from < store.journalFrom {
+		store.journalFrom = from
+	}
+	store.journalMux.Unlock()
+}
+
+// startJournal records that the log entry at index is being applied.
+// Once the cache is restored from a snapshot sent by the leader, the
+// journal holds the writes of every entry from the first applied.
+func (store *Store) startJournal(index uint64) {
+	if len(store.Conf.ReplicationTargets) == 0 {
+		return
+	}
+	store.journalMux.Lock()
+	if store.journalFrom == 0 {
+		store.journalFrom = index
+	}
+	store.journalMux.Unlock()
+}
+
+// journalWrite records the outcome of a command of the log entry at
+// index, if it is shipped to a standby cluster. Only writes that were
+// applied are recorded, and writes that stored a key-value pair are
+// recorded as a put of the pair they stored, which the standby can
+// replay any number of times. Every replica records the same writes,
+// so whichever leads ships them.
+func (store *Store) journalWrite(c *Command, index uint64, res response.CacheResponse) {
+	if len(store.Conf.ReplicationTargets) == 0 || res.Status != 1 {
+		return
+	}
+	write := Command{Cmd: c.Cmd, Args: c.Args}
+	if storedCommands[c.Cmd] {
+		write.Cmd = STORE_PUT
+		if derivedCommands[c.Cmd] {
+			write.Args.Gobj = res.Gobj
+		}
+	} else if !replicatedCommands[c.Cmd] {
+		return
+	}
+
+	store.journalMux.Lock()
+	defer store.journalMux.Unlock()
+
+	if n := len(store.journal); n > 0 && store.journal[n-1].index == index {
+		store.journal[n-1].writes = append(store.journal[n-1].writes, write)
+	} else {
+		store.journal = append(store.journal, journalEntry{index: index, writes: []Command{write}})
+	}
+}
+
+// trimJournal drops the writes of the entries compacted out of the
+// log, which begins at first, or is empty if first is 0 and every
+// entry up to applied was compacted. It must be called with
+// journalMux held.
+func (store *Store) trimJournal(first uint64, applied uint64) {
+	if first == 0 {
+		first = applied + 1
+	}
+	if store.journalFrom == 0 || first <= store.journalFrom {
+		return
+	}
+	i := sort.Search(len(store.journal), func(i int) bool {
+		return store.journal[i].index >= first
+	})
+	store.journal = append([]journalEntry(nil), store.journal[i:]...)
+	store.journalFrom = first
+}
+
+// resetJournal empties the journal when the cache is restored from
+// a snapshot, as the writes the snapshot holds are not known.
+func (store *Store) resetJournal() {
+	store.journalMux.Lock()
+	store.journal = nil
+	store.journalFrom = 0
+	store.journalMux.Unlock()
+}
+
+// ReplicationSnapshot returns a put of every key-value pair in the
+// cache, timed when it was written, and the index of the last log
+// entry applied before the cache was copied. The copy may also hold
+// writes of later entries, which are shipped again, but replaying
+// them is harmless as the standby keeps the later of two writes.
+func (store *Store) ReplicationSnapshot() ([]Command, uint64) {
+	applied := atomic.LoadUint64(&store.applied)
+	entries := store.cache().Entries()
+
+	writes := make([]Command, len(entries))
+	for i, entry := range entries {
+		writes[i] = Command{
+			Cmd: STORE_PUT,
+			Args: request.CacheRequest{
+				Gobj:      object.NewCacheObjectFromParams(entry.Key, entry.Value, entry.TTL),
+				Timestamp: entry.CreatedAt,
+			},
+		}
+		writes[i].Args.Gobj.Flags = entry.Flags
+	}
+	return writes, applied
+}
+
+// Replicate applies a batch of writes shipped from another cluster,
+// and records its position. It must be called on the leader.
+func (store *Store) Replicate(b *ReplicationBatch) error {
+	res := store.apply(b.command())
+	if res.Error == response.NOT_LEADER_ERR {
+		return raft.ErrNotLeader
+	} else if res.Status != 1 {
+		return fmt.Errorf("failed to replicate writes of %s: %s", b.Source, res.Message)
+	}
+	return nil
+}
+
+// replicate applies a replicated batch of writes. Where a write
+// conflicts with the key-value pair in the cache the later of the
+// two is kept, so writes made to this cluster are not overwritten by
+// earlier writes from the source. Writes made in the same second are
+// ordered by the source. As every write is a put, a delete or a
+// flush, replaying a batch leaves the cache as it is.
+func (store *Store) replicate(c *Command, index uint64) response.CacheResponse {
+	cache := store.cache()
+	for i := range c.Batch {
+		args := c.Batch[i].Args
+		args.Version = index
+		current, ok := cache.Peek(args.Gobj.Key)
+		later := !ok || current.CreatedAt <= args.Timestamp
+
+		switch c.Batch[i].Cmd {
+		case STORE_PUT:
+			if later {
+				cache.Put(args)
+			}
+		case STORE_DELETE:
+			if ok && later {
+				cache.DeleteByKey(args.Gobj.Key)
+			}
+		case STORE_FLUSH:
+			for _, entry := range cache.Entries() {
+				if entry.CreatedAt <= args.Timestamp {
+					cache.DeleteByKey(entry.Key)
+				}
+			}
+		}
+	}
+
+	if source := c.Args.Gobj.Key; c.Args.Version > 0 {
+		store.mux.Lock()
+		if store.checkpoints == nil {
+			store.checkpoints = make(map[string]uint64)
+		}
+		store.checkpoints[source] = c.Args.Version
+		store.mux.Unlock()
+	}
+	return response.NewResponseFromMessage("OK", 1)
+}
+
+// Checkpoint returns the position of the last batch replicated
+// from the source cluster, or 0 if none was.
+func (store *Store) Checkpoint(source string) uint64 {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	return store.checkpoints[source]
+}
+
+// replicationCheckpoints returns a copy of the position of
+// every cluster replicating to this one.
+func (store *Store) replicationCheckpoints() map[string]uint64 {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	checkpoints := make(map[string]uint64, len(store.checkpoints))
+	for source, position := range store.checkpoints {
+		checkpoints[source] = position
+	}
+	return checkpoints
+}
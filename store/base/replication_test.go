@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package base
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// shipLog ships the writes after position from primary to standby
+// through the encoding they are sent to the standby cluster in.
+func shipLog(t *testing.T, primary *Store, standby *Store, position uint64) uint64 {
+	writes, next, err := primary.ReplicationLog(position, 100)
+	utils.AssertEqual(t, err, nil, "")
+	data, err := EncodeReplicationBatch(&ReplicationBatch{Source: "primary/0", Position: next, Writes: writes})
+	utils.AssertEqual(t, err, nil, "")
+	batch, err := DecodeReplicationBatch(data)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, standby.Replicate(batch), nil, "")
+	return next
+}
+
+func TestReplication(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.ReplicationTargets = []string{"standby:7991"}
+
+	primary := NewStore(LRU_TYPE)
+	primary.BuildStore(conf)
+	primary.RaftDir, _ = ioutil.TempDir("", "store_test")
+	primary.RaftBind = freeRaftAddr(t)
+	primary.configureRaft = func(c *raft.Config) {
+		c.TrailingLogs = 0
+	}
+	if err := primary.Open(true, "node0"); err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer primary.Close()
+	dir, _ := ioutil.TempDir("", "store_test")
+	standby := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer standby.Close()
+	clusterLeader(t, []*Store{primary})
+	clusterLeader(t, []*Store{standby})
+
+	primary.Execute("put", request.NewRequestFromValues("England", "London", 60))
+	primary.Execute("put", request.NewRequestFromValues("Population", int64(56000000), -1))
+	primary.Execute("put", request.NewRequestFromValues("France", "Paris", -1))
+	primary.Execute("delete", request.NewRequestFromValues("France", "", -1))
+
+	// Writes keep their types, TTL and the time they were made
+	position := shipLog(t, primary, standby, 0)
+	utils.AssertEqual(t, standby.Checkpoint("primary/0"), position, "")
+	utils.AssertEqual(t, standby.Execute("get", request.NewRequestFromValues("Population", "", -1)).Gobj.Value, int64(56000000), "")
+	utils.AssertEqual(t, standby.Contains("France"), false, "")
+	original, _ := primary.cache().Peek("England")
+	copied, _ := standby.cache().Peek("England")
+	utils.AssertEqual(t, copied.TTL, original.TTL, "")
+	utils.AssertEqual(t, copied.CreatedAt, original.CreatedAt, "")
+
+	// Nothing is shipped until more writes are applied
+	writes, next, err := primary.ReplicationLog(position, 100)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, len(writes), 0, "")
+	utils.AssertEqual(t, next, position, "")
+
+	// The later of two conflicting writes is kept, whichever
+	// cluster it was made in
+	standby.Execute("put", request.NewRequestFromValues("England", "Manchester", -1))
+	earlier := request.NewRequestFromValues("England", "Leeds", -1)
+	earlier.Timestamp = time.Now().Unix() - 60
+	erase := request.NewRequestFromValues("England", "", -1)
+	erase.Timestamp = earlier.Timestamp
+	batch := &ReplicationBatch{Source: "primary/0", Writes: []Command{{Cmd: STORE_PUT, Args: earlier}, {Cmd: STORE_DELETE, Args: erase}}}
+	utils.AssertEqual(t, standby.Replicate(batch), nil, "")
+	utils.AssertEqual(t, standby.Execute("get", request.NewRequestFromValues("England", "", -1)).Gobj.Value, "Manchester", "")
+	utils.AssertEqual(t, standby.Checkpoint("primary/0"), position, "")
+
+	primary.Execute("put", request.NewRequestFromValues("England", "Liverpool", -1))
+	position = shipLog(t, primary, standby, position)
+	utils.AssertEqual(t, standby.Execute("get", request.NewRequestFromValues("England", "", -1)).Gobj.Value, "Liverpool", "")
+
+	// Batches are bounded by whole log entries
+	for _, city := range []string{"Cork", "Galway", "Limerick"} {
+		primary.Execute("put", request.NewRequestFromValues(city, city, -1))
+	}
+	writes, next, err = primary.ReplicationLog(position, 2)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, len(writes), 2, "")
+	utils.AssertEqual(t, writes[1].Args.Gobj.Key, "Galway", "")
+
+	// Once the log is compacted the whole cache is shipped instead
+	if err := primary.Raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+	_, _, err = primary.ReplicationLog(position, 100)
+	utils.AssertEqual(t, err, ErrNotInLog, "")
+	_, _, err = primary.ReplicationLog(primary.Raft.LastIndex()+1, 100)
+	utils.AssertEqual(t, err, ErrNotInLog, "")
+	writes, position = primary.ReplicationSnapshot()
+	utils.AssertEqual(t, len(writes), 5, "")
+	utils.AssertEqual(t, position, primary.Raft.AppliedIndex(), "")
+	utils.AssertEqual(t, standby.Replicate(&ReplicationBatch{Source: "primary/0", Position: position, Writes: writes}), nil, "")
+	utils.AssertEqual(t, standby.CacheSize().Keys, int32(5), "")
+
+	// Checkpoints survive a snapshot
+	snapshot, err := (*fsm)(standby).Snapshot()
+	utils.AssertEqual(t, err, nil, "")
+	sink := &memorySink{}
+	utils.AssertEqual(t, snapshot.Persist(sink), nil, "")
+	restored := NewStore(LRU_TYPE)
+	restored.BuildStore(conf)
+	err = (*fsm)(restored).Restore(ioutil.NopCloser(bytes.NewReader(sink.Bytes())))
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, restored.Checkpoint("primary/0"), position, "")
+
+	// Clients cannot send replicated writes
+	x := standby.Execute(STORE_REPLICATE, request.NewEmptyRequest())
+	utils.AssertEqual(t, x.Error, "INVALID_COMMAND_ERR", "")
+	_, err = DecodeReplicationBatch([]byte{commandFormat})
+	utils.AssertEqual(t, err != nil, true, "")
+}
+
+func TestReplicationShipsAppliedWrites(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.ReplicationTargets = []string{"standby:7991"}
+
+	dir, _ := ioutil.TempDir("", "store_test")
+	primary := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer primary.Close()
+	dir, _ = ioutil.TempDir("", "store_test")
+	standby := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer standby.Close()
+	clusterLeader(t, []*Store{primary})
+	clusterLeader(t, []*Store{standby})
+
+	primary.Execute("put", request.NewRequestFromValues("Counter", "10", -1))
+	primary.Execute("incr", request.NewRequestFromValues("Counter", "5", -1))
+	primary.Execute("put", request.NewRequestFromValues("England", "London", -1))
+
+	// Writes refused as they were applied are not shipped
+	version := primary.Execute("gets", request.NewRequestFromValues("England", "", -1)).Version
+	stale := request.NewRequestFromValues("England", "Leeds", -1)
+	stale.Cas = version - 1
+	utils.AssertEqual(t, primary.Execute("cas", stale).Status, int32(0), "")
+	utils.AssertEqual(t, primary.Execute("add", request.NewRequestFromValues("England", "York", -1)).Status, int32(0), "")
+	utils.AssertEqual(t, primary.Execute("replace", request.NewRequestFromValues("France", "Paris", -1)).Status, int32(0), "")
+
+	// An incr is shipped as a put of the value it stored
+	writes, position, err := primary.ReplicationLog(0, 100)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, len(writes), 3, "")
+	utils.AssertEqual(t, writes[1].Cmd, STORE_PUT, "")
+	utils.AssertEqual(t, writes[1].Args.Gobj.Value, "15", "")
+
+	// Replaying a batch leaves the standby as it is
+	batch := &ReplicationBatch{Source: "primary/0", Position: position, Writes: writes}
+	for i := 0; i < 2; i++ {
+		utils.AssertEqual(t, standby.Replicate(batch), nil, "")
+		utils.AssertEqual(t, standby.Execute("get", request.NewRequestFromValues("Counter", "", -1)).Gobj.Value, "15", "")
+		utils.AssertEqual(t, standby.Execute("get", request.NewRequestFromValues("England", "", -1)).Gobj.Value, "London", "")
+		utils.AssertEqual(t, standby.Contains("France"), false, "")
+	}
+
+	// A later incr ships the value it stored, however often replayed
+	primary.Execute("incr", request.NewRequestFromValues("Counter", "1", -1))
+	for i := 0; i < 2; i++ {
+		shipLog(t, primary, standby, position)
+	}
+	utils.AssertEqual(t, standby.Execute("get", request.NewRequestFromValues("Counter", "", -1)).Gobj.Value, "16", "")
+}
@@ -38,6 +38,7 @@ import (
 	"github.com/ghostdb/ghostdb-cache-node/utils"
 	"github.com/ghostdb/ghostdb-cache-node/config"
 	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/cache"
 )
 
 func TestSnapshotScheduler(t *testing.T) {
@@ -67,7 +68,7 @@ func TestSnapshotScheduler(t *testing.T) {
 	store.Execute("put", request.NewRequestFromValues("England", "London", 2))
 	store.Execute("put", request.NewRequestFromValues("Italy", "Rome", -1))
 
-	utils.AssertEqual(t, store.Execute("nodeSize", request.NewEmptyRequest()).Gobj.Value, int32(2), "")
+	utils.AssertEqual(t, store.Execute("nodeSize", request.NewEmptyRequest()).Gobj.Value.(cache.NodeSize).Keys, int32(2), "")
 
 	time.Sleep(6 * time.Second)
 
@@ -35,17 +35,24 @@ import (
 	"time"
 
 	"fmt"
+	"io"
 	"net"
 	"os"
-	"regexp"
-	"strings"
-	"encoding/json"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	
 	"github.com/hashicorp/raft"
 	"github.com/ghostdb/ghostdb-cache-node/store/cache"
 	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/lfu"
+	"github.com/ghostdb/ghostdb-cache-node/store/arc"
+	"github.com/ghostdb/ghostdb-cache-node/store/tlru"
+	"github.com/ghostdb/ghostdb-cache-node/store/mru"
+	"github.com/ghostdb/ghostdb-cache-node/store/tinylfu"
 	"github.com/ghostdb/ghostdb-cache-node/store/crawlers"
 	"github.com/ghostdb/ghostdb-cache-node/store/persistence"
+	"github.com/ghostdb/ghostdb-cache-node/store/raftlog"
 	"github.com/ghostdb/ghostdb-cache-node/config"
 	"github.com/ghostdb/ghostdb-cache-node/store/request"
 	"github.com/ghostdb/ghostdb-cache-node/store/response"
@@ -59,8 +66,23 @@ const (
 	STORE_ADD = "add"
 	STORE_DELETE = "delete"
 	STORE_FLUSH = "flush"
+	STORE_REPLACE = "replace" // Writes a key-value pair only if the key exists
+	STORE_TOUCH = "touch" // Sets the TTL of an existing key-value pair
+	STORE_TTL = "ttl" // Reads the seconds until a key-value pair expires
+	STORE_GETS = "gets" // Reads a key-value pair with its flags and version
+	STORE_CAS = "cas" // Writes a key-value pair only if it is at a version
+	STORE_INCR = "incr" // Adds to a key-value pair holding a decimal number
+	STORE_DECR = "decr" // Subtracts from a key-value pair holding a decimal number
 	STORE_NODE_SIZE = "nodeSize"
 	STORE_APP_METRICS = "getAppMetrics"
+	STORE_EXPIRE = "expire" // Internal, proposed by the leaders crawlers
+	STORE_SET_PEER = "setPeer" // Internal, records the HTTP address of a node
+	STORE_SET_GROUPS = "setGroups" // Internal, records the Raft groups a node hosts
+	STORE_SET_MIGRATION = "setMigration" // Internal, records the progress of a slot migration
+	STORE_IMPORT = "importEntries" // Internal, writes key-value pairs moved from another group
+	STORE_RELEASE = "releaseEntries" // Internal, removes key-value pairs moved to another group
+	STORE_BATCH = "batch" // Internal, commands proposed together in a single log entry
+	STORE_REPLICATE = "replicate" // Internal, applies writes replicated from another cluster
 )
 
 const (
@@ -74,6 +96,7 @@ const (
 	MRU_TYPE = "MRU"   // Most recently used
 	ARC_TYPE = "ARC"   // Adaptive Replacement Cache
 	TLRU_TYPE = "TLRU" // Time-aware Least Recently Used
+	WTINYLFU_TYPE = "WTINYLFU" // Window TinyLFU
 )
 
 type HandlerType func(request.CacheRequest) response.CacheResponse
@@ -96,16 +119,38 @@ type BaseStore interface {
 	// Join joins a node, identified by nodeID and located at addr, to this store.
 	// The node must be ready to respond to Raft communications at that address.
 	Join(nodeID string, addr string) error
+	// AddLearner joins a node to this store as a non-voting learner.
+	AddLearner(nodeID string, addr string) error
+	// Promote makes a learner a voter.
+	Promote(nodeID string) error
+	// Remove removes a node, identified by nodeID, from the cluster.
+	Remove(nodeID string) error
+	// Status returns the status of every server in the cluster.
+	Status() ([]ServerStatus, error)
 	// Open opens the store. If enableSingle is set, and there are no existing peers,
 	// then this node becomes the first node, and therefore leader, of the cluster.
 	// localID should be the server identifier for this node.
 	Open(enableSingle bool, localID string) error
+	// Close shuts down Raft and releases its transport and stores.
+	Close() error
 }
 
 type Store struct {
 	RaftDir            string
 	RaftBind           string
+	// HTTPAddr is the address other nodes forward requests to
+	HTTPAddr           string
+	// Groups, if set, are the data groups this node hosts. They
+	// are recorded in the directory of the meta group of a
+	// sharded cluster, so other nodes can route to them.
+	Groups             []int
 	Raft               *raft.Raft
+	transport          *raft.NetworkTransport
+	// raftStores are the log and stable stores closed by Close.
+	raftStores         []io.Closer
+	// logStore holds the Raft log, which the writes shipped
+	// to a standby cluster are read from.
+	logStore           raft.LogStore
 	ServerID           string
 	NumericalID        int
 	PeersLength        int
@@ -117,14 +162,71 @@ type Store struct {
 	crawlerScheduler   *crawlers.CrawlerScheduler
 	snapshotScheduler  *persistence.SnapshotScheduler
 	appMetrics         *monitor.AppMetrics
+
+	// mux guards Cache and commands, which are swapped when
+	// the cache is restored from a Raft snapshot.
+	mux                sync.RWMutex
+	// peers maps the Raft ID of each node to its HTTP address.
+	// It is replicated through Raft and guarded by mux.
+	peers              map[string]string
+	// groups maps the Raft ID of each node to the data groups
+	// it hosts. It is replicated through Raft and guarded by mux.
+	groups             map[string][]int
+	// migrations maps each slot moved between data groups to its
+	// latest migration, and slots each slot moved to the group
+	// that owns it. They are replicated through the meta group
+	// of a sharded cluster and guarded by mux.
+	migrations         map[int]Migration
+	slots              map[int]int
+	// checkpoints maps each cluster replicating to this one to the
+	// index of the last of its log entries applied here. They are
+	// replicated through Raft and guarded by mux.
+	checkpoints        map[string]uint64
+	// scans maps each slot being exported to the keys found by the
+	// last scan of the cache for it, which batches are read from in
+	// turn. It is local to the node and guarded by scanMux.
+	scans              map[int][]string
+	scanMux            sync.Mutex
+	// applied is the index of the last log entry applied
+	// to the cache. It is read and written atomically.
+	applied            uint64
+	// journal holds the writes shipped to a standby cluster of the
+	// log entries applied since journalFrom, in log order, as they
+	// were applied. It is kept by nodes replicating to a standby
+	// for as long as the log keeps the entries, and is guarded
+	// by journalMux.
+	journal            []journalEntry
+	journalFrom        uint64
+	journalMux         sync.Mutex
+	// shutdownCh is closed by Close to stop watching leadership,
+	// proposing batches and any work done for the group, such as
+	// replicating it. It is returned by Done.
+	shutdownCh         chan struct{}
+	// batcher, if set, groups concurrent commands into a single
+	// log entry. It is set when the store is opened.
+	batcher            *batcher
+	// crawling records if RunStore started the crawlers.
+	crawling           bool
+	// configureRaft, if set, adjusts the Raft configuration before
+	// the store is opened. Tests use it to force log compaction.
+	configureRaft      func(*raft.Config)
 }
 
 // Command is the struct used by the replication log.
 // All write commands can be written to the replciation log
-// in this format
+// in this format, encoded by encodeCommand
 type Command struct {
 	Cmd  string
 	Args request.CacheRequest
+
+	// Entries are the key-value pairs of a batch moved between groups
+	Entries   []lru.Entry `json:",omitempty"`
+
+	// Migration is the progress of a slot migration
+	Migration *Migration  `json:",omitempty"`
+
+	// Batch are the commands of a batch, applied in order
+	Batch     []Command   `json:",omitempty"`
 }
 
 func NewStore(policy string) *Store {
@@ -136,14 +238,21 @@ func NewStore(policy string) *Store {
 func (store *Store) Execute(cmd string, args request.CacheRequest) response.CacheResponse {
 	// All commands that are not write commands don't need to call Apply() on the store.
 	// We can handle them as before.
-	if cmd == "get" || cmd == "getAppMetrics"{
+	if cmd == "get" || cmd == STORE_TTL || cmd == STORE_GETS || cmd == "getAppMetrics"{
 		// Handle get
-		if cmd == "get" {
-			if _, ok := store.commands[cmd]; !ok {
+		if cmd == "get" || cmd == STORE_TTL || cmd == STORE_GETS {
+			handler, ok := store.handler(cmd)
+			if !ok {
 				return response.BadCommandResponse(cmd)
 			}
+			if res, ok := store.checkConsistency(args.Consistency); !ok {
+				return res
+			}
 			
-			execResult := store.commands[cmd].(func(request.CacheRequest) response.CacheResponse)(args)
+			execResult := handler(args)
+			if execResult.Message == lru.CACHE_MISS {
+				store.expireOnRead(args.Gobj.Key)
+			}
 			if store.Conf.PersistenceAOF {
 				writeAof(cmd, &(args))
 			}
@@ -151,49 +260,122 @@ func (store *Store) Execute(cmd string, args request.CacheRequest) response.Cach
 		}
 		// Handle getAppMetrics
 		return response.BadCommandResponse(cmd)
+	} else if internalCommands[cmd] {
+		// Expirations are only decided by the leaders crawlers,
+		// peers are only recorded when they join, and slots are
+		// only moved by migrations.
+		return response.BadCommandResponse(cmd)
 	} else {
-		// All write commands need to be applied to the replication log
-		c := &Command{
+		// All write commands need to be applied to the replication log.
+		// They are timed here so every replica times them the same way.
+		args.Timestamp = time.Now().Unix()
+		return store.apply(&Command{
 			Cmd: cmd,
 			Args: args,
-		}
+		})
+	}
+}
 
-		b, err := json.Marshal(c)
-		if err != nil {
-			return response.BadCommandResponse(cmd)
-		}
+// apply proposes a command to the replication log and waits for it
+// to be applied. A node that is not the leader proposes nothing, and
+// returns a response naming the leader so the request can be retried.
+// Commands applied concurrently may be proposed as a single batch.
+func (store *Store) apply(c *Command) response.CacheResponse {
+	if store.batcher != nil {
+		return store.batcher.propose(c, store.shutdownCh)
+	}
 
-		applyFuture := store.Raft.Apply(b, raftTimeout)
-		if err := applyFuture.Error(); err != nil {
-			return response.NewResponseFromMessage("Error commiting to raft cluster", 500)
-		}
+	b, err := encodeCommand(c)
+	if err != nil {
+		return response.BadCommandResponse(c.Cmd)
+	}
 
-		res, ok := applyFuture.Response().(response.CacheResponse)
-		if !ok {
-			return response.NewResponseFromMessage("Error commiting to raft cluster 2", 500)
-		}
+	applyFuture := store.Raft.Apply(b, raftTimeout)
+	if err := applyFuture.Error(); err != nil {
+		return store.commitError(err)
+	}
 
-		return res
+	res, ok := applyFuture.Response().(response.CacheResponse)
+	if !ok {
+		return response.NewResponseFromMessage("Error commiting to raft cluster 2", 500)
 	}
+
+	return res
+}
+
+// commitError returns the response to a command that could not be
+// committed to the replication log.
+func (store *Store) commitError(err error) response.CacheResponse {
+	if err == raft.ErrNotLeader {
+		return response.NewNotLeaderResponse(store.LeaderHTTPAddr())
+	}
+	return response.NewResponseFromMessage("Error commiting to raft cluster", 500)
 }
 
 func writeAof(cmd string, args *request.CacheRequest) {
+	// Expirations replay as deletions, and the other
+	// writes of a key-value pair as puts
+	if cmd == STORE_EXPIRE {
+		cmd = STORE_DELETE
+	} else if cmd == STORE_REPLACE || cmd == STORE_CAS || derivedCommands[cmd] {
+		cmd = STORE_PUT
+	}
 	if isWriteOp(cmd) {
 		var gobj = args.Gobj
 		persistence.WriteBuffer(cmd, gobj.Key, gobj.Value, gobj.TTL)
 	}
 }
 
+// internalCommands are replicated commands clients cannot send
+var internalCommands = map[string]bool{
+	STORE_EXPIRE:        true,
+	STORE_SET_PEER:      true,
+	STORE_SET_GROUPS:    true,
+	STORE_SET_MIGRATION: true,
+	STORE_IMPORT:        true,
+	STORE_RELEASE:       true,
+	STORE_BATCH:         true,
+	STORE_REPLICATE:     true,
+}
+
 func isWriteOp(cmd string) bool {
 	writeOps := map[string]bool {
 		STORE_ADD: true,
 		STORE_PUT: true,
 		STORE_DELETE: true,
 		STORE_FLUSH: true,
+		STORE_REPLACE: true,
+		STORE_TOUCH: true,
 	}
 	return writeOps[cmd]
 }
 
+// checkConsistency reports if the node may serve a read at the given
+// consistency level, and if not the response to return instead.
+// Linearizable reads wait on a Raft barrier, which only commits while
+// the node leads a quorum, and completes once every write committed
+// before it has been applied to the cache.
+func (store *Store) checkConsistency(level string) (response.CacheResponse, bool) {
+	switch level {
+	case "", request.CONSISTENCY_STALE:
+		return response.CacheResponse{}, true
+	case request.CONSISTENCY_LEADER:
+		if store.Raft.State() != raft.Leader {
+			return response.NewNotLeaderResponse(store.LeaderHTTPAddr()), false
+		}
+		return response.CacheResponse{}, true
+	case request.CONSISTENCY_LINEARIZABLE:
+		err := store.Raft.Barrier(raftTimeout).Error()
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return response.NewNotLeaderResponse(store.LeaderHTTPAddr()), false
+		} else if err != nil {
+			return response.NewResponseFromMessage("Error confirming leadership with raft cluster", 500), false
+		}
+		return response.CacheResponse{}, true
+	}
+	return response.BadConsistencyResponse(level), false
+}
+
 func (store *Store) CreateSnapshot() {
 	_, err := persistence.CreateSnapshot(&store.Cache, &store.Conf)
 	if err != nil {
@@ -208,8 +390,32 @@ func (store *Store) BuildStore(conf config.Configuration) {
 	store.commands = make(map[string]interface{})
 	store.commands = store.registerHandlers()
 	store.crawlerScheduler = crawlers.NewCrawlerScheduler(conf.CrawlerInterval)
+	crawlers.SetExpirer(store.crawlerScheduler, store)
 	store.snapshotScheduler = persistence.NewSnapshotScheduler(conf.SnapshotInterval)
 	store.appMetrics = monitor.NewAppMetrics(time.Duration(store.Conf.AppMetricInterval), true)
+	store.registerPolicyReporter()
+
+	if _, ok := store.Cache.(cache.MemoryReporter); !ok && conf.MaxMemoryBytes > 0 {
+		log.Printf("maxMemoryBytes is not enforced by the %s policy, only keyspaceSize bounds the cache", store.policy)
+	}
+}
+
+// registerPolicyReporter records policy specific statistics in the
+// appMetrics log if the stores cache policy keeps any, and counts
+// key-value pairs the cache removes on write because they expired.
+func (store *Store) registerPolicyReporter() {
+	if store.appMetrics == nil {
+		return
+	}
+	if reporter, ok := store.Cache.(monitor.PolicyReporter); ok {
+		monitor.SetPolicyReporter(store.appMetrics, reporter)
+	}
+	if notifier, ok := store.Cache.(cache.ExpiryNotifier); ok {
+		appMetrics := store.appMetrics
+		notifier.SetExpiryHook(func(key string) {
+			monitor.Removed(appMetrics)
+		})
+	}
 }
 
 func (baseStore *Store) registerHandlers() map[string]interface{} {
@@ -219,14 +425,250 @@ func (baseStore *Store) registerHandlers() map[string]interface{} {
 		STORE_ADD: baseStore.Cache.Add,
 		STORE_DELETE: baseStore.Cache.Delete,
 		STORE_FLUSH: baseStore.Cache.Flush,
-		STORE_NODE_SIZE: baseStore.Cache.CountKeys,
+		STORE_NODE_SIZE: baseStore.nodeSize,
+		STORE_EXPIRE: baseStore.expire,
+		STORE_REPLACE: baseStore.replace,
+		STORE_TOUCH: baseStore.touch,
+		STORE_TTL: baseStore.ttl,
+		STORE_GETS: baseStore.gets,
+		STORE_CAS: baseStore.cas,
+		STORE_INCR: baseStore.incr,
+		STORE_DECR: baseStore.decr,
+	}
+}
+
+// expire applies an expiration decided by the leaders crawlers
+func (store *Store) expire(args request.CacheRequest) response.CacheResponse {
+	return store.cache().ExpireByKey(args.Gobj.Key, args.Version)
+}
+
+// handler returns the function that handles a command
+func (store *Store) handler(cmd string) (HandlerType, bool) {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	h, ok := store.commands[cmd]
+	if !ok {
+		return nil, false
+	}
+	return h.(func(request.CacheRequest) response.CacheResponse), true
+}
+
+// cache returns the stores current cache
+func (store *Store) cache() cache.Cache {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+	return store.Cache
+}
+
+// swapCache atomically replaces the stores cache, so commands are
+// either handled by the old cache or the new one, never a mix.
+// Running crawlers are restarted to crawl the new cache.
+func (store *Store) swapCache(c cache.Cache) {
+	store.mux.Lock()
+	store.Cache = c
+	store.commands = store.registerHandlers()
+	crawling := store.crawling
+	store.mux.Unlock()
+
+	store.registerPolicyReporter()
+
+	if crawling {
+		crawlers.StopScheduler(store.crawlerScheduler)
+		store.crawlerScheduler = crawlers.NewCrawlerScheduler(store.Conf.CrawlerInterval)
+		crawlers.SetExpirer(store.crawlerScheduler, store)
+		go crawlers.StartCrawlers(c, store.crawlerScheduler)
+	}
+}
+
+// IsLeader reports if this node is the leader of the cluster,
+// and so the node that decides which key-value pairs expire.
+func (store *Store) IsLeader() bool {
+	return store.Raft != nil && store.Raft.State() == raft.Leader
+}
+
+// ExpireKeys proposes the removal of expired key-value pairs through
+// the replication log, so every replica removes them in log order.
+// Each key-value pair is only removed if it has not been written
+// again since it was marked.
+func (store *Store) ExpireKeys(marked map[string]uint64) {
+	futures := make([]raft.ApplyFuture, 0, len(marked))
+	for key, version := range marked {
+		args := request.NewRequestFromValues(key, nil, -1)
+		args.Version = version
+
+		b, err := encodeCommand(&Command{Cmd: STORE_EXPIRE, Args: args})
+		if err != nil {
+			continue
+		}
+		futures = append(futures, store.Raft.Apply(b, raftTimeout))
+	}
+
+	for _, f := range futures {
+		if err := f.Error(); err != nil {
+			log.Printf("failed to replicate expiration: %s", err.Error())
+		}
+	}
+}
+
+// expireOnRead removes a key-value pair a read found expired, through
+// the replication log as the crawlers do, if this node leads. Other
+// nodes only hide it, and leave its removal to the leader.
+func (store *Store) expireOnRead(key string) {
+	if !store.IsLeader() {
+		return
+	}
+	current, ok := store.cache().Peek(key)
+	if !ok || !current.Expired(time.Now().Unix()) {
+		return
+	}
+	store.ExpireKeys(map[string]uint64{key: current.Version})
+}
+
+// SetPeerHTTPAddr records the HTTP address of the node with the
+// given Raft ID on every node, so followers can forward requests to
+// it when it leads. It must be called on the leader.
+func (store *Store) SetPeerHTTPAddr(nodeID string, httpAddr string) error {
+	res := store.apply(&Command{
+		Cmd: STORE_SET_PEER,
+		Args: request.NewRequestFromValues(nodeID, httpAddr, -1),
+	})
+	if res.Error == response.NOT_LEADER_ERR {
+		return raft.ErrNotLeader
+	} else if res.Status != 1 {
+		return fmt.Errorf("failed to record the HTTP address of %s: %s", nodeID, res.Message)
+	}
+	return nil
+}
+
+// setPeer applies a replicated HTTP address of a node.
+func (store *Store) setPeer(args request.CacheRequest) response.CacheResponse {
+	httpAddr, ok := args.Gobj.Value.(string)
+	if !ok {
+		return response.NewResponseFromMessage("HTTP address must be a string", 0)
+	}
+
+	store.mux.Lock()
+	if store.peers == nil {
+		store.peers = make(map[string]string)
+	}
+	store.peers[args.Gobj.Key] = httpAddr
+	store.mux.Unlock()
+
+	return response.NewResponseFromMessage("OK", 1)
+}
+
+// peerHTTPAddrs returns a copy of the HTTP addresses of the nodes.
+func (store *Store) peerHTTPAddrs() map[string]string {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	peers := make(map[string]string, len(store.peers))
+	for id, addr := range store.peers {
+		peers[id] = addr
+	}
+	return peers
+}
+
+// LeaderHTTPAddr returns the HTTP address of the leader, or an empty
+// string if the leader or its HTTP address is not known.
+func (store *Store) LeaderHTTPAddr() string {
+	leader := store.Raft.Leader()
+	if leader == "" {
+		return ""
+	}
+
+	future := store.Raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return ""
 	}
+	for _, server := range future.Configuration().Servers {
+		if server.Address == leader {
+			return store.peerHTTPAddrs()[string(server.ID)]
+		}
+	}
+	return ""
+}
+
+// watchLeadership records the HTTP address, and any groups, of the
+// node each time it becomes leader, as the node that bootstrapped the
+// cluster never joined it, and a node may have moved since it joined.
+func (store *Store) watchLeadership(notify <-chan bool, shutdownCh <-chan struct{}) {
+	for {
+		select {
+		case leader := <-notify:
+			if !leader {
+				continue
+			}
+			if store.HTTPAddr != "" && store.peerHTTPAddrs()[store.ServerID] != store.HTTPAddr {
+				if err := store.SetPeerHTTPAddr(store.ServerID, store.HTTPAddr); err != nil {
+					log.Println(err.Error())
+				}
+			}
+			if store.Groups != nil && !sameGroups(store.peerGroups()[store.ServerID], store.Groups) {
+				if err := store.SetPeerGroups(store.ServerID, store.Groups); err != nil {
+					log.Println(err.Error())
+				}
+			}
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+// nodeSize reports the number of keys in the stores cache along
+// with the estimated bytes they use, if the cache tracks memory.
+func (store *Store) nodeSize(args request.CacheRequest) response.CacheResponse {
+	return response.NewResponseFromValue(store.CacheSize())
+}
+
+// CacheSize returns the size of the stores own cache, which on a
+// follower may lag the leader.
+func (store *Store) CacheSize() cache.NodeSize {
+	c := store.cache()
+	size := cache.NodeSize{}
+	if count, ok := c.CountKeys(request.NewEmptyRequest()).Gobj.Value.(int32); ok {
+		size.Keys = count
+	}
+	if reporter, ok := c.(cache.MemoryReporter); ok {
+		size.Bytes = reporter.CountBytes()
+	}
+	return size
 }
 
 func (store *Store) BuildStoreFromSnapshot(bs *[]byte) {
-	// FUTURE: Switch to handle building for specified Cache types
-	c, _ := persistence.BuildCacheFromSnapshot(bs)
-	store.Cache = &(c)
+	switch store.policy {
+	case LFU_TYPE:
+		c, err := persistence.BuildLfuCacheFromSnapshot(bs)
+		if err != nil {
+			log.Printf("failed to rebuild LFU cache from snapshot: %s", err.Error())
+			return
+		}
+		store.Cache = c
+	case LRU_TYPE:
+		if store.Conf.ShardCount > 1 {
+			store.replaySnapshot(bs)
+			break
+		}
+		c, _ := persistence.BuildCacheFromSnapshot(bs)
+		c.MaxBytes = store.Conf.MaxMemoryBytes
+		store.Cache = &(c)
+	default:
+		store.replaySnapshot(bs)
+	}
+	store.commands = store.registerHandlers()
+	store.registerPolicyReporter()
+}
+
+// replaySnapshot rebuilds the stores cache by replaying the key-value
+// pairs of a snapshot into a new cache of the stores policy.
+func (store *Store) replaySnapshot(bs *[]byte) {
+	c := store.newCacheFromPolicy(store.policy)
+	if err := persistence.ReplaySnapshot(bs, c); err != nil {
+		log.Printf("failed to rebuild %s cache from snapshot: %s", store.policy, err.Error())
+		return
+	}
+	store.Cache = c
 }
 
 func (store *Store) BuildStoreFromAof() {
@@ -235,12 +677,19 @@ func (store *Store) BuildStoreFromAof() {
 }
 
 func (store *Store) RunStore() {
-	go crawlers.StartCrawlers(&store.Cache, store.crawlerScheduler)
+	store.mux.Lock()
+	store.crawling = true
+	c := store.Cache
+	store.mux.Unlock()
+
+	go crawlers.StartCrawlers(c, store.crawlerScheduler)
 	if store.Conf.SnapshotEnabled {
 		go persistence.StartSnapshotter(&store.Cache, &store.Conf, store.snapshotScheduler)
 	} else if store.Conf.PersistenceAOF {
+		// Replay the AOF before serving requests so the cache
+		// is fully rebuilt with the stores policy.
 		if ok, _ := persistence.AofExists(); ok {
-			go persistence.RebootAof(&store.Cache, store.Conf.AofMaxBytes)
+			persistence.RebootAof(&store.Cache, store.Conf.AofMaxBytes)
 		} else {
 			go persistence.BootAOF(&store.Cache, store.Conf.AofMaxBytes)
 		}
@@ -248,68 +697,70 @@ func (store *Store) RunStore() {
 }
 
 func (store *Store) StopStore() {
+	store.mux.Lock()
+	store.crawling = false
+	store.mux.Unlock()
+
 	go crawlers.StopScheduler(store.crawlerScheduler)
 	if store.Conf.SnapshotEnabled {
 		go persistence.StopSnapshotter(store.snapshotScheduler)
 	}
 }
 
+// ValidatePolicy returns an error if the store cannot
+// build a cache for the given policy type.
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case LRU_TYPE, LFU_TYPE, MRU_TYPE, ARC_TYPE, TLRU_TYPE, WTINYLFU_TYPE:
+		return nil
+	}
+	return fmt.Errorf("unknown cache policy %q, must be one of %s, %s, %s, %s, %s or %s",
+		policy, LRU_TYPE, LFU_TYPE, MRU_TYPE, ARC_TYPE, TLRU_TYPE, WTINYLFU_TYPE)
+}
+
 func (store *Store) newCacheFromPolicy(policy string) cache.Cache {
 	switch policy {
 	case LRU_TYPE:
+		if store.Conf.ShardCount > 1 {
+			return lru.NewShardedLRU(store.Conf)
+		}
 		return lru.NewLRU(store.Conf)
+	case LFU_TYPE:
+		return lfu.NewLFU(store.Conf)
+	case ARC_TYPE:
+		return arc.NewARC(store.Conf)
+	case TLRU_TYPE:
+		return tlru.NewTLRU(store.Conf)
+	case MRU_TYPE:
+		return mru.NewMRU(store.Conf)
+	case WTINYLFU_TYPE:
+		return tinylfu.NewTinyLFU(store.Conf)
 	default:
 		return nil
 	}
 }
 
 func (store *Store) Join(nodeID string, addr string) error {
-	fmt.Printf("received join request for remote node %s at %s\n", nodeID, addr)
-
-	configFuture := store.Raft.GetConfiguration()
-	if err := configFuture.Error(); err != nil {
-		fmt.Printf("failed to get raft configuration: %v", err)
-		return err
-	}
-
-	for _, srv := range configFuture.Configuration().Servers {
-		// If a node already exists with either the joining node's ID or address,
-		// that node may need to be removed from the config first.
-		if srv.ID == raft.ServerID(nodeID) || srv.Address == raft.ServerAddress(addr) {
-			// However if *both* the ID and the address are the same, then nothing -- not even
-			// a join operation -- is needed.
-			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(nodeID) {
-				fmt.Printf("node %s at %s already member of cluster, ignoring join request", nodeID, addr)
-				return nil
-			}
-
-			future := store.Raft.RemoveServer(srv.ID, 0, 0)
-			if err := future.Error(); err != nil {
-				return fmt.Errorf("error removing existing node %s at %s: %s", nodeID, addr, err)
-			}
-		}
-	}
-
 	// Add a voter. The voters will decide who is master when a new leader election is called.
-	f := store.Raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
-	if f.Error() != nil {
-		return f.Error()
-	}
-	fmt.Printf("node %s at %s joined successfully", nodeID, addr)
-	return nil
+	return store.addServer(nodeID, addr, raft.Voter)
 }
 
 func (store *Store) Open(enableSingle bool, localID string) error {
 	config := raft.DefaultConfig()
 	config.LocalID = raft.ServerID(localID)
 	store.ServerID = localID
+	notify := make(chan bool, 1)
+	config.NotifyCh = notify
+	if store.configureRaft != nil {
+		store.configureRaft(config)
+	}
 	
 	addr, err := net.ResolveTCPAddr("tcp", store.RaftBind)
 	if err != nil {
 		return err
 	}
 	
-	transport, err := raft.NewTCPTransport(store.RaftBind, addr, 3, raftTimeout, os.Stderr)
+	transport, err := store.newTransport(addr)
 	if err != nil {
 		return err
 	}
@@ -319,16 +770,34 @@ func (store *Store) Open(enableSingle bool, localID string) error {
 		return fmt.Errorf("file snapshot store: %s", err)
 	}
 
-	var logStore raft.LogStore
-	var stableStore raft.StableStore
-	logStore = raft.NewInmemStore()
-	stableStore = raft.NewInmemStore()
+	logStore, stableStore, err := store.openRaftStores()
+	if err != nil {
+		transport.Close()
+		return err
+	}
+	// Raft restores the latest snapshot and applies the
+	// entries after it again, which rebuilds the journal.
+	journalFrom := uint64(1)
+	if metas, err := snapshots.List(); err == nil && len(metas) > 0 {
+		journalFrom = metas[0].Index + 1
+	}
 
 	ra, err := raft.NewRaft(config, (*fsm)(store), logStore, stableStore, snapshots, transport)
 	if err != nil {
+		store.closeRaftStores()
+		transport.Close()
 		return fmt.Errorf("new raft: %s", err)
 	}
 	store.Raft = ra
+	store.transport = transport
+	store.logStore = logStore
+	store.openJournal(journalFrom)
+	store.shutdownCh = make(chan struct{})
+	go store.watchLeadership(notify, store.shutdownCh)
+	if store.Conf.ProposalBatchSize > 1 {
+		store.batcher = newBatcher(store, ra)
+		go store.batcher.run(store.shutdownCh)
+	}
 
 	if enableSingle {
 		configuration := raft.Configuration{
@@ -345,6 +814,68 @@ func (store *Store) Open(enableSingle bool, localID string) error {
 	return nil
 }
 
+// openRaftStores opens the Raft log and stable stores of the
+// kind set in the configuration. Durable stores are kept under
+// RaftDir, so the log, term and vote survive a restart.
+func (store *Store) openRaftStores() (raft.LogStore, raft.StableStore, error) {
+	switch store.Conf.RaftStore {
+	case config.RAFT_STORE_INMEM:
+		inmem := raft.NewInmemStore()
+		return inmem, inmem, nil
+	case config.RAFT_STORE_DURABLE, "":
+		logStore, err := raftlog.NewLogStore(filepath.Join(store.RaftDir, "log"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("raft log store: %s", err)
+		}
+		stableStore, err := raftlog.NewStableStore(filepath.Join(store.RaftDir, "stable.dat"))
+		if err != nil {
+			logStore.Close()
+			return nil, nil, fmt.Errorf("raft stable store: %s", err)
+		}
+		store.raftStores = []io.Closer{logStore, stableStore}
+		return logStore, stableStore, nil
+	}
+	return nil, nil, fmt.Errorf("unknown raft store %q", store.Conf.RaftStore)
+}
+
+func (store *Store) closeRaftStores() {
+	for _, c := range store.raftStores {
+		c.Close()
+	}
+	store.raftStores = nil
+}
+
+// Close shuts down Raft and releases its transport and stores,
+// so the store can be opened again from the same RaftDir. Raft is
+// left shut down rather than unset, so callers racing with Close
+// have their requests refused rather than finding no Raft.
+func (store *Store) Close() error {
+	if store.Raft == nil || store.Closed() {
+		return nil
+	}
+	close(store.shutdownCh)
+	err := store.Raft.Shutdown().Error()
+	store.transport.Close()
+	store.closeRaftStores()
+	return err
+}
+
+// Closed reports if the store has been closed since it was opened.
+func (store *Store) Closed() bool {
+	select {
+	case <-store.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel closed when the store is closed, which
+// stops the work a node does for the group while it runs.
+func (store *Store) Done() <-chan struct{} {
+	return store.shutdownCh
+}
+
 type fsm Store
 
 // GetNumericalID is used to get the numerical ID of a node from the list of peers
@@ -360,32 +891,76 @@ func GetNumericalID(ID string, peers []string) int {
 }
 
 
-func PeersList(rawConfig string) []string {
-	peers := []string{}
-	re := regexp.MustCompile(`ID:[0-9A-z]*`)
-	for _, peer := range re.FindAllString(rawConfig, -1) {
-		peers = append(peers, strings.Replace(peer, "ID:", "", -1))
+// Apply applies a Raft log entry to the key-value store.
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	c, err := decodeCommand(l.Data)
+	if err != nil {
+		log.Printf("failed to decode log entry %d: %s", l.Index, err.Error())
+		return response.BadEntryResponse(err)
 	}
-	return peers
+	(*Store)(f).startJournal(l.Index)
+
+	// Each command of a batch has its own response, which
+	// is returned to the caller that proposed it.
+	if c.Cmd == STORE_BATCH {
+		responses := make([]response.CacheResponse, len(c.Batch))
+		for i := range c.Batch {
+			responses[i] = f.applyCommand(&c.Batch[i], l.Index)
+		}
+		atomic.StoreUint64(&f.applied, l.Index)
+		return responses
+	}
+	res := f.applyCommand(c, l.Index)
+	atomic.StoreUint64(&f.applied, l.Index)
+	return res
 }
 
-// Apply applies a Raft log entry to the key-value store.
-func (f *fsm) Apply(l *raft.Log) interface{} {
-	// Handle all other commands
-	var c Command
-	
-	if err := json.Unmarshal(l.Data, &c); err != nil {
-		panic(fmt.Sprintf("failed to unmarshal command: %s", err.Error()))
+// applyCommand applies a command of the log entry with the given index.
+func (f *fsm) applyCommand(c *Command, index uint64) response.CacheResponse {
+	// Peers are not part of the cache
+	if c.Cmd == STORE_SET_PEER {
+		return (*Store)(f).setPeer(c.Args)
+	} else if c.Cmd == STORE_SET_GROUPS {
+		return (*Store)(f).setGroups(c.Args)
+	} else if c.Cmd == STORE_SET_MIGRATION {
+		return (*Store)(f).setMigration(c.Migration)
+	}
+
+	// Batches moved between groups are versioned by their log entry
+	if c.Cmd == STORE_IMPORT {
+		return (*Store)(f).importEntries(c.Entries, index)
+	} else if c.Cmd == STORE_RELEASE {
+		return (*Store)(f).releaseEntries(c.Entries)
+	} else if c.Cmd == STORE_REPLICATE {
+		return (*Store)(f).replicate(c, index)
 	}
 
-	if _, ok := f.commands[c.Cmd]; !ok {
+	handler, ok := (*Store)(f).handler(c.Cmd)
+	if !ok {
 		return response.BadCommandResponse(c.Cmd)
 	}
 	
-	execResult := f.commands[c.Cmd].(func(request.CacheRequest) response.CacheResponse)(c.Args)
+	// Writes are versioned by their log entry, which is the
+	// same on every replica.
+	if storedCommands[c.Cmd] {
+		c.Args.Version = index
+	}
+
+	execResult := handler(c.Args)
 	if f.Conf.PersistenceAOF {
-		writeAof(c.Cmd, &(c.Args))
+		// Expirations that found the key-value pair written
+		// again since it was marked removed nothing, as did
+		// conditional writes whose condition failed. Writes
+		// that derive the key-value pair from the one they
+		// found are written with the pair they stored.
+		if !conditionalCommands[c.Cmd] || execResult.Status == 1 {
+			if derivedCommands[c.Cmd] {
+				c.Args.Gobj = execResult.Gobj
+			}
+			writeAof(c.Cmd, &(c.Args))
+		}
 	}
+	(*Store)(f).journalWrite(c, index, execResult)
 	// CHECK RESPONSE AND SEND TO APP METRICS
 	monitor.WriteMetrics(f.appMetrics, c.Cmd, execResult)
 	
@@ -34,10 +34,14 @@ import (
 	"testing"
 	"time"
 	"io/ioutil"
+	"reflect"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/ghostdb/ghostdb-cache-node/config"
 	"github.com/ghostdb/ghostdb-cache-node/utils"
 	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
 )
 
 func TestStore(t *testing.T) {
@@ -69,4 +73,253 @@ func TestStore(t *testing.T) {
 
 	x = store.Execute("put", request.NewRequestFromValues("Key1", "NewValue1", -1))
 	utils.AssertEqual(t, x.Status, int32(1), "")
-}
\ No newline at end of file
+}
+
+func TestExpireKeys(t *testing.T) {
+	conf := config.InitializeConfiguration()
+
+	store := NewStore("LRU")
+	tmpDir, _ := ioutil.TempDir("", "store_test")
+	store.RaftDir = tmpDir
+	store.RaftBind = "127.0.0.1:0"
+
+	if err := store.Open(true, "node0"); err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+
+	// Simple way to ensure there is a leader.
+	time.Sleep(3 * time.Second)
+
+	store.BuildStore(conf)
+	utils.AssertEqual(t, store.IsLeader(), true, "")
+
+	// Clients cannot propose expirations.
+	x := store.Execute("expire", request.NewRequestFromValues("England", nil, -1))
+	utils.AssertEqual(t, x.Status, int32(0), "")
+
+	store.Execute("put", request.NewRequestFromValues("England", "London", 60))
+	marked := (*store.Cache.GetHashtableReference())["England"].Version
+	utils.AssertEqual(t, marked > 0, true, "")
+
+	// England is written again after it was marked, so the
+	// expiration must leave the new version in place.
+	store.Execute("put", request.NewRequestFromValues("England", "London", 60))
+	written := (*store.Cache.GetHashtableReference())["England"].Version
+	utils.AssertEqual(t, written > marked, true, "")
+
+	store.ExpireKeys(map[string]uint64{"England": marked})
+	x = store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, "London", "")
+
+	store.ExpireKeys(map[string]uint64{"England": written})
+	x = store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Message, "CACHE_MISS", "")
+}
+
+func TestExpireOnRead(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	dir, _ := ioutil.TempDir("", "store_test")
+	store := openClusterNode(t, conf, dir, freeRaftAddr(t), "node0", true)
+	defer store.Close()
+	clusterLeader(t, []*Store{store})
+
+	store.Execute("put", request.NewRequestFromValues("England", "London", 60))
+	(*store.Cache.GetHashtableReference())["England"].CreatedAt -= 120
+
+	// The leader removes the expired key-value pair it reads
+	x := store.Execute("get", request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, x.Message, "CACHE_MISS", "")
+	utils.AssertEqual(t, store.Cache.Contains("England"), false, "")
+	utils.AssertEqual(t, store.Cache.CountKeys(request.NewEmptyRequest()).Gobj.Value, int32(0), "")
+	utils.AssertEqual(t, atomic.LoadUint64(&store.appMetrics.Removed), uint64(1), "")
+}
+
+// openClusterNode opens a store with durable Raft state in dir
+func openClusterNode(t *testing.T, conf config.Configuration, dir, addr, id string, bootstrap bool) *Store {
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+	store.RaftDir = dir
+	store.RaftBind = addr
+	store.HTTPAddr = id + ":7991"
+	if err := store.Open(bootstrap, id); err != nil {
+		t.Fatalf("failed to open store %s: %s", id, err)
+	}
+	return store
+}
+
+// clusterLeader waits for one of the stores to become leader
+func clusterLeader(t *testing.T, stores []*Store) *Store {
+	var leader *Store
+	elected := waitFor(10*time.Second, func() bool {
+		for _, store := range stores {
+			if store.IsLeader() {
+				leader = store
+				return true
+			}
+		}
+		return false
+	})
+	if !elected {
+		t.Fatalf("no leader elected")
+	}
+	return leader
+}
+
+func raftTerm(store *Store) uint64 {
+	term, _ := strconv.ParseUint(store.Raft.Stats()["term"], 10, 64)
+	return term
+}
+
+func TestDurableRaftStateSurvivesRestart(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.RaftStore = config.RAFT_STORE_DURABLE
+
+	ids := []string{"node0", "node1", "node2"}
+	dirs := make([]string, len(ids))
+	addrs := make([]string, len(ids))
+	stores := make([]*Store, len(ids))
+	for i := range ids {
+		dirs[i], _ = ioutil.TempDir("", "store_test")
+		addrs[i] = freeRaftAddr(t)
+		stores[i] = openClusterNode(t, conf, dirs[i], addrs[i], ids[i], i == 0)
+	}
+	leader := clusterLeader(t, stores[:1])
+	for i := 1; i < len(ids); i++ {
+		if err := leader.Join(ids[i], addrs[i]); err != nil {
+			t.Fatalf("failed to join: %s", err)
+		}
+	}
+
+	leader.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	leader.Execute("put", request.NewRequestFromValues("Ireland", "Dublin", 600))
+	leader.Execute("put", request.NewRequestFromValues("France", "Paris", -1))
+	leader.Execute("delete", request.NewRequestFromValues("France", "", -1))
+	entries := leader.cache().Entries()
+	lastIndex := leader.Raft.LastIndex()
+
+	replicated := waitFor(10*time.Second, func() bool {
+		for _, store := range stores {
+			if store.Raft.AppliedIndex() < lastIndex {
+				return false
+			}
+		}
+		return true
+	})
+	utils.AssertEqual(t, replicated, true, "")
+
+	terms := make([]uint64, len(ids))
+	for i, store := range stores {
+		terms[i] = raftTerm(store)
+		if err := store.Close(); err != nil {
+			t.Fatalf("failed to close store: %s", err)
+		}
+	}
+
+	// Restart every node from its Raft directory, without
+	// bootstrapping, so the cluster only knows what it kept on disk.
+	for i := range ids {
+		stores[i] = openClusterNode(t, conf, dirs[i], addrs[i], ids[i], false)
+		defer stores[i].Close()
+
+		utils.AssertEqual(t, raftTerm(stores[i]) >= terms[i], true, "")
+		utils.AssertEqual(t, stores[i].Raft.LastIndex() >= lastIndex, true, "")
+	}
+
+	leader = clusterLeader(t, stores)
+	utils.AssertEqual(t, raftTerm(leader) > terms[0], true, "")
+
+	// The committed entries are applied again on every node once
+	// the new leader commits its first entry.
+	for _, store := range stores {
+		store := store
+		restored := waitFor(10*time.Second, func() bool {
+			return reflect.DeepEqual(store.cache().Entries(), entries)
+		})
+		utils.AssertEqual(t, restored, true, "")
+	}
+
+	x := leader.Execute("put", request.NewRequestFromValues("Spain", "Madrid", -1))
+	utils.AssertEqual(t, x.Status, int32(1), "")
+}
+
+func TestReadConsistency(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	leaderDir, _ := ioutil.TempDir("", "store_test")
+	followerDir, _ := ioutil.TempDir("", "store_test")
+	leader := openClusterNode(t, conf, leaderDir, freeRaftAddr(t), "node0", true)
+	defer leader.Close()
+	clusterLeader(t, []*Store{leader})
+
+	follower := openClusterNode(t, conf, followerDir, freeRaftAddr(t), "node1", false)
+	defer follower.Close()
+	if err := leader.Join("node1", follower.RaftBind); err != nil {
+		t.Fatalf("failed to join: %s", err)
+	}
+
+	leader.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	replicated := waitFor(10*time.Second, func() bool {
+		return follower.Raft.AppliedIndex() >= leader.Raft.LastIndex() &&
+			follower.LeaderHTTPAddr() == leader.HTTPAddr
+	})
+	utils.AssertEqual(t, replicated, true, "")
+
+	get := func(store *Store, level string) response.CacheResponse {
+		args := request.NewRequestFromValues("England", "", -1)
+		args.Consistency = level
+		return store.Execute("get", args)
+	}
+
+	for _, level := range []string{"", request.CONSISTENCY_STALE, request.CONSISTENCY_LEADER, request.CONSISTENCY_LINEARIZABLE} {
+		utils.AssertEqual(t, get(leader, level).Gobj.Value, "London", "")
+	}
+
+	// The follower serves stale reads only, and points the
+	// client at the leaders HTTP address for the others.
+	utils.AssertEqual(t, get(follower, request.CONSISTENCY_STALE).Gobj.Value, "London", "")
+	for _, level := range []string{request.CONSISTENCY_LEADER, request.CONSISTENCY_LINEARIZABLE} {
+		x := get(follower, level)
+		utils.AssertEqual(t, x.Error, response.NOT_LEADER_ERR, "")
+		utils.AssertEqual(t, x.Gobj.Value, leader.HTTPAddr, "")
+	}
+
+	utils.AssertEqual(t, get(leader, "eventual").Error, response.INVALID_CONSISTENCY_ERR, "")
+}
+
+func TestFollowerWriteNamesLeader(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	leaderDir, _ := ioutil.TempDir("", "store_test")
+	followerDir, _ := ioutil.TempDir("", "store_test")
+	leader := openClusterNode(t, conf, leaderDir, freeRaftAddr(t), "node0", true)
+	defer leader.Close()
+	clusterLeader(t, []*Store{leader})
+
+	follower := openClusterNode(t, conf, followerDir, freeRaftAddr(t), "node1", false)
+	defer follower.Close()
+	if err := leader.Join("node1", follower.RaftBind); err != nil {
+		t.Fatalf("failed to join: %s", err)
+	}
+	if err := leader.SetPeerHTTPAddr("node1", follower.HTTPAddr); err != nil {
+		t.Fatalf("failed to set peer: %s", err)
+	}
+
+	// The leader recorded its own HTTP address when it was elected
+	known := waitFor(10*time.Second, func() bool {
+		return follower.LeaderHTTPAddr() == leader.HTTPAddr &&
+			follower.peerHTTPAddrs()["node1"] == follower.HTTPAddr
+	})
+	utils.AssertEqual(t, known, true, "")
+
+	x := follower.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	utils.AssertEqual(t, x.Error, response.NOT_LEADER_ERR, "")
+	utils.AssertEqual(t, x.Gobj.Value, leader.HTTPAddr, "")
+
+	// Clients cannot record peers
+	x = leader.Execute("setPeer", request.NewRequestFromValues("node2", "evil:7991", -1))
+	utils.AssertEqual(t, x.Error, response.INVALID_COMMAND_ERR, "")
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/config"
+)
+
+// tlsStreamLayer is a raft.StreamLayer that encrypts Raft traffic
+// with TLS. Both ends present a certificate signed by the clusters
+// CA, so only nodes holding such a certificate can join the cluster.
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	config    *tls.Config
+}
+
+// Dial connects to the node at address, completing the handshake
+// within the timeout. The certificate of the node must name the host
+// of its address.
+func (layer *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), layer.config)
+}
+
+// Addr returns the address other nodes connect to.
+func (layer *tlsStreamLayer) Addr() net.Addr {
+	if layer.advertise != nil {
+		return layer.advertise
+	}
+	return layer.Listener.Addr()
+}
+
+// newTransport returns the transport Raft traffic is sent over,
+// TLS if it is configured, otherwise plain TCP.
+func (store *Store) newTransport(advertise *net.TCPAddr) (*raft.NetworkTransport, error) {
+	if !store.Conf.RaftTLSEnabled() {
+		return raft.NewTCPTransport(store.RaftBind, advertise, 3, raftTimeout, os.Stderr)
+	}
+
+	tlsConfig, err := RaftTLSConfig(store.Conf)
+	if err != nil {
+		return nil, err
+	}
+	if advertise.IP == nil || advertise.IP.IsUnspecified() {
+		return nil, errors.New("local bind address is not advertisable")
+	}
+
+	listener, err := tls.Listen("tcp", store.RaftBind, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	stream := &tlsStreamLayer{
+		Listener:  listener,
+		advertise: advertise,
+		config:    tlsConfig,
+	}
+	return raft.NewNetworkTransport(stream, 3, raftTimeout, os.Stderr), nil
+}
+
+// RaftTLSConfig builds the TLS configuration of the Raft transport
+// from the certificate, key and CA given in the configuration. The
+// node presents its certificate both when it accepts and when it
+// dials a connection, and requires the other end to present one
+// signed by the CA. Requests nodes send each other over HTTP use
+// the same configuration.
+func RaftTLSConfig(conf config.Configuration) (*tls.Config, error) {
+	if conf.RaftTLSCertFile == "" || conf.RaftTLSKeyFile == "" || conf.RaftTLSCAFile == "" {
+		return nil, errors.New("raft TLS requires a certificate, key and CA file")
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.RaftTLSCertFile, conf.RaftTLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load raft TLS certificate: %s", err)
+	}
+	ca, err := ioutil.ReadFile(conf.RaftTLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft TLS CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in raft TLS CA %s", conf.RaftTLSCAFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package base
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// testCA is a self-signed CA that signs the certificates of test nodes
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA: %s", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate for 127.0.0.1 signed by the CA, and sets
+// the configuration to present it and to trust the CA.
+func (ca *testCA) issue(t *testing.T, dir string, name string, conf *config.Configuration) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	conf.RaftTLSCertFile = filepath.Join(dir, name+".crt")
+	conf.RaftTLSKeyFile = filepath.Join(dir, name+".key")
+	conf.RaftTLSCAFile = ca.file
+	writePEM(t, conf.RaftTLSCertFile, "CERTIFICATE", der)
+	writePEM(t, conf.RaftTLSKeyFile, "EC PRIVATE KEY", keyDer)
+}
+
+func writePEM(t *testing.T, path string, kind string, der []byte) {
+	b := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("failed to write %s: %s", path, err)
+	}
+}
+
+func TestRaftTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls_test")
+	ca := newTestCA(t, dir, "ca")
+	rogueCA := newTestCA(t, dir, "rogue-ca")
+
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+
+	open := func(id string, ca *testCA, bootstrap bool) *Store {
+		c := conf
+		if ca != nil {
+			ca.issue(t, dir, id, &c)
+		}
+		raftDir, _ := ioutil.TempDir("", "store_test")
+		return openClusterNode(t, c, raftDir, freeRaftAddr(t), id, bootstrap)
+	}
+	leader := open("node0", ca, true)
+	defer leader.Close()
+	follower := open("node1", ca, false)
+	defer follower.Close()
+	rogue := open("node2", rogueCA, false)
+	defer rogue.Close()
+	plain := open("node3", nil, false)
+	defer plain.Close()
+	clusterLeader(t, []*Store{leader})
+
+	if err := leader.Join("node1", follower.RaftBind); err != nil {
+		t.Fatalf("failed to join: %s", err)
+	}
+	// Learners, so the nodes that cannot connect do not cost a quorum
+	for _, s := range []*Store{rogue, plain} {
+		if err := leader.AddLearner(s.ServerID, s.RaftBind); err != nil {
+			t.Fatalf("failed to add learner: %s", err)
+		}
+	}
+
+	x := leader.Execute("put", request.NewRequestFromValues("England", "London", -1))
+	utils.AssertEqual(t, x.Status, int32(1), "")
+
+	// Nodes with a certificate signed by the CA replicate
+	replicated := waitFor(10*time.Second, func() bool {
+		return follower.Contains("England")
+	})
+	utils.AssertEqual(t, replicated, true, "")
+
+	// Nodes with a certificate signed by another CA, or without TLS,
+	// cannot take part in the cluster
+	replicated = waitFor(3*time.Second, func() bool {
+		return rogue.Contains("England") || plain.Contains("England")
+	})
+	utils.AssertEqual(t, replicated, false, "")
+
+	// Nor can a client without a certificate, whose connection is
+	// closed rather than left waiting for it to send a request
+	tlsConfig, err := RaftTLSConfig(leader.Conf)
+	utils.AssertEqual(t, err, nil, "")
+	tlsConfig.Certificates = nil
+	conn, err := tls.Dial("tcp", leader.RaftBind, tlsConfig)
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	utils.AssertEqual(t, err != nil, true, "")
+	timeout, ok := err.(net.Error)
+	utils.AssertEqual(t, ok && timeout.Timeout(), false, "")
+}
+
+func TestRaftTLSConfigRequiresEveryFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls_test")
+	ca := newTestCA(t, dir, "ca")
+
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	ca.issue(t, dir, "node0", &conf)
+	conf.RaftTLSCAFile = ""
+
+	store := NewStore(LRU_TYPE)
+	store.BuildStore(conf)
+	store.RaftDir, _ = ioutil.TempDir("", "store_test")
+	store.RaftBind = freeRaftAddr(t)
+	utils.AssertEqual(t, store.Open(true, "node0") != nil, true, "")
+}
@@ -62,6 +62,22 @@ type Cache interface {
 	// e.g. the cache crawlers.
 	DeleteByKey(key string) response.CacheResponse
 
+	// ExpireByKey removes an expired key/value pair if it is still
+	// at the version it was marked expired at. Expirations are
+	// replicated, so a key/value pair written again after it was
+	// marked must be left in the cache.
+	ExpireByKey(key string, version uint64) response.CacheResponse
+
+	// Contains reports if the cache holds a key/value pair for the
+	// key, without counting as a use of it. Used to route requests
+	// for a slot that is being moved to another Raft group.
+	Contains(key string) bool
+
+	// Peek returns a copy of the key/value pair for the key, if the
+	// cache holds one, without counting as a use of it. Used to
+	// resolve conflicting writes replicated from another cluster.
+	Peek(key string) (lru.Entry, bool)
+
 	// Flush removes all key/value pairs from the cache even if they
 	// have not expired
 	Flush(request.CacheRequest) response.CacheResponse
@@ -69,6 +85,40 @@ type Cache interface {
 	// CountKeys return the number of keys in the cache
 	CountKeys(request.CacheRequest) response.CacheResponse
 
+	// Entries returns a copy of the key/value pairs in the cache
+	// in the order they should be replayed to rebuild it, the
+	// next to be evicted first.
+	Entries() []lru.Entry
+
 	// GetHashtableReference is for internal use by crawlers and AOF
 	GetHashtableReference() *map[string]*lru.Node
-}
\ No newline at end of file
+}
+
+// MemoryReporter is implemented by caches that track the
+// estimated number of bytes used by their key/value pairs.
+type MemoryReporter interface {
+	// CountBytes returns the estimated number of bytes used
+	// by the key/value pairs in the cache.
+	CountBytes() int64
+}
+
+// ExpiryNotifier is implemented by caches that remove expired
+// key/value pairs found by a write, such as add, rather than
+// leaving them until the crawlers next run. Reads do not remove
+// expired key/value pairs, they only hide them, and the leader
+// removes the ones it reads through the replication log.
+type ExpiryNotifier interface {
+	// SetExpiryHook sets a function called with the key of each
+	// key/value pair removed by a write because it expired.
+	SetExpiryHook(hook func(key string))
+}
+
+// NodeSize is the value of the response to the nodeSize command.
+type NodeSize struct {
+	// Keys is the number of key/value pairs in the cache
+	Keys  int32
+
+	// Bytes is the estimated number of bytes used by the key/value
+	// pairs in the cache. It is 0 if the cache does not track memory.
+	Bytes int64
+}
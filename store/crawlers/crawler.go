@@ -34,49 +34,44 @@ import (
 	"time"
 
 	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
 )
 
-// StartCrawl crawls the cache and evicts stale data
-func StartCrawl(cache *lru.LRUCache) {
-	markedKeys := mark(cache)
-	sweep(cache, markedKeys)
-	return
-}
-
-// Traverse the cache and mark key-value pair nodes
-// for removal.
-func mark(cache *lru.LRUCache) []string {
-	markedKeys := []string{}
+// Crawlable is a cache the crawlers can search for stale
+// key-value pairs. Every cache policy implements it.
+type Crawlable interface {
+	// Entries returns a copy of the key-value pairs in the cache,
+	// taken under its lock, so the crawl does not hold it.
+	Entries() []lru.Entry
 
-	node, _ := lru.GetLastNode(cache.DLL)
-
-	// List is empty
-	if node == nil {
-		return []string{}
-	}
+	// ExpireByKey removes an expired key-value pair if it is
+	// still at the version it was marked expired at.
+	ExpireByKey(key string, version uint64) response.CacheResponse
+}
 
-	// Crawl until node.Prev is nil i.e. the Head Node
-	for ok := true; ok; ok = !(node.Prev == nil) {
-		node.Mux.Lock()
+// StartCrawl crawls the cache and evicts stale data
+func StartCrawl(cache Crawlable) {
+	sweep(cache, mark(cache))
+}
 
-		if node.TTL != -1 {
-			now := time.Now().Unix()
+// Traverse a copy of the cache and mark key-value pairs
+// for removal, with the version they were marked at.
+func mark(cache Crawlable) map[string]uint64 {
+	markedKeys := map[string]uint64{}
+	now := time.Now().Unix()
 
-			if node.CreatedAt+node.TTL < now {
-				markedKeys = append(markedKeys, node.Key)
-			}
+	for _, entry := range cache.Entries() {
+		if entry.Expired(now) {
+			markedKeys[entry.Key] = entry.Version
 		}
-		node.Mux.Unlock()
-		node = node.Prev
 	}
-
 	return markedKeys
 }
 
-// Sweep the cache removing the marked nodes
-func sweep(cache *lru.LRUCache, keys []string) {
-	for _, key := range keys {
-		cache.DeleteByKey(key)
+// Sweep the cache removing the marked key-value pairs, each only
+// if it has not been written again since it was marked.
+func sweep(cache Crawlable, marked map[string]uint64) {
+	for key, version := range marked {
+		cache.ExpireByKey(key, version)
 	}
-	return
 }
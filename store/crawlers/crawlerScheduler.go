@@ -34,13 +34,27 @@ import (
 	"time"
 
 	"github.com/ghostdb/ghostdb-cache-node/store/cache"
-	"github.com/ghostdb/ghostdb-cache-node/store/lru"
 )
 
 // CrawlerScheduler represents a scheduler for cache crawlers
 type CrawlerScheduler struct {
 	Interval time.Duration
 	stop     chan bool
+	expirer  Expirer
+}
+
+// Expirer removes the stale key-value pairs found by the crawlers.
+// A replicated store implements it so that one node decides which
+// key-value pairs have expired and every replica removes them in
+// the same order, through the replication log.
+type Expirer interface {
+	// IsLeader reports if this node decides which key-value pairs
+	// have expired. The crawlers do not run on other nodes.
+	IsLeader() bool
+
+	// ExpireKeys removes the marked key-value pairs, each only if
+	// it is still at the version it was marked at.
+	ExpireKeys(marked map[string]uint64)
 }
 
 // NewCrawlerScheduler initializes a new Crawler Scheduler
@@ -53,32 +67,50 @@ func NewCrawlerScheduler(interval int32) *CrawlerScheduler {
 	return scheduler
 }
 
+// SetExpirer sets the expirer the crawlers hand stale key-value
+// pairs to. Without one the crawlers remove them from the cache
+// directly.
+func SetExpirer(scheduler *CrawlerScheduler, expirer Expirer) {
+	scheduler.expirer = expirer
+}
+
+// crawl runs a crawl of a cache, expiring the key-value
+// pairs it marks.
+func (scheduler *CrawlerScheduler) crawl(c Crawlable) {
+	if scheduler.expirer == nil {
+		sweep(c, mark(c))
+		return
+	}
+	if !scheduler.expirer.IsLeader() {
+		return
+	}
+	if marked := mark(c); len(marked) > 0 {
+		scheduler.expirer.ExpireKeys(marked)
+	}
+}
+
 /*
 	StartCrawlers will start the cache crawler.
 
 	The crawler is periodically run on the cache until the ticker
-	is stopped.
-	StartCrawlers is generic in nature and will run the appropriate
-	crawler for the stores underlying cache policy implementation.
+	is stopped. Every cache policy is crawled the same way, through
+	a copy of its key-value pairs, so the crawler does not hold the
+	lock of the cache, or of any segment of it, while it crawls.
+
+	If the scheduler has an expirer, the crawlers only run on the
+	node it reports as the leader and hand the stale key-value
+	pairs to it rather than removing them from the cache.
 
-	POLICY TO CRAWLER MAP:
-		1) LRU Policy -> startDllCrawler
+	The crawler keeps crawling the cache it is given, so it must be
+	restarted with the new cache if the store swaps its cache.
 		
 */
-func StartCrawlers(cache *cache.Cache, scheduler *CrawlerScheduler) {
+func StartCrawlers(c cache.Cache, scheduler *CrawlerScheduler) {
 	ticker := time.NewTicker(scheduler.Interval)
-	switch (*cache).(type) {
-	case *lru.LRUCache:
-		startDllCrawler((*cache).(*lru.LRUCache), ticker, scheduler)
-	}
-}
-
-// startDllCrawler starts a crawler that crawls a doubly linked list
-func startDllCrawler(cache *lru.LRUCache, ticker *time.Ticker, scheduler *CrawlerScheduler) {
 	for {
 		select {
 			case <- ticker.C:
-				go StartCrawl(cache)
+				go scheduler.crawl(c)
 			case <- scheduler.stop:
 				ticker.Stop()
 				return
@@ -37,7 +37,13 @@ import (
 	"github.com/ghostdb/ghostdb-cache-node/config"
 	"github.com/ghostdb/ghostdb-cache-node/utils"
 	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+	"github.com/ghostdb/ghostdb-cache-node/store/arc"
+	"github.com/ghostdb/ghostdb-cache-node/store/lfu"
 	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/mru"
+	"github.com/ghostdb/ghostdb-cache-node/store/tinylfu"
+	"github.com/ghostdb/ghostdb-cache-node/store/tlru"
 )
 
 func TestCrawler(t *testing.T) {
@@ -62,3 +68,76 @@ func TestCrawler(t *testing.T) {
 	utils.AssertEqual(t, cache.Count, int32(2), "")
 	cache.Mux.Unlock()
 }
+
+type testExpirer struct {
+	leader  bool
+	expired map[string]uint64
+}
+
+func (e *testExpirer) IsLeader() bool {
+	return e.leader
+}
+
+func (e *testExpirer) ExpireKeys(marked map[string]uint64) {
+	for key, version := range marked {
+		e.expired[key] = version
+	}
+}
+
+func TestCrawlerExpirer(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := lru.NewLRU(config)
+
+	stale := request.NewRequestFromValues("England", "London", 5)
+	stale.Version = 7
+	cache.Put(stale)
+	cache.Put(request.NewRequestFromValues("Italy", "Rome", -1))
+	cache.Hashtable["England"].CreatedAt -= 10
+
+	expirer := &testExpirer{expired: map[string]uint64{}}
+	scheduler := NewCrawlerScheduler(config.CrawlerInterval)
+	SetExpirer(scheduler, expirer)
+
+	// Only the leader decides expirations
+	scheduler.crawl(cache)
+	utils.AssertEqual(t, len(expirer.expired), 0, "")
+
+	// The leader hands them to the expirer with their version,
+	// rather than removing them from its cache directly.
+	expirer.leader = true
+	scheduler.crawl(cache)
+	utils.AssertEqual(t, len(expirer.expired), 1, "")
+	utils.AssertEqual(t, expirer.expired["England"], uint64(7), "")
+	utils.AssertEqual(t, cache.Count, int32(2), "")
+
+	// Expirations only apply to the version they were decided for
+	utils.AssertEqual(t, cache.ExpireByKey("England", 6).Message, lru.NOT_FOUND, "")
+	utils.AssertEqual(t, cache.ExpireByKey("England", 7).Message, lru.REMOVED, "")
+}
+
+func TestCrawlerPolicies(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.ShardCount = 4
+	caches := []interface {
+		Crawlable
+		Put(request.CacheRequest) response.CacheResponse
+		Contains(string) bool
+	}{
+		lru.NewLRU(config), lru.NewShardedLRU(config), lfu.NewLFU(config), arc.NewARC(config),
+		tlru.NewTLRU(config), mru.NewMRU(config), tinylfu.NewTinyLFU(config),
+	}
+
+	// Every policy is crawled the same way
+	for _, cache := range caches {
+		stale := request.NewRequestFromValues("England", "London", 5)
+		stale.Timestamp = time.Now().Unix() - 10
+		cache.Put(stale)
+		cache.Put(request.NewRequestFromValues("Italy", "Rome", -1))
+		cache.Put(request.NewRequestFromValues("Ireland", "Dublin", 60))
+
+		StartCrawl(cache)
+		utils.AssertEqual(t, cache.Contains("England"), false, "")
+		utils.AssertEqual(t, cache.Contains("Italy"), true, "")
+		utils.AssertEqual(t, cache.Contains("Ireland"), true, "")
+	}
+}
@@ -0,0 +1,373 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lfu
+
+import (
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// agingFactor controls how often access frequencies are aged.
+// Once the cache has served agingFactor * Size accesses, every
+// frequency is halved so keys that were hot a long time ago
+// cannot stay in the cache forever.
+const agingFactor = 10
+
+// LFUCache represents a least frequently used cache object
+type LFUCache struct {
+	lru.Keyed
+
+	// Size represents the maximum number of allowable
+	// key-value pairs in the cache.
+	Size int32
+
+	// Count records the number of key-value pairs
+	// currently in the cache.
+	Count int32
+
+	// Full tracks if Count is equal to Size
+	Full bool
+
+
+	// Frequencies maps keys to their access frequency
+	Frequencies map[string]int64
+
+	// Accesses records the number of accesses since the
+	// frequencies were last aged.
+	Accesses int64
+
+	// buckets maps a frequency to the bucket holding every
+	// key-value pair accessed that many times.
+	buckets map[int64]*bucket
+
+	// head is the bucket with the lowest frequency. Buckets
+	// are linked in increasing order of frequency.
+	head *bucket
+}
+
+// bucket holds all key-value pairs that share an access
+// frequency. Within a bucket, pairs are kept in recency order
+// so ties are broken by evicting the least recently used pair.
+type bucket struct {
+	frequency int64
+	entries   *lru.List
+	prev      *bucket
+	next      *bucket
+}
+
+// NewLFU will initialize the cache
+func NewLFU(config config.Configuration) *LFUCache {
+	cache := &LFUCache{
+		Size:        config.KeyspaceSize,
+		Count:       int32(0),
+		Full:        false,
+		Frequencies: make(map[string]int64),
+		buckets:     make(map[int64]*bucket),
+	}
+	cache.InitKeyed(cache.remove, cache.replayOrder)
+	return cache
+}
+
+// Get will fetch a key/value pair from the cache
+func (cache *LFUCache) Get(args request.CacheRequest) response.CacheResponse {
+	key := args.Gobj.Key
+
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	node, ok := cache.Live(key)
+	if !ok {
+		return response.NewCacheMissResponse()
+	}
+	cache.touch(node)
+
+	return response.NewResponseFromValue(node.Value)
+}
+
+// Put will add a key/value pair to the cache, possibly
+// overwriting an existing key/value pair. Put will evict
+// the least frequently used key/value pair if the cache is full.
+func (cache *LFUCache) Put(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Value = args.Gobj.Value
+		node.TTL = args.Gobj.TTL
+		node.Stamp(args)
+		cache.touch(node)
+		return response.NewResponseFromMessage(lru.STORED, 1)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Add will add a key/value pair to the cache if the key
+// does not exist already.
+func (cache *LFUCache) Add(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok && !cache.Expire(node, lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_STORED, 0)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Flush removes all key/value pairs from the cache even if they have not expired
+func (cache *LFUCache) Flush(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.Hashtable = make(map[string]*lru.Node)
+	cache.Frequencies = make(map[string]int64)
+	cache.buckets = make(map[int64]*bucket)
+	cache.head = nil
+	cache.Count = 0
+	cache.Accesses = 0
+	cache.Full = false
+
+	return response.NewResponseFromMessage(lru.FLUSHED, 1)
+}
+
+// CountKeys return the number of keys in the cache
+func (cache *LFUCache) CountKeys(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+	return response.NewResponseFromValue(cache.Count)
+}
+
+// Rebuild relinks the frequency buckets from the Hashtable and
+// Frequencies maps. It is used after the cache has been
+// deserialized from a snapshot, where only the maps are stored.
+func (cache *LFUCache) Rebuild() {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.InitKeyed(cache.remove, cache.replayOrder)
+	if cache.Frequencies == nil {
+		cache.Frequencies = make(map[string]int64)
+	}
+	cache.buckets = make(map[int64]*bucket)
+	cache.head = nil
+
+	for key, node := range cache.Hashtable {
+		freq, ok := cache.Frequencies[key]
+		if !ok || freq < 1 {
+			freq = 1
+			cache.Frequencies[key] = freq
+		}
+		lru.InsertNode(cache.bucketFor(freq), node)
+	}
+	cache.Count = int32(len(cache.Hashtable))
+	cache.Full = cache.Count >= cache.Size
+}
+
+// insert adds a new key-value pair with a frequency of one,
+// evicting the least frequently used pair first if the
+// cache is full. The caller must hold the cache lock.
+func (cache *LFUCache) insert(key string, value interface{}, ttl int64) {
+	if cache.Count >= cache.Size {
+		cache.evict()
+	}
+
+	node, _ := lru.Insert(cache.bucketFor(1), key, value, ttl)
+	cache.Hashtable[key] = node
+	cache.Frequencies[key] = 1
+	cache.Count++
+	cache.Full = cache.Count >= cache.Size
+}
+
+// evict removes the least recently used key-value pair from the
+// lowest frequency bucket. The caller must hold the cache lock.
+func (cache *LFUCache) evict() {
+	if cache.head == nil {
+		return
+	}
+	node, err := lru.GetLastNode(cache.head.entries)
+	if err != nil {
+		return
+	}
+	cache.remove(node)
+}
+
+// touch records an access to a key-value pair, moving it into
+// the bucket for its next frequency. The caller must hold the
+// cache lock.
+func (cache *LFUCache) touch(node *lru.Node) {
+	freq := cache.Frequencies[node.Key]
+	current := cache.buckets[freq]
+
+	next := cache.buckets[freq+1]
+	if next == nil {
+		next = &bucket{frequency: freq + 1, entries: lru.InitList()}
+		cache.linkAfter(current, next)
+	}
+
+	lru.RemoveNode(current.entries, node)
+	lru.InsertNode(next.entries, node)
+	cache.Frequencies[node.Key] = freq + 1
+	cache.releaseIfEmpty(current)
+
+	cache.Accesses++
+	if cache.Accesses >= int64(cache.Size)*agingFactor {
+		cache.age()
+	}
+}
+
+// remove unlinks a key-value pair from its bucket and the
+// lookup tables. The caller must hold the cache lock.
+func (cache *LFUCache) remove(node *lru.Node) {
+	b := cache.buckets[cache.Frequencies[node.Key]]
+	lru.RemoveNode(b.entries, node)
+	cache.releaseIfEmpty(b)
+
+	delete(cache.Hashtable, node.Key)
+	delete(cache.Frequencies, node.Key)
+	cache.Count--
+	cache.Full = false
+}
+
+// replayOrder returns the lists of the frequency buckets, whose
+// key-value pairs are replayed least frequently and then least
+// recently used first.
+func (cache *LFUCache) replayOrder() []*lru.List {
+	lists := make([]*lru.List, 0, len(cache.buckets))
+	for b := cache.head; b != nil; b = b.next {
+		lists = append(lists, b.entries)
+	}
+	return lists
+}
+
+// age halves the frequency of every key-value pair. Halving keeps
+// the relative order of the buckets, so adjacent buckets that end
+// up with the same frequency are merged in place.
+func (cache *LFUCache) age() {
+	var prev *bucket
+	for b := cache.head; b != nil; {
+		next := b.next
+		delete(cache.buckets, b.frequency)
+
+		freq := b.frequency / 2
+		if freq < 1 {
+			freq = 1
+		}
+
+		if prev != nil && prev.frequency == freq {
+			// Move the pairs across oldest first so the merged
+			// bucket keeps their relative recency.
+			for {
+				node, err := lru.GetLastNode(b.entries)
+				if err != nil {
+					break
+				}
+				lru.RemoveNode(b.entries, node)
+				lru.InsertNode(prev.entries, node)
+				cache.Frequencies[node.Key] = freq
+			}
+			prev.next = next
+			if next != nil {
+				next.prev = prev
+			}
+		} else {
+			b.frequency = freq
+			cache.buckets[freq] = b
+			for node := b.entries.Head.Next; node != b.entries.Tail; node = node.Next {
+				cache.Frequencies[node.Key] = freq
+			}
+			prev = b
+		}
+		b = next
+	}
+	cache.Accesses = 0
+}
+
+// bucketFor returns the entry list for a frequency, creating and
+// linking a new bucket if needed. The caller must hold the cache lock.
+func (cache *LFUCache) bucketFor(freq int64) *lru.List {
+	if b, ok := cache.buckets[freq]; ok {
+		return b.entries
+	}
+
+	b := &bucket{frequency: freq, entries: lru.InitList()}
+	var prev *bucket
+	for cur := cache.head; cur != nil && cur.frequency < freq; cur = cur.next {
+		prev = cur
+	}
+	cache.linkAfter(prev, b)
+
+	return b.entries
+}
+
+// linkAfter links bucket b into the bucket list directly after prev,
+// or at the head of the list if prev is nil.
+func (cache *LFUCache) linkAfter(prev *bucket, b *bucket) {
+	cache.buckets[b.frequency] = b
+	b.prev = prev
+	if prev == nil {
+		b.next = cache.head
+		cache.head = b
+	} else {
+		b.next = prev.next
+		prev.next = b
+	}
+	if b.next != nil {
+		b.next.prev = b
+	}
+}
+
+// releaseIfEmpty unlinks a bucket once it no longer holds any
+// key-value pairs.
+func (cache *LFUCache) releaseIfEmpty(b *bucket) {
+	if b.entries.Size > 0 {
+		return
+	}
+	delete(cache.buckets, b.frequency)
+	if b.prev == nil {
+		cache.head = b.next
+	} else {
+		b.prev.next = b.next
+	}
+	if b.next != nil {
+		b.next.prev = b.prev
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lfu
+
+import (
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestLfu(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewLFU(config)
+	cache.Size = int32(2)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+
+	// England is read twice, Ireland once.
+	// England: 3, Ireland: 2
+	cache.Get(request.NewRequestFromValues("England", "", -1))
+	cache.Get(request.NewRequestFromValues("England", "", -1))
+	cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+
+	cache.Put(request.NewRequestFromValues("America", "Washington", -1)) // Ireland evicted here
+
+	v1 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+
+	v2 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v2.Gobj.Value, "")
+
+	// America has the lowest frequency so it is evicted
+	// even though it is the most recently inserted key.
+	cache.Put(request.NewRequestFromValues("France", "Paris", -1))
+
+	v3 := cache.Get(request.NewRequestFromValues("America", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v3.Message, "")
+}
+
+func TestLfuAging(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewLFU(config)
+	cache.Size = int32(2)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+
+	// Read England until the frequencies are aged.
+	for i := 0; i < int(cache.Size)*agingFactor; i++ {
+		cache.Get(request.NewRequestFromValues("England", "", -1))
+	}
+	utils.AssertEqual(t, cache.Accesses, int64(0), "")
+	utils.AssertEqual(t, cache.Frequencies["England"], int64(10), "")
+	utils.AssertEqual(t, cache.Frequencies["Ireland"], int64(1), "")
+
+	// The frequency buckets must still be consistent after aging.
+	cache.Put(request.NewRequestFromValues("America", "Washington", -1)) // Ireland evicted here
+	v1 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+
+	v2 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v2.Gobj.Value, "")
+	utils.AssertEqual(t, cache.Frequencies["England"], int64(11), "")
+}
+
+func TestLfuRebuild(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewLFU(config)
+	cache.Size = int32(2)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+	cache.Get(request.NewRequestFromValues("England", "", -1))
+
+	restored := &LFUCache{
+		Size:        cache.Size,
+		Frequencies: cache.Frequencies,
+	}
+	restored.Hashtable = make(map[string]*lru.Node)
+	for k, v := range cache.Hashtable {
+		restored.Hashtable[k] = &lru.Node{Key: v.Key, Value: v.Value, TTL: v.TTL, CreatedAt: v.CreatedAt}
+	}
+	restored.Rebuild()
+
+	utils.AssertEqual(t, restored.Count, int32(2), "")
+	utils.AssertEqual(t, restored.Full, true, "")
+
+	restored.Put(request.NewRequestFromValues("America", "Washington", -1)) // Ireland evicted here
+	v1 := restored.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+}
@@ -221,4 +221,83 @@ func deleteFromCacheParallel(b *testing.B) {
 			i = i + 1
 		}
 	})
-}
\ No newline at end of file
+}
+
+// shardCounts are the segment counts compared by the sharded
+// benchmarks. Run them with -cpu 1,4,16 to see how each scales.
+var shardCounts = []int32{1, 4, 16, 64}
+
+func newShardedCache(shards int32, size int) *ShardedLRUCache {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.ShardCount = shards
+	config.KeyspaceSize = int32(size)
+	return NewShardedLRU(config)
+}
+
+func BenchmarkShardedPutToCacheParallel(b *testing.B) {
+	for _, shards := range shardCounts {
+		b.Run(fmt.Sprintf("shards-%d", shards), func(b *testing.B) {
+			cache := newShardedCache(shards, b.N)
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				id := rand.Int()
+				counter := 0
+				for pb.Next() {
+					cache.Put(request.NewRequestFromValues(fmt.Sprintf("key-%d-%d", id, counter), fmt.Sprintf("value-%d-%d", counter, id), -1))
+					counter = counter + 1
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkShardedGetFromCacheParallel(b *testing.B) {
+	const keys = 65536
+
+	for _, shards := range shardCounts {
+		b.Run(fmt.Sprintf("shards-%d", shards), func(b *testing.B) {
+			cache := newShardedCache(shards, keys)
+			for i := 0; i < keys; i++ {
+				cache.Put(request.NewRequestFromValues(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i), -1))
+			}
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Int()
+				for pb.Next() {
+					cache.Get(request.NewRequestFromValues(fmt.Sprintf("key-%d", i%keys), "", -1))
+					i = i + 1
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkShardedMixedParallel runs a read heavy workload of
+// nine gets to every put over a cache that is kept full.
+func BenchmarkShardedMixedParallel(b *testing.B) {
+	const keys = 65536
+
+	for _, shards := range shardCounts {
+		b.Run(fmt.Sprintf("shards-%d", shards), func(b *testing.B) {
+			cache := newShardedCache(shards, keys/2)
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Int()
+				for pb.Next() {
+					key := fmt.Sprintf("key-%d", i%keys)
+					if i%10 == 0 {
+						cache.Put(request.NewRequestFromValues(key, "value", -1))
+					} else {
+						cache.Get(request.NewRequestFromValues(key, "", -1))
+					}
+					i = i + 1
+				}
+			})
+		})
+	}
+}
@@ -35,6 +35,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
 )
 
 type Node struct {
@@ -51,6 +53,18 @@ type Node struct {
 	// into the cache.
 	CreatedAt int64
 
+	// Bytes is the estimated number of bytes used by
+	// the key-value pair.
+	Bytes     int64
+
+	// Version identifies the write that stored the key-value
+	// pair, so an expiration is only applied to the version
+	// of the key-value pair it was decided for.
+	Version   uint64
+
+	// Flags are the memcached client flags stored with the value
+	Flags     uint32
+
 	// Prev points to the previous node in the doubly
 	// linked list. Omit this from snapshot serialization.
 	Prev      *Node `json:"-"`
@@ -63,6 +77,61 @@ type Node struct {
 	Mux       sync.Mutex
 }
 
+// Expired reports if the key-value pair has outlived its
+// TTL at the given unix time. A TTL of -1 never expires.
+func (node *Node) Expired(now int64) bool {
+	return node.TTL != -1 && node.CreatedAt+node.TTL < now
+}
+
+// Entry is a point-in-time copy of a key-value pair, so the
+// contents of a cache can be serialized without holding its lock.
+type Entry struct {
+	Key       string
+	Value     interface{}
+	TTL       int64
+	CreatedAt int64
+	Version   uint64
+	Flags     uint32
+}
+
+// Expired reports if the key-value pair had expired by now,
+// a time in unix seconds.
+func (entry Entry) Expired(now int64) bool {
+	return entry.TTL != -1 && entry.CreatedAt+entry.TTL < now
+}
+
+// Entry returns a copy of the key-value pair held by the node.
+func (node *Node) Entry() Entry {
+	return Entry{
+		Key:       node.Key,
+		Value:     node.Value,
+		TTL:       node.TTL,
+		CreatedAt: node.CreatedAt,
+		Version:   node.Version,
+		Flags:     node.Flags,
+	}
+}
+
+// Stamp records the time, version and flags of the write that
+// stored the key-value pair. Writes replicated through Raft carry the
+// time the leader proposed them and the index of their log entry,
+// so every replica stamps a key-value pair the same way.
+func (node *Node) Stamp(args request.CacheRequest) {
+	node.CreatedAt = RequestTime(args)
+	node.Version = args.Version
+	node.Flags = args.Gobj.Flags
+}
+
+// RequestTime returns the time of a request in unix seconds.
+// Replicated requests carry the time the leader proposed them,
+// other requests are timed when they are handled.
+func RequestTime(args request.CacheRequest) int64 {
+	if args.Timestamp != 0 {
+		return args.Timestamp
+	}
+	return time.Now().Unix()
+}
+
 type List struct {
 	// Head is the head node. It is a special case node.
 	// It does not get populated and is a reference node 
@@ -129,6 +198,7 @@ func Insert(ll *List, key string, value interface{}, ttl int64) (*Node, error) {
 		Value:     value,
 		TTL:       ttl,
 		CreatedAt: time.Now().Unix(),
+		Bytes:     EstimateSize(key, value),
 		Prev:      nil,
 		Next:      nil,
 	}
@@ -146,6 +216,24 @@ func Insert(ll *List, key string, value interface{}, ttl int64) (*Node, error) {
 	return newNode, nil
 }
 
+// InsertNode links an existing node in at the head of the
+// doubly linked list. Unlike Insert, the node keeps its
+// original CreatedAt timestamp, which lets policies move
+// key-value pairs between lists without resetting their TTL.
+func InsertNode(ll *List, node *Node) (*Node, error) {
+	ll.Mux.Lock()
+	defer ll.Mux.Unlock()
+
+	node.Prev = ll.Head
+	node.Next = ll.Head.Next
+	ll.Head.Next = node
+	node.Next.Prev = node
+
+	atomic.AddInt32(&ll.Size, 1)
+
+	return node, nil
+}
+
 // RemoveLast removes the least recently used item in the list.
 func RemoveLast(ll *List) (*Node, error) {
 	// Lock access
@@ -167,6 +255,25 @@ func RemoveLast(ll *List) (*Node, error) {
 	}
 }
 
+// RemoveFirst removes the most recently used item in the list.
+func RemoveFirst(ll *List) (*Node, error) {
+	ll.Mux.Lock()
+	defer ll.Mux.Unlock()
+
+	if ll.Size == 0 {
+		return nil, errors.New("List is empty")
+	}
+
+	nodeToRemove := ll.Head.Next
+
+	ll.Head.Next = nodeToRemove.Next
+	nodeToRemove.Next.Prev = ll.Head
+
+	atomic.AddInt32(&ll.Size, -1)
+
+	return nodeToRemove, nil
+}
+
 // RemoveNode removes a specific node from the list.
 func RemoveNode(ll *List, node *Node) (*Node, error) {
 	ll.Mux.Lock()
@@ -194,6 +301,18 @@ func RemoveNode(ll *List, node *Node) (*Node, error) {
 	return node, nil
 }
 
+// AppendEntries appends copies of the key-value pairs in the
+// list to entries, from the tail of the list to its head.
+func AppendEntries(entries []Entry, ll *List) []Entry {
+	ll.Mux.Lock()
+	defer ll.Mux.Unlock()
+
+	for node := ll.Tail.Prev; node != nil && node != ll.Head; node = node.Prev {
+		entries = append(entries, node.Entry())
+	}
+	return entries
+}
+
 // Returns the last node in the list
 func GetLastNode(ll *List) (*Node, error) {
 	ll.Mux.Lock()
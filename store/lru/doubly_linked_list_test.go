@@ -61,4 +61,12 @@ func TestListOperations(t *testing.T) {
 	utils.AssertEqual(t, n.TTL, int64(-1), "")
 
 	Insert(dll, n1.Key, n1.Value, -1)
+
+	n, _ = RemoveFirst(dll)
+	utils.AssertEqual(t, n.Key, "Germany", "")
+	utils.AssertEqual(t, dll.Head.Next.Key, "Belgium", "")
+
+	n, _ = RemoveLast(dll)
+	utils.AssertEqual(t, n.Key, "Ireland", "")
+	utils.AssertEqual(t, dll.Size, int32(3), "")
 }
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package lru
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// Keyed holds what a cache does by key alone, whatever lists its
+// policy keeps the key-value pairs in, so each policy only keeps
+// its eviction logic. The caches of the policies other than LRU
+// embed it, and set it up with InitKeyed.
+//
+// Reads hide expired key-value pairs rather than remove them. They
+// are left for the replicated expire command, so every replica
+// removes the same pairs, while a write that finds one removes it.
+type Keyed struct {
+	// Hashtable maps keys to the nodes of the cache
+	Hashtable map[string]*Node
+
+	// Mux is a mutex lock
+	Mux sync.Mutex
+
+	// onExpire is called with the key of each key-value
+	// pair removed by a write because it expired.
+	onExpire func(key string)
+
+	// unlink removes a node from the lists and the hashtable
+	// of the cache, and replayOrder returns the lists in the
+	// order their key-value pairs should be replayed to
+	// rebuild the cache. Both are called with the lock held.
+	unlink      func(node *Node)
+	replayOrder func() []*List
+}
+
+// InitKeyed sets the functions the policy of the cache unlinks
+// nodes and orders its lists with, and makes the hashtable if
+// the cache does not have one yet.
+func (keyed *Keyed) InitKeyed(unlink func(node *Node), replayOrder func() []*List) {
+	if keyed.Hashtable == nil {
+		keyed.Hashtable = make(map[string]*Node)
+	}
+	keyed.unlink = unlink
+	keyed.replayOrder = replayOrder
+}
+
+// Live returns the node for a key, unless it has expired.
+// The caller must hold the cache lock.
+func (keyed *Keyed) Live(key string) (*Node, bool) {
+	node, ok := keyed.Hashtable[key]
+	if !ok || node.Expired(time.Now().Unix()) {
+		return nil, false
+	}
+	return node, true
+}
+
+// Expire removes a key-value pair if it has expired by the time of
+// the write that found it, reporting whether it did.
+// The caller must hold the cache lock.
+func (keyed *Keyed) Expire(node *Node, now int64) bool {
+	if !node.Expired(now) {
+		return false
+	}
+	keyed.unlink(node)
+	if keyed.onExpire != nil {
+		keyed.onExpire(node.Key)
+	}
+	return true
+}
+
+// Delete removes a key/value pair from the cache
+// Returns NOT_FOUND if the key does not exist.
+func (keyed *Keyed) Delete(args request.CacheRequest) response.CacheResponse {
+	return keyed.DeleteByKey(args.Gobj.Key)
+}
+
+// DeleteByKey functions the same as Delete, however it is used in various locations
+// to reduce the cost of allocating request objects for internal deletion mechanisms
+// e.g. the cache crawlers.
+func (keyed *Keyed) DeleteByKey(key string) response.CacheResponse {
+	keyed.Mux.Lock()
+	defer keyed.Mux.Unlock()
+
+	node, ok := keyed.Hashtable[key]
+	if !ok {
+		return response.NewResponseFromMessage(NOT_FOUND, 0)
+	}
+	keyed.unlink(node)
+
+	return response.NewResponseFromMessage(REMOVED, 1)
+}
+
+// ExpireByKey removes an expired key-value pair if it is still
+// at the version it was marked expired at.
+func (keyed *Keyed) ExpireByKey(key string, version uint64) response.CacheResponse {
+	keyed.Mux.Lock()
+	defer keyed.Mux.Unlock()
+
+	node, ok := keyed.Hashtable[key]
+	if !ok || node.Version != version {
+		return response.NewResponseFromMessage(NOT_FOUND, 0)
+	}
+	keyed.unlink(node)
+
+	return response.NewResponseFromMessage(REMOVED, 1)
+}
+
+// Contains reports if the cache holds a key/value pair for
+// the key, without counting as a use of it.
+func (keyed *Keyed) Contains(key string) bool {
+	keyed.Mux.Lock()
+	defer keyed.Mux.Unlock()
+
+	_, ok := keyed.Hashtable[key]
+	return ok
+}
+
+// Peek returns a copy of the key/value pair for the key, if the
+// cache holds one, without counting as a use of it.
+func (keyed *Keyed) Peek(key string) (Entry, bool) {
+	keyed.Mux.Lock()
+	defer keyed.Mux.Unlock()
+
+	node, ok := keyed.Hashtable[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return node.Entry(), true
+}
+
+// Entries returns a copy of the key-value pairs in the cache in
+// the order they should be replayed to rebuild it.
+func (keyed *Keyed) Entries() []Entry {
+	keyed.Mux.Lock()
+	defer keyed.Mux.Unlock()
+
+	entries := make([]Entry, 0, len(keyed.Hashtable))
+	for _, ll := range keyed.replayOrder() {
+		entries = AppendEntries(entries, ll)
+	}
+	return entries
+}
+
+// SetExpiryHook sets a function called with the key of each
+// key-value pair removed by a write because it expired.
+func (keyed *Keyed) SetExpiryHook(hook func(key string)) {
+	keyed.Mux.Lock()
+	defer keyed.Mux.Unlock()
+	keyed.onExpire = hook
+}
+
+// GetHashtableReference is for internal use by crawlers and AOF
+func (keyed *Keyed) GetHashtableReference() *map[string]*Node {
+	return &keyed.Hashtable
+}
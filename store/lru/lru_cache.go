@@ -34,6 +34,7 @@ import (
 	"log"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ghostdb/ghostdb-cache-node/config"
 	"github.com/ghostdb/ghostdb-cache-node/store/request"
@@ -63,11 +64,29 @@ type LRUCache struct {
 	// Full tracks if Count is equal to Size
 	Full      bool
 
+	// Bytes records the estimated number of bytes used
+	// by the key-value pairs currently in the cache.
+	Bytes     int64
+
+	// MaxBytes is the maximum estimated number of bytes
+	// the key-value pairs in the cache may use. If 0 the
+	// cache is only bounded by Size.
+	MaxBytes  int64
+
 	// DLL is a doubly linked list containing all key-value pairs
 	DLL       *List `json:"omitempty"`
 
 	// Hashtable maps to nodes in the doubly linked list
 	Hashtable map[string]*Node
+
+	// onExpire is called with the key of each key-value
+	// pair removed by a write because it expired.
+	onExpire  func(key string)
+
+	// shared, if set, also records the bytes used by the
+	// key-value pairs, for a memory bound shared with other
+	// caches, such as the segments of a sharded cache.
+	shared    *int64
 	
 	// Mux is a mutex lock
 	Mux       sync.Mutex
@@ -79,6 +98,7 @@ func NewLRU(config config.Configuration) *LRUCache {
 		Size:      config.KeyspaceSize,
 		Count:     int32(0),
 		Full:      false,
+		MaxBytes:  config.MaxMemoryBytes,
 		DLL:       InitList(),
 		Hashtable: newHashtable(),
 	}
@@ -88,7 +108,9 @@ func newHashtable() map[string]*Node {
 	return make(map[string]*Node)
 }
 
-// Get will fetch a key/value pair from the cache
+// Get will fetch a key/value pair from the cache. An expired
+// key/value pair is not returned, but is left for the replicated
+// expire command to remove, so every replica removes the same pairs.
 func (cache *LRUCache) Get(args request.CacheRequest) response.CacheResponse {
 	// Fix in the FUTURE
 	// to use a method that validates the 
@@ -96,167 +118,97 @@ func (cache *LRUCache) Get(args request.CacheRequest) response.CacheResponse {
 	key := args.Gobj.Key
 
 	cache.Mux.Lock()
-	nodeToGet := cache.Hashtable[key]
-	cache.Mux.Unlock()
+	defer cache.Mux.Unlock()
 
-	if nodeToGet == nil {
+	nodeToGet, ok := cache.Hashtable[key]
+	if !ok || nodeToGet.Expired(time.Now().Unix()) {
 		return response.NewCacheMissResponse()
 	}
 
-	cache.Mux.Lock()
-	n, _ := RemoveNode(cache.DLL, nodeToGet)
-	cache.Mux.Unlock()
-
-	cache.Mux.Lock()
-	node, _ := Insert(cache.DLL, n.Key, n.Value, n.TTL)
-	cache.Mux.Unlock()
+	// Move the node to the head of the list, keeping its
+	// CreatedAt so a read does not extend its TTL.
+	RemoveNode(cache.DLL, nodeToGet)
+	InsertNode(cache.DLL, nodeToGet)
 
-	cache.Mux.Lock()
-	cache.Hashtable[key] = node
-	cache.Mux.Unlock()
-
-	return response.NewResponseFromValue(node.Value)
+	return response.NewResponseFromValue(nodeToGet.Value)
 }
 
 // Put will add a key/value pair to the cache, possibly
 // overwriting an existing key/value pair. Put will evict
-// a key/value pair if the cache is full.
+// key/value pairs if the cache is full, either by key count
+// or by estimated bytes. A key/value pair larger than the
+// memory bound of the cache is not stored, and as with memcached
+// the value it would have replaced is removed.
 func (cache *LRUCache) Put(args request.CacheRequest) response.CacheResponse {
 	key := args.Gobj.Key
 	value := args.Gobj.Value
 	ttl := args.Gobj.TTL
 
-	if !cache.Full {
-		inCache := keyInCache(cache, key)
-
-		newNode, _ := Insert(cache.DLL, key, value, ttl)
-
-		insertIntoHashtable(cache, key, newNode)
+	size := EstimateSize(key, value)
 
-		if !inCache {
-			cache.Mux.Lock()
-			atomic.AddInt32(&cache.Count, 1)
-			cache.Mux.Unlock()
-		}
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
 
-		if cache.Count == cache.Size {
-			cache.Full = true
-		}
+	// SPECIAL CASE: Replace the existing key/value pair
+	if node, ok := cache.Hashtable[key]; ok {
+		removeFromCache(cache, node)
+	}
 
-	} else {
-		// SPECIAL CASE: Just update the value
-		inCache := keyInCache(cache, key)
-		if inCache {
-			// Get the value node
-			node, _ := cache.Hashtable[key]
-	
-			// Update the value
-			node.Value = value
-			return response.NewResponseFromMessage(STORED, 1)
-		} else {
-			n, _ := RemoveLast(cache.DLL)
+	if !cache.fits(size) {
+		return response.NewResponseFromMessage(NOT_STORED, 0)
+	}
 
-			deleteFromHashtable(cache, n.Key)
+	evict(cache, size)
+	insertIntoCache(cache, key, value, ttl).Stamp(args)
 
-			newNode, _ := Insert(cache.DLL, key, value, ttl)
-			insertIntoHashtable(cache, key, newNode)
-		}
-	}
 	return response.NewResponseFromMessage(STORED, 1)
 }
 
-func deleteFromHashtable(cache *LRUCache, key string) {
-	cache.Mux.Lock()
-	defer cache.Mux.Unlock()
-	delete(cache.Hashtable, key)
-}
-
 // Add will add a key/value pair to the cache if the key
-// does not exist already. It will not evict a key/value pair
-// from the cache. If the cache is full, the key/value pair does
-// not get added.
+// does not exist already. If the cache is full, key/value
+// pairs are evicted to make room for the new one.
 func (cache *LRUCache) Add(args request.CacheRequest) response.CacheResponse {
 	key := args.Gobj.Key
 	value := args.Gobj.Value
 	ttl := args.Gobj.TTL
 
-	cache.Mux.Lock()
-	_, ok := cache.Hashtable[key]
-	cache.Mux.Unlock()
-	if ok {
+	size := EstimateSize(key, value)
+	if !cache.fits(size) {
 		return response.NewResponseFromMessage(NOT_STORED, 0)
 	}
-	if !cache.Full {
-		inCache := keyInCache(cache, key)
-
-		newNode, _ := Insert(cache.DLL, key, value, ttl)
 
-		insertIntoHashtable(cache, key, newNode)
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
 
-		if !inCache {
-			atomic.AddInt32(&cache.Count, 1)
-		}
+	if node, ok := cache.Hashtable[key]; ok && !expire(cache, node, RequestTime(args)) {
+		return response.NewResponseFromMessage(NOT_STORED, 0)
+	}
 
-		if cache.Count == cache.Size {
-			cache.Full = true
-		}
-	} else {
-		n, _ := RemoveLast(cache.DLL)
-		deleteFromHashtable(cache, n.Key)
+	evict(cache, size)
+	insertIntoCache(cache, key, value, ttl).Stamp(args)
 
-		newNode, _ := Insert(cache.DLL, key, value, ttl)
-		insertIntoHashtable(cache, key, newNode)
-	}
 	return response.NewResponseFromMessage(STORED, 1)
 }
 
 // Delete removes a key/value pair from the cache
 // Returns NOT_FOUND if the key does not exist.
 func (cache *LRUCache) Delete(args request.CacheRequest) response.CacheResponse {
-
-	key := args.Gobj.Key
-
-	cache.Mux.Lock()
-	_, ok := cache.Hashtable[key]
-	cache.Mux.Unlock()
-	if ok {
-		cache.Mux.Lock()
-		nodeToRemove := cache.Hashtable[key]
-		cache.Mux.Unlock()
-
-		if nodeToRemove == nil {
-			return response.NewResponseFromMessage(NOT_FOUND, 0)
-		}
-
-		deleteFromHashtable(cache, nodeToRemove.Key)
-		_, err := RemoveNode(cache.DLL, nodeToRemove)
-
-		if err != nil {
-			log.Println("failed to remove key-value pair")
-		}
-
-		cache.Mux.Lock()
-		atomic.AddInt32(&cache.Count, -1)
-		cache.Mux.Unlock()
-
-		cache.Full = false
-		return response.NewResponseFromMessage(REMOVED, 1)
-	}
-	return response.NewResponseFromMessage(NOT_FOUND, 0)
+	return cache.DeleteByKey(args.Gobj.Key)
 }
 
 // Flush removes all key/value pairs from the cache even if they have not expired
 func (cache *LRUCache) Flush(args request.CacheRequest) response.CacheResponse {
-	log.Println("ARGS", args)
-	for k := range cache.Hashtable {
-		n, _ := RemoveLast(cache.DLL)
-		if n == nil {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	for {
+		n, err := RemoveLast(cache.DLL)
+		if err != nil {
 			break
 		}
-		deleteFromHashtable(cache, k)
-		cache.Mux.Lock()
+		delete(cache.Hashtable, n.Key)
 		atomic.AddInt32(&cache.Count, -1)
-		cache.Mux.Unlock()
+		account(cache, -n.Bytes)
 	}
 
 	cache.Full = false
@@ -269,7 +221,23 @@ func (cache *LRUCache) Flush(args request.CacheRequest) response.CacheResponse {
 
 // CountKeys return the number of keys in the cache
 func (cache *LRUCache) CountKeys(args request.CacheRequest) response.CacheResponse {
-	return response.NewResponseFromValue(cache.Count)
+	return response.NewResponseFromValue(atomic.LoadInt32(&cache.Count))
+}
+
+// CountBytes returns the estimated number of bytes used by
+// the key/value pairs in the cache.
+func (cache *LRUCache) CountBytes() int64 {
+	return atomic.LoadInt64(&cache.Bytes)
+}
+
+// PolicyMetrics reports the memory used by the cache
+// against its memory bound.
+func (cache *LRUCache) PolicyMetrics() map[string]int64 {
+	return map[string]int64{
+		"LruKeys":      int64(atomic.LoadInt32(&cache.Count)),
+		"LruBytesUsed": atomic.LoadInt64(&cache.Bytes),
+		"LruMaxBytes":  cache.MaxBytes,
+	}
 }
 
 // DeleteByKey functions the same as Delete, however it is used in various locations
@@ -277,51 +245,161 @@ func (cache *LRUCache) CountKeys(args request.CacheRequest) response.CacheRespon
 // e.g. the cache crawlers.
 func (cache *LRUCache) DeleteByKey(key string) response.CacheResponse {
 	cache.Mux.Lock()
-	_, ok := cache.Hashtable[key]
-	cache.Mux.Unlock()
-	if ok {
-		cache.Mux.Lock()
-		nodeToRemove := cache.Hashtable[key]
-		cache.Mux.Unlock()
-
-		if nodeToRemove == nil {
-			return response.NewResponseFromMessage(NOT_FOUND, 0)
-		}
+	defer cache.Mux.Unlock()
 
-		deleteFromHashtable(cache, nodeToRemove.Key)
-		_, err := RemoveNode(cache.DLL, nodeToRemove)
+	nodeToRemove, ok := cache.Hashtable[key]
+	if !ok {
+		return response.NewResponseFromMessage(NOT_FOUND, 0)
+	}
 
-		if err != nil {
-			log.Println("failed to remove key-value pair")
-		}
+	removeFromCache(cache, nodeToRemove)
 
-		cache.Mux.Lock()
-		atomic.AddInt32(&cache.Count, -1)
-		cache.Mux.Unlock()
+	return response.NewResponseFromMessage(REMOVED, 1)
+}
 
-		cache.Full = false
+// ExpireByKey removes an expired key/value pair if it is still
+// at the version it was marked expired at. A key/value pair written
+// again since it was marked is left in the cache.
+func (cache *LRUCache) ExpireByKey(key string, version uint64) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
 
-		return response.NewResponseFromMessage(REMOVED, 1)
+	node, ok := cache.Hashtable[key]
+	if !ok || node.Version != version {
+		return response.NewResponseFromMessage(NOT_FOUND, 0)
 	}
-	return response.NewResponseFromMessage(NOT_FOUND, 0)
+
+	removeFromCache(cache, node)
+
+	return response.NewResponseFromMessage(REMOVED, 1)
 }
 
-func (cache *LRUCache) GetHashtableReference() *map[string]*Node {
-	return &cache.Hashtable
+// Contains reports if the cache holds a key/value pair for
+// the key, without counting as a use of it.
+func (cache *LRUCache) Contains(key string) bool {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	_, ok := cache.Hashtable[key]
+	return ok
+}
+
+// Peek returns a copy of the key/value pair for the key, if the
+// cache holds one, without counting as a use of it.
+func (cache *LRUCache) Peek(key string) (Entry, bool) {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	node, ok := cache.Hashtable[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return node.Entry(), true
 }
 
-func insertIntoHashtable(cache *LRUCache, key string, node *Node) {
+// Entries returns a copy of the key/value pairs in the cache,
+// least recently used first.
+func (cache *LRUCache) Entries() []Entry {
 	cache.Mux.Lock()
 	defer cache.Mux.Unlock()
-	cache.Hashtable[key] = node
+	return AppendEntries(make([]Entry, 0, cache.Count), cache.DLL)
 }
 
-func keyInCache(cache *LRUCache, key string) (bool) {
+// SetExpiryHook sets a function called with the key of each
+// key/value pair removed by a write because it expired.
+func (cache *LRUCache) SetExpiryHook(hook func(key string)) {
 	cache.Mux.Lock()
 	defer cache.Mux.Unlock()
-	_, ok := cache.Hashtable[key] 
-	if ok {
-		return true
+	cache.onExpire = hook
+}
+
+func (cache *LRUCache) GetHashtableReference() *map[string]*Node {
+	return &cache.Hashtable
+}
+
+// fits reports if a key/value pair of the given size can
+// be stored without exceeding the memory bound on its own.
+func (cache *LRUCache) fits(size int64) bool {
+	return cache.MaxBytes <= 0 || size <= cache.MaxBytes
+}
+
+// evict removes least recently used key/value pairs until a
+// key/value pair of the given size can be inserted without
+// exceeding either the key count or the memory bound.
+// The caller must hold the cache lock.
+func evict(cache *LRUCache, size int64) {
+	for cache.Count >= cache.Size || (cache.MaxBytes > 0 && cache.Bytes+size > cache.MaxBytes) {
+		if !evictLast(cache) {
+			return
+		}
+	}
+}
+
+// evictLast removes the least recently used key/value pair,
+// reporting whether the cache held one.
+// The caller must hold the cache lock.
+func evictLast(cache *LRUCache) bool {
+	n, err := RemoveLast(cache.DLL)
+	if err != nil {
+		return false
+	}
+
+	delete(cache.Hashtable, n.Key)
+	atomic.AddInt32(&cache.Count, -1)
+	account(cache, -n.Bytes)
+	return true
+}
+
+// account adds to the estimated number of bytes used by
+// the key/value pairs in the cache, and in the caches it
+// shares its memory bound with.
+func account(cache *LRUCache, bytes int64) {
+	atomic.AddInt64(&cache.Bytes, bytes)
+	if cache.shared != nil {
+		atomic.AddInt64(cache.shared, bytes)
+	}
+}
+
+// insertIntoCache inserts a new key/value pair as the most
+// recently used and accounts for its key and bytes.
+// The caller must hold the cache lock.
+func insertIntoCache(cache *LRUCache, key string, value interface{}, ttl int64) *Node {
+	newNode, _ := Insert(cache.DLL, key, value, ttl)
+	cache.Hashtable[key] = newNode
+
+	atomic.AddInt32(&cache.Count, 1)
+	account(cache, newNode.Bytes)
+	cache.Full = cache.Count >= cache.Size
+
+	return newNode
+}
+
+// removeFromCache removes a key/value pair from the cache
+// and releases its key and bytes.
+// The caller must hold the cache lock.
+func removeFromCache(cache *LRUCache, node *Node) {
+	delete(cache.Hashtable, node.Key)
+	_, err := RemoveNode(cache.DLL, node)
+
+	if err != nil {
+		log.Println("failed to remove key-value pair")
 	}
-	return false
-}
\ No newline at end of file
+
+	atomic.AddInt32(&cache.Count, -1)
+	account(cache, -node.Bytes)
+	cache.Full = false
+}
+
+// expire removes a key/value pair if it has expired by the time of
+// the write that found it, reporting whether it did.
+// The caller must hold the cache lock.
+func expire(cache *LRUCache, node *Node, now int64) bool {
+	if !node.Expired(now) {
+		return false
+	}
+	removeFromCache(cache, node)
+	if cache.onExpire != nil {
+		cache.onExpire(node.Key)
+	}
+	return true
+}
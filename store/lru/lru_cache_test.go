@@ -105,3 +105,94 @@ func TestLru(t *testing.T) {
 	message = cache.CountKeys(request.NewRequestFromValues("Key1", "", -1))
 	utils.AssertEqual(t, message.Gobj.Value.(int32), int32(0), "")
 }
+
+func TestLruMemoryBound(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	entrySize := EstimateSize("England", "London")
+	config.MaxMemoryBytes = 2 * entrySize
+	cache := NewLRU(config)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+	utils.AssertEqual(t, cache.CountBytes(), 2*entrySize, "")
+
+	// The memory bound is hit before the keyspace size
+	cache.Put(request.NewRequestFromValues("Germany", "Berlin", -1)) // England:London evicted here
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, CACHE_MISS, v1.Message, "")
+	utils.AssertEqual(t, cache.Count, int32(2), "")
+	utils.AssertEqual(t, cache.CountBytes() <= config.MaxMemoryBytes, true, "")
+
+	// Overwriting a key replaces its bytes rather than adding to them
+	cache.Put(request.NewRequestFromValues("Ireland", "Cork", -1))
+	utils.AssertEqual(t, cache.Count, int32(2), "")
+	utils.AssertEqual(t, cache.CountBytes(), entrySize+EstimateSize("Ireland", "Cork"), "")
+
+	// A value larger than the bound on its own is not stored
+	// and nothing is evicted to make room for it.
+	large := make([]byte, config.MaxMemoryBytes)
+	message := cache.Put(request.NewRequestFromValues("Spain", string(large), -1))
+	utils.AssertEqual(t, NOT_STORED, message.Message, "")
+	utils.AssertEqual(t, cache.Count, int32(2), "")
+
+	// A key overwritten with such a value is removed, so its
+	// old value is not served after the write was refused.
+	message = cache.Put(request.NewRequestFromValues("Ireland", string(large), -1))
+	utils.AssertEqual(t, NOT_STORED, message.Message, "")
+	v1 = cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, CACHE_MISS, v1.Message, "")
+	utils.AssertEqual(t, cache.Count, int32(1), "")
+
+	message = cache.Delete(request.NewRequestFromValues("Germany", "", -1))
+	utils.AssertEqual(t, REMOVED, message.Message, "")
+	utils.AssertEqual(t, cache.CountBytes(), int64(0), "")
+
+	cache.Flush(request.NewRequestFromValues("", "", -1))
+	utils.AssertEqual(t, cache.CountBytes(), int64(0), "")
+}
+
+func TestEstimateSize(t *testing.T) {
+	utils.AssertEqual(t, EstimateSize("key", "value"), int64(nodeOverhead+8), "")
+	utils.AssertEqual(t, EstimateSize("key", nil), int64(nodeOverhead+3), "")
+	utils.AssertEqual(t, EstimateSize("key", float64(1)), int64(nodeOverhead+11), "")
+
+	small := EstimateSize("key", map[string]interface{}{"a": "b"})
+	large := EstimateSize("key", map[string]interface{}{"a": "bbbbbbbbbb"})
+	utils.AssertEqual(t, large-small, int64(9), "")
+}
+
+func TestLruLazyExpiration(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewLRU(config)
+
+	expired := []string{}
+	cache.SetExpiryHook(func(key string) {
+		expired = append(expired, key)
+	})
+
+	cache.Put(request.NewRequestFromValues("England", "London", 60))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", 60))
+	cache.Hashtable["England"].CreatedAt -= 120
+	cache.Hashtable["Ireland"].CreatedAt -= 120
+
+	// Expired key-value pairs are hidden from reads, but only
+	// removed by a write, so reads leave every replica as it is
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, CACHE_MISS, v1.Message, "")
+	utils.AssertEqual(t, cache.Count, int32(2), "")
+
+	// and when checked for existence by Add
+	message := cache.Add(request.NewRequestFromValues("Ireland", "Cork", 60))
+	utils.AssertEqual(t, STORED, message.Message, "")
+	v2 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, "Cork", v2.Gobj.Value, "")
+
+	utils.AssertEqual(t, len(expired), 1, "")
+	utils.AssertEqual(t, expired[0], "Ireland", "")
+	utils.AssertEqual(t, cache.Count, int32(2), "")
+
+	// Reads do not extend the TTL of a key-value pair
+	createdAt := cache.Hashtable["Ireland"].CreatedAt
+	cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, cache.Hashtable["Ireland"].CreatedAt, createdAt, "")
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package lru
+
+import (
+	"encoding/json"
+)
+
+// nodeOverhead approximates the memory used by a node and its
+// hashtable entry, not counting the key and value themselves.
+const nodeOverhead = 104
+
+// EstimateSize returns the estimated number of bytes a key-value
+// pair occupies in the cache. It is an estimate, not an exact
+// accounting of the Go heap, and is used to bound the memory
+// used by the cache.
+func EstimateSize(key string, value interface{}) int64 {
+	return nodeOverhead + int64(len(key)) + valueSize(value)
+}
+
+// valueSize estimates the size of a value. Values decoded from
+// JSON requests are strings, numbers, booleans, slices and maps,
+// which are handled directly. Anything else falls back to the size
+// of its JSON encoding.
+func valueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case int, int64, uint, uint64, float64, uintptr:
+		return 8
+	case []interface{}:
+		size := int64(24)
+		for _, elem := range v {
+			size += 16 + valueSize(elem)
+		}
+		return size
+	case map[string]interface{}:
+		size := int64(48)
+		for k, elem := range v {
+			size += 32 + int64(len(k)) + valueSize(elem)
+		}
+		return size
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return 0
+		}
+		return int64(len(b))
+	}
+}
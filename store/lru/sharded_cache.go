@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package lru
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// ShardedLRUCache is an LRU cache split into independent
+// segments. Each key is owned by the segment selected by the
+// hash of the key, and each segment has its own lock and list,
+// so operations on keys in different segments do not contend.
+// Recency, and so eviction, is tracked per segment.
+type ShardedLRUCache struct {
+	// Shards are the independent LRU segments of the cache
+	Shards []*LRUCache
+
+	// Bytes records the estimated number of bytes used by
+	// the key-value pairs in every segment.
+	Bytes    int64
+
+	// MaxBytes is the maximum estimated number of bytes the
+	// key-value pairs in all segments together may use. If 0
+	// the cache is only bounded by the keyspace size.
+	MaxBytes int64
+
+	// next is the segment the memory bound evicts from next
+	next     uint32
+}
+
+// NewShardedLRU will initialize a cache of config.ShardCount
+// segments, and no more segments than keys. The keyspace size
+// is divided between the segments, the remainder spread over
+// the first segments. The memory bound is kept for the cache
+// as a whole, so any value within it can be stored.
+func NewShardedLRU(config config.Configuration) *ShardedLRUCache {
+	count := config.ShardCount
+	if count > config.KeyspaceSize {
+		count = config.KeyspaceSize
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	cache := &ShardedLRUCache{
+		Shards:   make([]*LRUCache, count),
+		MaxBytes: config.MaxMemoryBytes,
+	}
+
+	shardConfig := config
+	shardConfig.MaxMemoryBytes = 0
+	for i := range cache.Shards {
+		shardConfig.KeyspaceSize = config.KeyspaceSize / count
+		if int32(i) < config.KeyspaceSize%count {
+			shardConfig.KeyspaceSize++
+		}
+		cache.Shards[i] = NewLRU(shardConfig)
+		cache.Shards[i].shared = &cache.Bytes
+	}
+	return cache
+}
+
+// shardFor returns the segment that owns a key
+func (cache *ShardedLRUCache) shardFor(key string) *LRUCache {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return cache.Shards[h.Sum32()%uint32(len(cache.Shards))]
+}
+
+// Get will fetch a key/value pair from the cache
+func (cache *ShardedLRUCache) Get(args request.CacheRequest) response.CacheResponse {
+	return cache.shardFor(args.Gobj.Key).Get(args)
+}
+
+// Put will add a key/value pair to the cache, possibly
+// overwriting an existing key/value pair. Put will evict
+// key/value pairs from the keys segment if it is full, and
+// from any segment if the cache exceeds its memory bound.
+// A key/value pair larger than the memory bound is not stored,
+// and the value it would have replaced is removed.
+func (cache *ShardedLRUCache) Put(args request.CacheRequest) response.CacheResponse {
+	if !cache.fits(args) {
+		cache.shardFor(args.Gobj.Key).DeleteByKey(args.Gobj.Key)
+		return response.NewResponseFromMessage(NOT_STORED, 0)
+	}
+	res := cache.shardFor(args.Gobj.Key).Put(args)
+	cache.reclaim()
+	return res
+}
+
+// Add will add a key/value pair to the cache if the key
+// does not exist already.
+func (cache *ShardedLRUCache) Add(args request.CacheRequest) response.CacheResponse {
+	if !cache.fits(args) {
+		return response.NewResponseFromMessage(NOT_STORED, 0)
+	}
+	res := cache.shardFor(args.Gobj.Key).Add(args)
+	cache.reclaim()
+	return res
+}
+
+// fits reports if a key/value pair can be stored without
+// exceeding the memory bound of the cache on its own.
+func (cache *ShardedLRUCache) fits(args request.CacheRequest) bool {
+	return cache.MaxBytes <= 0 || EstimateSize(args.Gobj.Key, args.Gobj.Value) <= cache.MaxBytes
+}
+
+// reclaim evicts least recently used key/value pairs, taking
+// the segments in turn, until the cache is within its memory
+// bound. Writes that race each evict until the bound is met.
+func (cache *ShardedLRUCache) reclaim() {
+	if cache.MaxBytes <= 0 {
+		return
+	}
+	empty := 0
+	for atomic.LoadInt64(&cache.Bytes) > cache.MaxBytes && empty < len(cache.Shards) {
+		i := atomic.AddUint32(&cache.next, 1) % uint32(len(cache.Shards))
+		shard := cache.Shards[i]
+		shard.Mux.Lock()
+		evicted := evictLast(shard)
+		if evicted {
+			shard.Full = false
+			empty = 0
+		} else {
+			empty++
+		}
+		shard.Mux.Unlock()
+	}
+}
+
+// Delete removes a key/value pair from the cache
+// Returns NOT_FOUND if the key does not exist.
+func (cache *ShardedLRUCache) Delete(args request.CacheRequest) response.CacheResponse {
+	return cache.shardFor(args.Gobj.Key).Delete(args)
+}
+
+// DeleteByKey functions the same as Delete without
+// allocating a request object.
+func (cache *ShardedLRUCache) DeleteByKey(key string) response.CacheResponse {
+	return cache.shardFor(key).DeleteByKey(key)
+}
+
+// ExpireByKey removes an expired key/value pair if it is
+// still at the version it was marked expired at.
+func (cache *ShardedLRUCache) ExpireByKey(key string, version uint64) response.CacheResponse {
+	return cache.shardFor(key).ExpireByKey(key, version)
+}
+
+// Contains reports if the segment of the key holds it
+func (cache *ShardedLRUCache) Contains(key string) bool {
+	return cache.shardFor(key).Contains(key)
+}
+
+// Peek returns a copy of the key/value pair for the key, if the
+// segment of the key holds one, without counting as a use of it.
+func (cache *ShardedLRUCache) Peek(key string) (Entry, bool) {
+	return cache.shardFor(key).Peek(key)
+}
+
+// Flush removes all key/value pairs from every segment
+func (cache *ShardedLRUCache) Flush(args request.CacheRequest) response.CacheResponse {
+	flushed := true
+	for _, shard := range cache.Shards {
+		if shard.Flush(args).Message != FLUSHED {
+			flushed = false
+		}
+	}
+
+	if flushed {
+		return response.NewResponseFromMessage(FLUSHED, 1)
+	}
+	return response.NewResponseFromMessage(ERR_FLUSH, 0)
+}
+
+// CountKeys return the number of keys in the cache
+func (cache *ShardedLRUCache) CountKeys(args request.CacheRequest) response.CacheResponse {
+	count := int32(0)
+	for _, shard := range cache.Shards {
+		count += shard.CountKeys(args).Gobj.Value.(int32)
+	}
+	return response.NewResponseFromValue(count)
+}
+
+// CountBytes returns the estimated number of bytes used by
+// the key/value pairs in the cache.
+func (cache *ShardedLRUCache) CountBytes() int64 {
+	return atomic.LoadInt64(&cache.Bytes)
+}
+
+// PolicyMetrics reports the memory used by the cache
+// against its memory bound, summed over the segments.
+func (cache *ShardedLRUCache) PolicyMetrics() map[string]int64 {
+	metrics := map[string]int64{
+		"LruShards": int64(len(cache.Shards)),
+	}
+	for _, shard := range cache.Shards {
+		for k, v := range shard.PolicyMetrics() {
+			metrics[k] += v
+		}
+	}
+	metrics["LruMaxBytes"] = cache.MaxBytes
+	return metrics
+}
+
+// Entries returns a copy of the key/value pairs in the cache,
+// segment by segment, each least recently used first.
+func (cache *ShardedLRUCache) Entries() []Entry {
+	entries := []Entry{}
+	for _, shard := range cache.Shards {
+		entries = append(entries, shard.Entries()...)
+	}
+	return entries
+}
+
+// SetExpiryHook sets a function called with the key of each
+// key/value pair removed by a write because it expired.
+func (cache *ShardedLRUCache) SetExpiryHook(hook func(key string)) {
+	for _, shard := range cache.Shards {
+		shard.SetExpiryHook(hook)
+	}
+}
+
+// GetHashtableReference merges the hashtables of the segments.
+// Unlike the other caches the map is a point-in-time copy, taken
+// segment by segment, but its nodes are the nodes in the cache.
+func (cache *ShardedLRUCache) GetHashtableReference() *map[string]*Node {
+	hashtable := make(map[string]*Node)
+	for _, shard := range cache.Shards {
+		shard.Mux.Lock()
+		for k, v := range shard.Hashtable {
+			hashtable[k] = v
+		}
+		shard.Mux.Unlock()
+	}
+	return &hashtable
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package lru
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestShardedLru(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.ShardCount = 4
+	config.KeyspaceSize = 10
+	cache := NewShardedLRU(config)
+
+	utils.AssertEqual(t, len(cache.Shards), 4, "")
+	utils.AssertEqual(t, cache.Shards[0].Size, int32(3), "")
+	utils.AssertEqual(t, cache.Shards[3].Size, int32(2), "")
+
+	// The segments hold no more keys than the keyspace size
+	size := int32(0)
+	for _, shard := range cache.Shards {
+		size += shard.Size
+	}
+	utils.AssertEqual(t, size, int32(10), "")
+
+	message := cache.Put(request.NewRequestFromValues("England", "London", -1))
+	utils.AssertEqual(t, STORED, message.Message, "")
+
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v1.Gobj.Value, "")
+
+	// A key always maps to the same segment
+	utils.AssertEqual(t, cache.shardFor("England") == cache.shardFor("England"), true, "")
+	_, ok := cache.shardFor("England").Hashtable["England"]
+	utils.AssertEqual(t, ok, true, "")
+
+	message = cache.Add(request.NewRequestFromValues("England", "London", -1))
+	utils.AssertEqual(t, NOT_STORED, message.Message, "")
+
+	message = cache.Add(request.NewRequestFromValues("Ireland", "Dublin", -1))
+	utils.AssertEqual(t, STORED, message.Message, "")
+
+	message = cache.CountKeys(request.NewEmptyRequest())
+	utils.AssertEqual(t, message.Gobj.Value, int32(2), "")
+	utils.AssertEqual(t, len(*cache.GetHashtableReference()), 2, "")
+
+	message = cache.Delete(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, REMOVED, message.Message, "")
+
+	message = cache.DeleteByKey("England")
+	utils.AssertEqual(t, NOT_FOUND, message.Message, "")
+
+	message = cache.Flush(request.NewEmptyRequest())
+	utils.AssertEqual(t, FLUSHED, message.Message, "")
+
+	message = cache.CountKeys(request.NewEmptyRequest())
+	utils.AssertEqual(t, message.Gobj.Value, int32(0), "")
+	utils.AssertEqual(t, cache.CountBytes(), int64(0), "")
+}
+
+func TestShardedLruConcurrentAccess(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.ShardCount = 8
+	config.KeyspaceSize = 64
+	cache := NewShardedLRU(config)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i%100)
+				cache.Put(request.NewRequestFromValues(key, "value", -1))
+				cache.Get(request.NewRequestFromValues(key, "", -1))
+				if i%3 == 0 {
+					cache.DeleteByKey(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every segment stays within its share of the keyspace, and its
+	// count agrees with its hashtable and list.
+	for _, shard := range cache.Shards {
+		utils.AssertEqual(t, shard.Count <= shard.Size, true, "")
+		utils.AssertEqual(t, int(shard.Count), len(shard.Hashtable), "")
+		utils.AssertEqual(t, shard.Count, shard.DLL.Size, "")
+	}
+}
+
+func TestShardedLruMemoryBound(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.ShardCount = 4
+	config.KeyspaceSize = 100
+	value := strings.Repeat("a", 1000)
+	size := EstimateSize("key-0", value)
+	config.MaxMemoryBytes = 3 * size
+	cache := NewShardedLRU(config)
+
+	// A value larger than a quarter of the bound still fits
+	message := cache.Put(request.NewRequestFromValues("key-0", value, -1))
+	utils.AssertEqual(t, STORED, message.Message, "")
+
+	message = cache.Put(request.NewRequestFromValues("Large", strings.Repeat("a", int(4*size)), -1))
+	utils.AssertEqual(t, NOT_STORED, message.Message, "")
+
+	// The bound holds for the segments together
+	for i := 1; i < 10; i++ {
+		message = cache.Put(request.NewRequestFromValues(fmt.Sprintf("key-%d", i), value, -1))
+		utils.AssertEqual(t, STORED, message.Message, "")
+		utils.AssertEqual(t, cache.CountBytes() <= config.MaxMemoryBytes, true, "")
+	}
+	utils.AssertEqual(t, cache.CountKeys(request.NewEmptyRequest()).Gobj.Value, int32(3), "")
+
+	bytes := int64(0)
+	for _, shard := range cache.Shards {
+		bytes += shard.CountBytes()
+	}
+	utils.AssertEqual(t, bytes, cache.CountBytes(), "")
+	utils.AssertEqual(t, cache.PolicyMetrics()["LruMaxBytes"], config.MaxMemoryBytes, "")
+
+	// A key overwritten with a value larger than the bound is removed
+	message = cache.Put(request.NewRequestFromValues("key-9", strings.Repeat("a", int(4*size)), -1))
+	utils.AssertEqual(t, NOT_STORED, message.Message, "")
+	utils.AssertEqual(t, cache.Get(request.NewRequestFromValues("key-9", "", -1)).Message, CACHE_MISS, "")
+	utils.AssertEqual(t, cache.CountKeys(request.NewEmptyRequest()).Gobj.Value, int32(2), "")
+
+	cache.Flush(request.NewEmptyRequest())
+	utils.AssertEqual(t, cache.CountBytes(), int64(0), "")
+}
@@ -93,7 +93,9 @@ type AppMetrics struct {
 
 	// Removed is the cumulative number of key-value pairs
 	// removed from the cache node. This includes key-value
-	// pairs removed by the cache crawlers.
+	// pairs removed by the cache crawlers and expired key-value
+	// pairs removed by the writes, or reads on the leader,
+	// that found them.
 	Removed   uint64
 
 	// NotFound is the cumulative number of key-value pairs
@@ -117,6 +119,16 @@ type AppMetrics struct {
 	// EntryTimestamp is a bool representing whether or not to
 	// include timestamps on the log entries.
 	EntryTimestamp bool
+
+	// policyReporter supplies statistics specific to the
+	// stores cache policy, if the policy has any.
+	policyReporter PolicyReporter
+}
+
+// PolicyReporter is implemented by caches whose eviction policy
+// keeps statistics worth recording in the appMetrics log.
+type PolicyReporter interface {
+	PolicyMetrics() map[string]int64
 }
 
 // ReadAppMetrics struct is used to Unmarshal log entries
@@ -136,6 +148,8 @@ type ReadAppMetrics struct {
 	NotFound  uint64 
 	Flushed   uint64 `json: "-"`
 	ErrFlush  uint64 
+
+	PolicyMetrics map[string]int64
 }
 
 // Boot instantiates a appMetrics log struct and its corresponding log file
@@ -189,6 +203,10 @@ func WriteMetrics(appMetrics *AppMetrics, cmd string, resp response.CacheRespons
 		} else {
 			Removed(appMetrics)
 		}
+	case constants.STORE_EXPIRE:
+		if resp.Status == 1 {
+			Removed(appMetrics)
+		}
 	case constants.STORE_FLUSH:
 		FlushHit(appMetrics)
 		if resp.Status != 1 {
@@ -199,6 +217,14 @@ func WriteMetrics(appMetrics *AppMetrics, cmd string, resp response.CacheRespons
 	}
 }
 
+// SetPolicyReporter sets the source of policy specific
+// statistics written with each appMetrics log entry.
+func SetPolicyReporter(appMetrics *AppMetrics, reporter PolicyReporter) {
+	appMetrics.Mux.Lock()
+	defer appMetrics.Mux.Unlock()
+	appMetrics.policyReporter = reporter
+}
+
 // ErrFlush is a setter that increments
 // its corresponding struct field by one
 func ErrFlush(appMetrics *AppMetrics) {
@@ -342,10 +368,21 @@ func Dump(appMetrics *AppMetrics) {
 		getMetrics := fmt.Sprintf(`"GetRequests": %d, "CacheMiss": %d, `, appMetrics.GetRequests, appMetrics.CacheMiss)
 		putMetrics := fmt.Sprintf(`"PutRequests": %d, `, appMetrics.PutRequests)
 		addMetrics := fmt.Sprintf(`"AddRequsets": %d, "NotStored": %d, `, appMetrics.AddRequests, appMetrics.NotStored)
-		deleteMetrics := fmt.Sprintf(`"DeleteRequests": %d, "NotFound": %d, `, appMetrics.DeleteRequests, appMetrics.NotFound)
-		flushMetrics := fmt.Sprintf(`"FlushRequests": %d, "ErrFlush": %d}`+"\n", appMetrics.FlushRequests, appMetrics.ErrFlush)
+		deleteMetrics := fmt.Sprintf(`"DeleteRequests": %d, "NotFound": %d, "Removed": %d, `, appMetrics.DeleteRequests, appMetrics.NotFound, atomic.LoadUint64(&appMetrics.Removed))
+		flushMetrics := fmt.Sprintf(`"FlushRequests": %d, "ErrFlush": %d`, appMetrics.FlushRequests, appMetrics.ErrFlush)
+
+		appMetrics.Mux.Lock()
+		reporter := appMetrics.policyReporter
+		appMetrics.Mux.Unlock()
+
+		policyMetrics := "}\n"
+		if reporter != nil {
+			if b, err := json.Marshal(reporter.PolicyMetrics()); err == nil {
+				policyMetrics = fmt.Sprintf(`, "PolicyMetrics": %s}`+"\n", b)
+			}
+		}
 
-		file.WriteString(total + getMetrics + putMetrics + addMetrics + deleteMetrics + flushMetrics)	
+		file.WriteString(total + getMetrics + putMetrics + addMetrics + deleteMetrics + flushMetrics + policyMetrics)	
 	}
 }
 
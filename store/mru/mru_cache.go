@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package mru
+
+import (
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// MRUCache represents a most recently used cache object.
+//
+// It shares the doubly linked list used by the LRU cache but
+// evicts from the head of the list. This suits cyclic scans over
+// a dataset larger than the cache, where the pair just used is
+// the one that will be needed again last.
+type MRUCache struct {
+	lru.Keyed
+
+	// Size represents the maximum number of allowable
+	// key-value pairs in the cache.
+	Size int32
+
+	// Count records the number of key-value pairs
+	// currently in the cache.
+	Count int32
+
+	// Full tracks if Count is equal to Size
+	Full bool
+
+	// DLL is a doubly linked list containing all key-value pairs
+	DLL *lru.List `json:"-"`
+}
+
+// NewMRU will initialize the cache
+func NewMRU(config config.Configuration) *MRUCache {
+	cache := &MRUCache{
+		Size:  config.KeyspaceSize,
+		Count: int32(0),
+		Full:  false,
+		DLL:   lru.InitList(),
+	}
+	cache.InitKeyed(cache.remove, cache.replayOrder)
+	return cache
+}
+
+// Get will fetch a key/value pair from the cache
+func (cache *MRUCache) Get(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	node, ok := cache.Live(args.Gobj.Key)
+	if !ok {
+		return response.NewCacheMissResponse()
+	}
+
+	lru.RemoveNode(cache.DLL, node)
+	lru.InsertNode(cache.DLL, node)
+
+	return response.NewResponseFromValue(node.Value)
+}
+
+// Put will add a key/value pair to the cache, possibly
+// overwriting an existing key/value pair. Put will evict
+// the most recently used key/value pair if the cache is full.
+func (cache *MRUCache) Put(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Value = args.Gobj.Value
+		node.TTL = args.Gobj.TTL
+		node.Stamp(args)
+
+		lru.RemoveNode(cache.DLL, node)
+		lru.InsertNode(cache.DLL, node)
+		return response.NewResponseFromMessage(lru.STORED, 1)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Add will add a key/value pair to the cache if the key
+// does not exist already.
+func (cache *MRUCache) Add(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok && !cache.Expire(node, lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_STORED, 0)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Flush removes all key/value pairs from the cache even if they have not expired
+func (cache *MRUCache) Flush(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.DLL = lru.InitList()
+	cache.Hashtable = make(map[string]*lru.Node)
+	cache.Count = 0
+	cache.Full = false
+
+	return response.NewResponseFromMessage(lru.FLUSHED, 1)
+}
+
+// CountKeys return the number of keys in the cache
+func (cache *MRUCache) CountKeys(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+	return response.NewResponseFromValue(cache.Count)
+}
+
+// insert adds a new key-value pair, evicting the most recently
+// used pair first if the cache is full. The caller must hold
+// the cache lock.
+func (cache *MRUCache) insert(key string, value interface{}, ttl int64) {
+	if cache.Count >= cache.Size {
+		if n, err := lru.RemoveFirst(cache.DLL); err == nil {
+			delete(cache.Hashtable, n.Key)
+			cache.Count--
+		}
+	}
+
+	node, _ := lru.Insert(cache.DLL, key, value, ttl)
+	cache.Hashtable[key] = node
+	cache.Count++
+	cache.Full = cache.Count >= cache.Size
+}
+
+// remove removes a key-value pair from the cache.
+// The caller must hold the cache lock.
+func (cache *MRUCache) remove(node *lru.Node) {
+	lru.RemoveNode(cache.DLL, node)
+	delete(cache.Hashtable, node.Key)
+	cache.Count--
+	cache.Full = false
+}
+
+// replayOrder returns the list of the cache, whose key-value
+// pairs are replayed least recently used first.
+func (cache *MRUCache) replayOrder() []*lru.List {
+	return []*lru.List{cache.DLL}
+}
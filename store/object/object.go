@@ -34,6 +34,10 @@ type CacheObject struct {
 	Key   string `json:"Key"`
 	Value interface{} `json:"Value"`
 	TTL   int64 `json:"TTL,string"`
+
+	// Flags are opaque bits stored with the value by memcached
+	// clients, which use them to record how the value is encoded.
+	Flags uint32 `json:"Flags,omitempty"`
 }
 
 func NewCacheObjectFromValue(value interface{}) CacheObject{
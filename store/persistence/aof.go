@@ -139,9 +139,11 @@ func appendBufferContent(dualWrite bool) {
 
 func reduceAOF(cache *cache.Cache) {
 	CreateAOF(getTempLogPath())
-	for k, v := range *((*cache).GetHashtableReference()) {
+	// The key/value pairs are copied under the lock of the
+	// cache, as it goes on serving requests during the rewrite
+	for _, v := range (*cache).Entries() {
 		timeStamp := time.Now().Format(time.RFC850)
-		entry := fmt.Sprintf(`{"Time":"%s", "Verb":"add", "Key":"%s", "Value":"%s", "TTL":"%d"}`+"\n", timeStamp, k, v.Value, v.TTL)
+		entry := fmt.Sprintf(`{"Time":"%s", "Verb":"add", "Key":"%s", "Value":"%s", "TTL":"%d"}`+"\n", timeStamp, v.Key, v.Value, v.TTL)
 		tmpBuffer.WriteString(entry)
 	}
 	file, err := os.OpenFile(configPath+tempLog, os.O_APPEND|os.O_WRONLY, 0600)
@@ -43,10 +43,18 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	// "time"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/lfu"
+	"github.com/ghostdb/ghostdb-cache-node/store/arc"
+	"github.com/ghostdb/ghostdb-cache-node/store/mru"
+	"github.com/ghostdb/ghostdb-cache-node/store/tlru"
+	"github.com/ghostdb/ghostdb-cache-node/store/tinylfu"
 	"github.com/ghostdb/ghostdb-cache-node/store/cache"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
 	"github.com/ghostdb/ghostdb-cache-node/config"
 )
 
@@ -58,6 +66,23 @@ func CreateSnapshot(cache *cache.Cache, config *config.Configuration) (bool, err
 	switch (*cache).(type) {
 	case *lru.LRUCache:
 		return createLruSnapshot((*cache).(*lru.LRUCache), config.EnableEncryption, config.Passphrase)
+	case *lru.ShardedLRUCache:
+		return createShardedLruSnapshot((*cache).(*lru.ShardedLRUCache), config.EnableEncryption, config.Passphrase)
+	case *lfu.LFUCache:
+		c := (*cache).(*lfu.LFUCache)
+		return createLockedSnapshot(&c.Mux, c, config.EnableEncryption, config.Passphrase)
+	case *arc.ARCCache:
+		c := (*cache).(*arc.ARCCache)
+		return createLockedSnapshot(&c.Mux, c, config.EnableEncryption, config.Passphrase)
+	case *tlru.TLRUCache:
+		c := (*cache).(*tlru.TLRUCache)
+		return createLockedSnapshot(&c.Mux, c, config.EnableEncryption, config.Passphrase)
+	case *mru.MRUCache:
+		c := (*cache).(*mru.MRUCache)
+		return createLockedSnapshot(&c.Mux, c, config.EnableEncryption, config.Passphrase)
+	case *tinylfu.TinyLFUCache:
+		c := (*cache).(*tinylfu.TinyLFUCache)
+		return createLockedSnapshot(&c.Mux, c, config.EnableEncryption, config.Passphrase)
 	default:
 		return false, nil
 	}
@@ -65,7 +90,48 @@ func CreateSnapshot(cache *cache.Cache, config *config.Configuration) (bool, err
 
 func createLruSnapshot(cache *lru.LRUCache, encryption bool, passphrase ...string) (bool, error) {
 	serialized, _ := json.MarshalIndent(cache, "", " ")
+	return writeSnapshot(serialized, encryption, passphrase...)
+}
 
+// createShardedLruSnapshot serializes the merged hashtable of the
+// segments of a sharded cache, so the snapshot can be replayed into
+// a cache with any number of segments, or any other policy. The
+// key/value pairs are copied under the lock of each segment, as
+// the segments go on serving requests while the copy is written.
+func createShardedLruSnapshot(cache *lru.ShardedLRUCache, encryption bool, passphrase ...string) (bool, error) {
+	entries := cache.Entries()
+	snapshot := struct {
+		Hashtable map[string]lru.Entry
+	}{
+		Hashtable: make(map[string]lru.Entry, len(entries)),
+	}
+	for _, entry := range entries {
+		snapshot.Hashtable[entry.Key] = entry
+	}
+	serialized, err := json.MarshalIndent(snapshot, "", " ")
+	if err != nil {
+		log.Printf("failed to serialize cache: %s", err.Error())
+		return false, err
+	}
+	return writeSnapshot(serialized, encryption, passphrase...)
+}
+
+// createLockedSnapshot serializes a cache while holding its lock,
+// so the snapshot is a consistent point-in-time view of the cache.
+func createLockedSnapshot(mux *sync.Mutex, cache interface{}, encryption bool, passphrase ...string) (bool, error) {
+	mux.Lock()
+	serialized, err := json.MarshalIndent(cache, "", " ")
+	mux.Unlock()
+	if err != nil {
+		log.Printf("failed to serialize cache: %s", err.Error())
+		return false, err
+	}
+	return writeSnapshot(serialized, encryption, passphrase...)
+}
+
+// writeSnapshot compresses, and optionally encrypts, a serialized
+// cache and writes it to the snapshot file.
+func writeSnapshot(serialized []byte, encryption bool, passphrase ...string) (bool, error) {
 	configPath, _ := os.UserConfigDir()
 	snapshotPath := configPath + SNAPSHOT_FILENAME
 
@@ -122,13 +188,16 @@ func BuildCacheFromSnapshot(bs *[]byte) (lru.LRUCache, error) {
 	ll := lru.InitList()
 
 	// Populate the caches hashtable and doubly linked list with the values 
-	// from the unmarshalled byte stream
+	// from the unmarshalled byte stream. Byte estimates are recomputed
+	// as the snapshot may predate them.
+	cache.Bytes = 0
 	for _, v := range cache.Hashtable {
 		n, err := lru.Insert(ll, v.Key, v.Value, v.TTL)
 		if err != nil {
 			return lru.LRUCache{}, err
 		}
 		cache.Hashtable[v.Key] = n
+		cache.Bytes += n.Bytes
 	}
 
 	cache.DLL = ll
@@ -136,6 +205,58 @@ func BuildCacheFromSnapshot(bs *[]byte) (lru.LRUCache, error) {
 	return cache, nil
 }
 
+// BuildLfuCacheFromSnapshot rebuilds an LFU cache from the byte stream of the snapshot
+func BuildLfuCacheFromSnapshot(bs *[]byte) (*lfu.LFUCache, error) {
+	var cache lfu.LFUCache
+
+	err := json.Unmarshal(*bs, &cache)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the hashtable and frequencies are serialized, so the
+	// frequency buckets need to be relinked.
+	cache.Rebuild()
+
+	return &cache, nil
+}
+
+// ReplaySnapshot rebuilds a cache of any policy from the byte stream
+// of a snapshot taken by any policy. Every snapshot serializes the
+// caches hashtable, so its key-value pairs are replayed into the
+// cache oldest first, keeping their original creation times.
+// Key-value pairs that expired while the node was down are skipped.
+func ReplaySnapshot(bs *[]byte, c cache.Cache) error {
+	var snapshot struct {
+		Hashtable map[string]*lru.Node
+	}
+
+	err := json.Unmarshal(*bs, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]*lru.Node, 0, len(snapshot.Hashtable))
+	for _, node := range snapshot.Hashtable {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].CreatedAt < nodes[j].CreatedAt
+	})
+
+	now := time.Now().Unix()
+	for _, node := range nodes {
+		if node.TTL != -1 && node.CreatedAt+node.TTL < now {
+			continue
+		}
+		c.Put(request.NewRequestFromValues(node.Key, node.Value, node.TTL))
+		if restored, ok := (*c.GetHashtableReference())[node.Key]; ok {
+			restored.CreatedAt = node.CreatedAt
+		}
+	}
+	return nil
+}
+
 // ReadSnapshot reads the compressed snapshot file into
 // buffer and returns a reference to the buffer
 func ReadSnapshot(encryption bool, passphrase ...string) *[]byte {
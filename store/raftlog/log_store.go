@@ -0,0 +1,616 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package raftlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// segmentExt is the file extension of log segments.
+	segmentExt = ".seg"
+
+	// segmentMaxBytes is the size at which the active segment
+	// is closed and a new segment is started.
+	segmentMaxBytes = 8 * 1024 * 1024
+
+	// recordHeaderSize is the size of the length and CRC
+	// prefixed to each record.
+	recordHeaderSize = 8
+
+	// entryHeaderSize is the size of the fixed fields of
+	// an encoded log entry.
+	entryHeaderSize = 8 + 8 + 1 + 4 + 4
+)
+
+// crcTable is the CRC32 table used to checksum records.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned when a record fails its checksum
+// anywhere other than at the tail of the log.
+var ErrCorrupt = errors.New("raft log is corrupt")
+
+// segment is a run of consecutive log entries stored in a single
+// file named after the index of its first entry.
+type segment struct {
+	path    string
+	first   uint64
+	// offsets holds the file offset of each record, so
+	// the record of index first+i is at offsets[i].
+	offsets []int64
+	size    int64
+	// file is opened for reads on first use.
+	file    *os.File
+}
+
+// lastIndex returns the index of the last entry in the segment.
+func (seg *segment) lastIndex() uint64 {
+	return seg.first + uint64(len(seg.offsets)) - 1
+}
+
+func (seg *segment) close() {
+	if seg.file != nil {
+		seg.file.Close()
+		seg.file = nil
+	}
+}
+
+// LogStore is a raft.LogStore that keeps the log in segment files
+// under a directory. Each record is written with its length and
+// CRC32, and the log is synced to disk before StoreLogs returns.
+// A record torn by a crash is found and cut off when the log is
+// opened again.
+type LogStore struct {
+	dir      string
+	mux      sync.RWMutex
+	segments []*segment
+	// active is the last segment, opened for appends.
+	active   *os.File
+}
+
+// NewLogStore opens the log stored in dir, creating
+// the directory if it does not exist.
+func NewLogStore(dir string) (*LogStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	// Segment names are zero padded, so they sort by index.
+	sort.Strings(names)
+
+	ls := &LogStore{dir: dir}
+	for i, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad segment name %s", name)
+		}
+		seg, err := loadSegment(name, first, i == len(names)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(seg.offsets) == 0 {
+			// Only the last segment can be empty, when
+			// the crash tore its first record.
+			os.Remove(name)
+			continue
+		}
+		if n := len(ls.segments); n > 0 {
+			prev := ls.segments[n-1]
+			if first <= prev.lastIndex() {
+				// A crash while the head of the log was being
+				// deleted left the segments this one replaced.
+				for _, old := range ls.segments {
+					os.Remove(old.path)
+				}
+				ls.segments = nil
+			} else if first != prev.lastIndex()+1 {
+				return nil, fmt.Errorf("%s: entries %d to %d are missing", ErrCorrupt, prev.lastIndex()+1, first-1)
+			}
+		}
+		ls.segments = append(ls.segments, seg)
+	}
+
+	if n := len(ls.segments); n > 0 {
+		if ls.active, err = os.OpenFile(ls.segments[n-1].path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return ls, nil
+}
+
+// loadSegment reads the records of a segment file. A record that
+// fails its checksum is cut off if it is in the last segment, as
+// it was torn by a crash, and is an error anywhere else.
+func loadSegment(path string, first uint64, last bool) (*segment, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := &segment{path: path, first: first}
+	var off int64
+	for off < int64(len(data)) {
+		payload, ok := readRecord(data[off:])
+		if ok {
+			var index uint64
+			if index, ok = entryIndex(payload); ok && index != first+uint64(len(seg.offsets)) {
+				return nil, fmt.Errorf("%s: %s holds index %d out of order", ErrCorrupt, path, index)
+			}
+		}
+		if !ok {
+			if !last {
+				return nil, fmt.Errorf("%s: bad record in %s at offset %d", ErrCorrupt, path, off)
+			}
+			if err := os.Truncate(path, off); err != nil {
+				return nil, err
+			}
+			break
+		}
+		seg.offsets = append(seg.offsets, off)
+		off += recordHeaderSize + int64(len(payload))
+	}
+	seg.size = off
+	return seg, nil
+}
+
+// readRecord returns the payload of the record at the start of
+// data, or false if the record is incomplete or fails its checksum.
+func readRecord(data []byte) ([]byte, bool) {
+	if len(data) < recordHeaderSize {
+		return nil, false
+	}
+	length := binary.BigEndian.Uint32(data[0:4])
+	sum := binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-recordHeaderSize) < uint64(length) {
+		return nil, false
+	}
+	payload := data[recordHeaderSize : recordHeaderSize+int(length)]
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, false
+	}
+	return payload, true
+}
+
+// appendRecord appends a record holding the encoded log entry to buf.
+func appendRecord(buf *bytes.Buffer, log *raft.Log) {
+	payload := make([]byte, entryHeaderSize+len(log.Data)+len(log.Extensions))
+	binary.BigEndian.PutUint64(payload[0:8], log.Index)
+	binary.BigEndian.PutUint64(payload[8:16], log.Term)
+	payload[16] = byte(log.Type)
+	binary.BigEndian.PutUint32(payload[17:21], uint32(len(log.Data)))
+	binary.BigEndian.PutUint32(payload[21:25], uint32(len(log.Extensions)))
+	copy(payload[entryHeaderSize:], log.Data)
+	copy(payload[entryHeaderSize+len(log.Data):], log.Extensions)
+
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	buf.Write(header[:])
+	buf.Write(payload)
+}
+
+// entryIndex returns the index of an encoded log entry.
+func entryIndex(payload []byte) (uint64, bool) {
+	if len(payload) < entryHeaderSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(payload[0:8]), true
+}
+
+// decodeEntry decodes a log entry written by appendRecord.
+func decodeEntry(payload []byte, log *raft.Log) error {
+	if len(payload) < entryHeaderSize {
+		return ErrCorrupt
+	}
+	dataLen := int(binary.BigEndian.Uint32(payload[17:21]))
+	extLen := int(binary.BigEndian.Uint32(payload[21:25]))
+	if len(payload) != entryHeaderSize+dataLen+extLen {
+		return ErrCorrupt
+	}
+
+	log.Index = binary.BigEndian.Uint64(payload[0:8])
+	log.Term = binary.BigEndian.Uint64(payload[8:16])
+	log.Type = raft.LogType(payload[16])
+	log.Data = nil
+	log.Extensions = nil
+	if dataLen > 0 {
+		log.Data = append([]byte(nil), payload[entryHeaderSize:entryHeaderSize+dataLen]...)
+	}
+	if extLen > 0 {
+		log.Extensions = append([]byte(nil), payload[entryHeaderSize+dataLen:]...)
+	}
+	return nil
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (ls *LogStore) FirstIndex() (uint64, error) {
+	ls.mux.RLock()
+	defer ls.mux.RUnlock()
+
+	if len(ls.segments) == 0 {
+		return 0, nil
+	}
+	return ls.segments[0].first, nil
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (ls *LogStore) LastIndex() (uint64, error) {
+	ls.mux.RLock()
+	defer ls.mux.RUnlock()
+
+	return ls.lastIndex(), nil
+}
+
+func (ls *LogStore) lastIndex() uint64 {
+	if len(ls.segments) == 0 {
+		return 0
+	}
+	return ls.segments[len(ls.segments)-1].lastIndex()
+}
+
+// find returns the position of the segment holding index,
+// or -1 if no segment holds it.
+func (ls *LogStore) find(index uint64) int {
+	i := sort.Search(len(ls.segments), func(i int) bool {
+		return ls.segments[i].lastIndex() >= index
+	})
+	if i == len(ls.segments) || ls.segments[i].first > index {
+		return -1
+	}
+	return i
+}
+
+// GetLog gets a log entry at a given index.
+func (ls *LogStore) GetLog(index uint64, log *raft.Log) error {
+	// Reads open the segment file on first use, which
+	// needs the write lock.
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+
+	i := ls.find(index)
+	if i == -1 {
+		return raft.ErrLogNotFound
+	}
+	seg := ls.segments[i]
+	if seg.file == nil {
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return err
+		}
+		seg.file = file
+	}
+
+	pos := index - seg.first
+	start := seg.offsets[pos]
+	end := seg.size
+	if pos+1 < uint64(len(seg.offsets)) {
+		end = seg.offsets[pos+1]
+	}
+	data := make([]byte, end-start)
+	if _, err := seg.file.ReadAt(data, start); err != nil && err != io.EOF {
+		return err
+	}
+	payload, ok := readRecord(data)
+	if !ok {
+		return fmt.Errorf("%s: bad record for index %d", ErrCorrupt, index)
+	}
+	return decodeEntry(payload, log)
+}
+
+// StoreLog stores a log entry.
+func (ls *LogStore) StoreLog(log *raft.Log) error {
+	return ls.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries. The entries must follow
+// on from the last index written, and are synced to disk before
+// StoreLogs returns.
+func (ls *LogStore) StoreLogs(logs []*raft.Log) error {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+
+	var next uint64
+	if last := ls.lastIndex(); last != 0 {
+		next = last + 1
+	}
+
+	var buf bytes.Buffer
+	var offsets []int64
+	for _, log := range logs {
+		if next != 0 && log.Index != next {
+			return fmt.Errorf("log entry %d does not follow index %d", log.Index, next-1)
+		}
+		next = log.Index + 1
+
+		if ls.active == nil || ls.activeSegment().size+int64(buf.Len()) >= segmentMaxBytes {
+			if err := ls.flush(&buf, offsets); err != nil {
+				return err
+			}
+			offsets = nil
+			if err := ls.roll(log.Index); err != nil {
+				return err
+			}
+		}
+		offsets = append(offsets, ls.activeSegment().size+int64(buf.Len()))
+		appendRecord(&buf, log)
+	}
+	return ls.flush(&buf, offsets)
+}
+
+func (ls *LogStore) activeSegment() *segment {
+	return ls.segments[len(ls.segments)-1]
+}
+
+// flush writes the buffered records to the active segment and syncs it.
+func (ls *LogStore) flush(buf *bytes.Buffer, offsets []int64) error {
+	if buf.Len() == 0 {
+		return nil
+	}
+	if _, err := ls.active.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := ls.active.Sync(); err != nil {
+		return err
+	}
+
+	seg := ls.activeSegment()
+	seg.offsets = append(seg.offsets, offsets...)
+	seg.size += int64(buf.Len())
+	buf.Reset()
+	return nil
+}
+
+// roll starts a new segment whose first entry is index.
+func (ls *LogStore) roll(index uint64) error {
+	path := filepath.Join(ls.dir, fmt.Sprintf("%020d%s", index, segmentExt))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(ls.dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	if ls.active != nil {
+		ls.active.Close()
+	}
+	ls.active = file
+	ls.segments = append(ls.segments, &segment{path: path, first: index})
+	return nil
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive.
+// Raft only deletes from the head of the log after a snapshot, or
+// from the tail of the log when a follower's log conflicts with the
+// leader's, so a range in the middle of the log is an error.
+func (ls *LogStore) DeleteRange(min, max uint64) error {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+
+	if len(ls.segments) == 0 {
+		return nil
+	}
+	first, last := ls.segments[0].first, ls.lastIndex()
+	if max < first || min > last {
+		return nil
+	}
+
+	switch {
+	case min <= first && max >= last:
+		return ls.deleteAll()
+	case min <= first:
+		return ls.deleteHead(max)
+	case max >= last:
+		return ls.deleteTail(min)
+	}
+	return fmt.Errorf("cannot delete entries %d to %d from the middle of the log", min, max)
+}
+
+func (ls *LogStore) deleteAll() error {
+	if ls.active != nil {
+		ls.active.Close()
+		ls.active = nil
+	}
+	for _, seg := range ls.segments {
+		seg.close()
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+	}
+	ls.segments = nil
+	return syncDir(ls.dir)
+}
+
+// deleteHead deletes the entries up to and including max. Segments
+// holding only deleted entries are removed, and the segment holding
+// max is rewritten to start after it.
+func (ls *LogStore) deleteHead(max uint64) error {
+	for len(ls.segments) > 0 && ls.segments[0].lastIndex() <= max {
+		seg := ls.segments[0]
+		seg.close()
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		ls.segments = ls.segments[1:]
+	}
+	if len(ls.segments) == 0 || ls.segments[0].first > max {
+		return syncDir(ls.dir)
+	}
+
+	seg := ls.segments[0]
+	index := max + 1
+	start := seg.offsets[index-seg.first]
+
+	data, err := ioutil.ReadFile(seg.path)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(ls.dir, fmt.Sprintf("%020d%s", index, segmentExt))
+	if err := writeFileSync(path, data[start:seg.size]); err != nil {
+		return err
+	}
+	// The new segment is in place before the old one is removed,
+	// so a crash in between is resolved when the log is opened.
+	seg.close()
+	if err := os.Remove(seg.path); err != nil {
+		return err
+	}
+	if err := syncDir(ls.dir); err != nil {
+		return err
+	}
+
+	offsets := make([]int64, 0, len(seg.offsets)-int(index-seg.first))
+	for _, off := range seg.offsets[index-seg.first:] {
+		offsets = append(offsets, off-start)
+	}
+	seg.path = path
+	seg.first = index
+	seg.offsets = offsets
+	seg.size -= start
+
+	if len(ls.segments) == 1 {
+		ls.active.Close()
+		if ls.active, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteTail deletes the entries from min to the end of the log.
+// Segments are removed from the end first, so a crash always
+// leaves a log without gaps.
+func (ls *LogStore) deleteTail(min uint64) error {
+	ls.active.Close()
+	ls.active = nil
+
+	for len(ls.segments) > 0 && ls.activeSegment().first >= min {
+		seg := ls.activeSegment()
+		seg.close()
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		ls.segments = ls.segments[:len(ls.segments)-1]
+	}
+	if len(ls.segments) == 0 {
+		return syncDir(ls.dir)
+	}
+
+	seg := ls.activeSegment()
+	if seg.lastIndex() >= min {
+		size := seg.offsets[min-seg.first]
+		if err := os.Truncate(seg.path, size); err != nil {
+			return err
+		}
+		seg.offsets = seg.offsets[:min-seg.first]
+		seg.size = size
+	}
+
+	var err error
+	if ls.active, err = os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	if err := ls.active.Sync(); err != nil {
+		return err
+	}
+	return syncDir(ls.dir)
+}
+
+// Close closes the segment files.
+func (ls *LogStore) Close() error {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+
+	for _, seg := range ls.segments {
+		seg.close()
+	}
+	if ls.active != nil {
+		err := ls.active.Close()
+		ls.active = nil
+		return err
+	}
+	return nil
+}
+
+// writeFileSync writes data to path through a temporary file, which
+// is synced and renamed into place, so path is never left half written.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs a directory, so the files created, renamed
+// or removed in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package raftlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func newTestLog(index uint64) *raft.Log {
+	return &raft.Log{
+		Index: index,
+		Term:  index/10 + 1,
+		Type:  raft.LogCommand,
+		Data:  []byte(fmt.Sprintf("entry %d", index)),
+	}
+}
+
+func storeTestLogs(t *testing.T, ls *LogStore, from, to uint64) {
+	logs := []*raft.Log{}
+	for i := from; i <= to; i++ {
+		logs = append(logs, newTestLog(i))
+	}
+	if err := ls.StoreLogs(logs); err != nil {
+		t.Fatalf("failed to store logs: %s", err)
+	}
+}
+
+// assertIndexes checks the first and last index of the log, and
+// that every entry between them reads back as it was stored.
+func assertIndexes(t *testing.T, ls *LogStore, first, last uint64) {
+	gotFirst, _ := ls.FirstIndex()
+	gotLast, _ := ls.LastIndex()
+	utils.AssertEqual(t, gotFirst, first, "")
+	utils.AssertEqual(t, gotLast, last, "")
+
+	for i := first; i <= last && last != 0; i++ {
+		var log raft.Log
+		if err := ls.GetLog(i, &log); err != nil {
+			t.Fatalf("failed to get log %d: %s", i, err)
+		}
+		utils.AssertEqual(t, reflect.DeepEqual(&log, newTestLog(i)), true, "")
+	}
+
+	var log raft.Log
+	utils.AssertEqual(t, ls.GetLog(last+1, &log), raft.ErrLogNotFound, "")
+}
+
+func TestLogStoreReopen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "raftlog_test")
+	defer os.RemoveAll(dir)
+
+	ls, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open log: %s", err)
+	}
+	assertIndexes(t, ls, 0, 0)
+
+	storeTestLogs(t, ls, 1, 50)
+	utils.AssertEqual(t, ls.StoreLog(newTestLog(52)) != nil, true, "")
+	ls.Close()
+
+	ls, err = NewLogStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen log: %s", err)
+	}
+	assertIndexes(t, ls, 1, 50)
+
+	storeTestLogs(t, ls, 51, 60)
+	assertIndexes(t, ls, 1, 60)
+	ls.Close()
+}
+
+func TestLogStoreTornTail(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "raftlog_test")
+	defer os.RemoveAll(dir)
+
+	ls, _ := NewLogStore(dir)
+	storeTestLogs(t, ls, 1, 10)
+	ls.Close()
+
+	// Tear the last record, as a crash in the middle of a write would.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	info, _ := os.Stat(segments[0])
+	os.Truncate(segments[0], info.Size()-3)
+
+	ls, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen log: %s", err)
+	}
+	assertIndexes(t, ls, 1, 9)
+
+	// The log carries on from the last whole record.
+	storeTestLogs(t, ls, 10, 12)
+	ls.Close()
+
+	ls, _ = NewLogStore(dir)
+	assertIndexes(t, ls, 1, 12)
+	ls.Close()
+}
+
+func TestLogStoreCorruptRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "raftlog_test")
+	defer os.RemoveAll(dir)
+
+	ls, _ := NewLogStore(dir)
+	storeTestLogs(t, ls, 1, 10)
+	ls.Close()
+
+	// Flip a byte in the first record, so it fails its checksum.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	data, _ := ioutil.ReadFile(segments[0])
+	data[recordHeaderSize] ^= 0xff
+	ioutil.WriteFile(segments[0], data, 0600)
+
+	ls, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen log: %s", err)
+	}
+	assertIndexes(t, ls, 0, 0)
+	ls.Close()
+}
+
+func TestLogStoreDeleteRange(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "raftlog_test")
+	defer os.RemoveAll(dir)
+
+	ls, _ := NewLogStore(dir)
+	storeTestLogs(t, ls, 1, 100)
+
+	// Compaction deletes from the head of the log.
+	if err := ls.DeleteRange(1, 40); err != nil {
+		t.Fatalf("failed to delete head: %s", err)
+	}
+	assertIndexes(t, ls, 41, 100)
+
+	// A conflicting follower deletes from the tail of the log.
+	if err := ls.DeleteRange(91, 100); err != nil {
+		t.Fatalf("failed to delete tail: %s", err)
+	}
+	assertIndexes(t, ls, 41, 90)
+
+	utils.AssertEqual(t, ls.DeleteRange(50, 60) != nil, true, "")
+
+	storeTestLogs(t, ls, 91, 95)
+	ls.Close()
+
+	ls, _ = NewLogStore(dir)
+	assertIndexes(t, ls, 41, 95)
+
+	if err := ls.DeleteRange(41, 95); err != nil {
+		t.Fatalf("failed to delete log: %s", err)
+	}
+	assertIndexes(t, ls, 0, 0)
+
+	// After a snapshot is installed the log restarts at any index.
+	storeTestLogs(t, ls, 200, 210)
+	ls.Close()
+
+	ls, _ = NewLogStore(dir)
+	assertIndexes(t, ls, 200, 210)
+	ls.Close()
+}
+
+func TestLogStoreInterruptedHeadDelete(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "raftlog_test")
+	defer os.RemoveAll(dir)
+
+	ls, _ := NewLogStore(dir)
+	storeTestLogs(t, ls, 1, 20)
+	oldPath := filepath.Join(dir, fmt.Sprintf("%020d%s", 1, segmentExt))
+	old, _ := ioutil.ReadFile(oldPath)
+
+	if err := ls.DeleteRange(1, 5); err != nil {
+		t.Fatalf("failed to delete head: %s", err)
+	}
+	ls.Close()
+
+	// Put back the segment the rewrite replaced, as a crash before
+	// it was removed would leave it.
+	ioutil.WriteFile(oldPath, old, 0600)
+
+	ls, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen log: %s", err)
+	}
+	assertIndexes(t, ls, 6, 20)
+	ls.Close()
+
+	_, err = os.Stat(oldPath)
+	utils.AssertEqual(t, os.IsNotExist(err), true, "")
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package raftlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrKeyNotFound is returned for keys that were never set.
+// Raft matches on the message "not found" to tell a new
+// node from a failed read.
+var ErrKeyNotFound = errors.New("not found")
+
+// StableStore is a raft.StableStore that keeps the current term
+// and vote in a single file. The file is rewritten in full and
+// renamed into place on every Set, and carries a CRC32 so a
+// damaged file is refused rather than read as an older term.
+type StableStore struct {
+	path string
+	mux  sync.RWMutex
+	kv   map[string][]byte
+}
+
+// NewStableStore opens the stable store kept in the file at path.
+func NewStableStore(path string) (*StableStore, error) {
+	ss := &StableStore{
+		path: path,
+		kv:   make(map[string][]byte),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ss, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	payload, ok := readRecord(data)
+	if !ok || len(payload)+recordHeaderSize != len(data) {
+		return nil, fmt.Errorf("%s: bad stable store %s", ErrCorrupt, path)
+	}
+	for len(payload) > 0 {
+		var key, value []byte
+		if key, payload, ok = readField(payload); ok {
+			value, payload, ok = readField(payload)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: bad stable store %s", ErrCorrupt, path)
+		}
+		ss.kv[string(key)] = value
+	}
+	return ss, nil
+}
+
+// readField reads a length prefixed field from the start of data.
+func readField(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(data[0:4])
+	if uint64(len(data)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return data[4 : 4+n], data[4+n:], true
+}
+
+func writeField(buf *bytes.Buffer, field []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(field)))
+	buf.Write(n[:])
+	buf.Write(field)
+}
+
+// Set stores the value of a key and syncs it to disk.
+func (ss *StableStore) Set(key []byte, val []byte) error {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+
+	// Write the new value to disk before serving it, so a failed
+	// write never hands Raft a term or vote that is not durable.
+	kv := make(map[string][]byte, len(ss.kv)+1)
+	for k, v := range ss.kv {
+		kv[k] = v
+	}
+	kv[string(key)] = append([]byte(nil), val...)
+	if err := persist(ss.path, kv); err != nil {
+		return err
+	}
+	ss.kv = kv
+	return nil
+}
+
+// Get returns the value of a key.
+func (ss *StableStore) Get(key []byte) ([]byte, error) {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+
+	val, ok := ss.kv[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte(nil), val...), nil
+}
+
+// SetUint64 stores the value of a key as a uint64.
+func (ss *StableStore) SetUint64(key []byte, val uint64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], val)
+	return ss.Set(key, b[:])
+}
+
+// GetUint64 returns the value of a key stored by SetUint64.
+func (ss *StableStore) GetUint64(key []byte) (uint64, error) {
+	val, err := ss.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("value of %s is not a uint64", key)
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// persist writes every key to the file at path as a single record.
+func persist(path string, kv map[string][]byte) error {
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payload bytes.Buffer
+	for _, key := range keys {
+		writeField(&payload, []byte(key))
+		writeField(&payload, kv[key])
+	}
+
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload.Bytes(), crcTable))
+	return writeFileSync(path, append(header[:], payload.Bytes()...))
+}
+
+// Close releases the stable store. Every Set is already on disk.
+func (ss *StableStore) Close() error {
+	return nil
+}
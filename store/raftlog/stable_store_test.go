@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package raftlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestStableStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "raftlog_test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stable.dat")
+
+	ss, err := NewStableStore(path)
+	if err != nil {
+		t.Fatalf("failed to open stable store: %s", err)
+	}
+	_, err = ss.GetUint64([]byte("CurrentTerm"))
+	utils.AssertEqual(t, err, ErrKeyNotFound, "")
+
+	ss.SetUint64([]byte("CurrentTerm"), 7)
+	ss.Set([]byte("LastVoteCand"), []byte("node1"))
+	ss.Close()
+
+	ss, err = NewStableStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen stable store: %s", err)
+	}
+	term, _ := ss.GetUint64([]byte("CurrentTerm"))
+	cand, _ := ss.Get([]byte("LastVoteCand"))
+	utils.AssertEqual(t, term, uint64(7), "")
+	utils.AssertEqual(t, string(cand), "node1", "")
+
+	// A term that cannot be written to disk is not served.
+	os.Mkdir(path+".tmp", 0700)
+	utils.AssertEqual(t, ss.SetUint64([]byte("CurrentTerm"), 8) != nil, true, "")
+	term, _ = ss.GetUint64([]byte("CurrentTerm"))
+	utils.AssertEqual(t, term, uint64(7), "")
+	os.Remove(path + ".tmp")
+
+	// A damaged file is refused rather than read as an older term.
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(path, data, 0600)
+	_, err = NewStableStore(path)
+	utils.AssertEqual(t, err != nil, true, "")
+}
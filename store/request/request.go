@@ -34,8 +34,33 @@ import (
 	"github.com/ghostdb/ghostdb-cache-node/store/object"
 )
 
+// Read consistency levels
+const (
+	CONSISTENCY_STALE        = "stale"        // Served from the local cache of any node
+	CONSISTENCY_LEADER       = "leader"       // Served only by the node that believes it is leader
+	CONSISTENCY_LINEARIZABLE = "linearizable" // Served by the leader once it has confirmed it still is
+)
+
 type CacheRequest struct {
 	Gobj object.CacheObject `json:"Gobj"`
+
+	// Version identifies the write that stores a key-value pair.
+	// Writes replicated through Raft use the index of their log
+	// entry, internal expire commands the version they expire.
+	Version   uint64 `json:"Version,omitempty"`
+
+	// Timestamp is the time, in unix seconds, the leader proposed
+	// a replicated write, so every replica times it the same way.
+	// If 0 the request is timed when it is handled.
+	Timestamp int64  `json:"Timestamp,omitempty"`
+
+	// Consistency is the consistency level of a read, one of
+	// stale, leader or linearizable. If empty the read is stale.
+	Consistency string `json:"Consistency,omitempty"`
+
+	// Cas is the version a cas command expects the key-value pair
+	// to be at, which memcached clients read with gets.
+	Cas       uint64 `json:"Cas,omitempty"`
 }
 
 func NewRequestFromValues(key string, value interface{}, ttl int64) CacheRequest {
@@ -38,6 +38,10 @@ import (
 
 const (
 	INVALID_COMMAND_ERR = "INVALID_COMMAND_ERR"
+	INVALID_CONSISTENCY_ERR = "INVALID_CONSISTENCY_ERR"
+	NOT_LEADER_ERR = "NOT_LEADER_ERR"
+	WRONG_GROUP_ERR = "WRONG_GROUP_ERR"
+	INVALID_ENTRY_ERR = "INVALID_ENTRY_ERR"
 )
 
 type CacheResponse struct {
@@ -51,6 +55,10 @@ type CacheResponse struct {
 	// Error message returned if something went wrong
 	// during command execution
 	Error   string
+	// Version identifies the write that stored the key-value
+	// pair returned by gets, which memcached clients use as
+	// its cas unique
+	Version uint64 `json:",omitempty"`
 }
 
 func NewResponseFromValue(value interface{}) CacheResponse{
@@ -96,4 +104,46 @@ func NewPingResponse() CacheResponse {
 		Message: "Pong!",
 		Error: "",
 	}
-}
\ No newline at end of file
+}
+func BadConsistencyResponse(level string) CacheResponse {
+	return CacheResponse {
+		Gobj: object.NewEmptyCacheObject(),
+		Status: 0,
+		Message: fmt.Sprintf("Consistency '%s' is not a recognized consistency level", level),
+		Error: INVALID_CONSISTENCY_ERR,
+	}
+}
+
+// NewNotLeaderResponse is returned for requests that only the leader
+// can serve. The value is the HTTP address of the leader, or empty
+// if the node does not know the leader.
+func NewNotLeaderResponse(leader string) CacheResponse {
+	return CacheResponse {
+		Gobj: object.NewCacheObjectFromValue(leader),
+		Status: 0,
+		Message: "Node is not the leader",
+		Error: NOT_LEADER_ERR,
+	}
+}
+
+// NewWrongGroupResponse is returned for requests sent to a node
+// that does not host their group and cannot route them to one.
+func NewWrongGroupResponse(group int) CacheResponse {
+	return CacheResponse {
+		Gobj: object.NewCacheObjectFromValue(group),
+		Status: 0,
+		Message: fmt.Sprintf("No reachable node hosts group %d", group),
+		Error: WRONG_GROUP_ERR,
+	}
+}
+
+// BadEntryResponse is the result of a replicated log entry that
+// could not be decoded, such as one written in an unknown format.
+func BadEntryResponse(err error) CacheResponse {
+	return CacheResponse {
+		Gobj: object.NewEmptyCacheObject(),
+		Status: 0,
+		Message: fmt.Sprintf("Log entry could not be decoded: %s", err.Error()),
+		Error: INVALID_ENTRY_ERR,
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package shard
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+)
+
+// Node is a cache node of a sharded cluster. It runs one store, each
+// its own Raft group, for the meta group and for every data group it
+// hosts, and maps each key to the group that owns it.
+type Node struct {
+	// ID is the Raft ID of the node in every group it hosts
+	ID       string
+
+	// HTTPAddr is the address the node serves clients on
+	HTTPAddr string
+
+	// Shards is the number of data groups in the cluster
+	Shards   int
+
+	// GroupPort is the Raft port of data group 1, each next data
+	// group using the next port. If 0 the data groups use the ports
+	// above that of the meta group. Nodes sharing a host need ports
+	// far enough apart that their groups do not collide.
+	GroupPort int
+
+	stores   map[int]*base.Store
+	slots    SlotMap
+}
+
+// NewNode builds the stores of a node hosting the meta group and
+// the given data groups. In a sharded cluster the keyspace size and
+// memory bound of the node are divided between the data groups it
+// hosts. Snapshots and the append-only file cannot be used, as the
+// stores would share one path, and the stores are made durable by
+// their Raft log and snapshots instead.
+func NewNode(conf config.Configuration, id string, httpAddr string, groups []int) (*Node, error) {
+	node := &Node{
+		ID:       id,
+		HTTPAddr: httpAddr,
+		Shards:   int(conf.RaftGroups),
+		stores:   make(map[int]*base.Store),
+		slots:    NewSlotMap(int(conf.RaftGroups)),
+	}
+
+	if node.Sharded() && (conf.SnapshotEnabled || conf.PersistenceAOF) {
+		return nil, fmt.Errorf("snapshots and the append-only file cannot be used in a sharded cluster, configure the durable raft store instead")
+	}
+
+	hosted := []int{META_GROUP}
+	if node.Sharded() {
+		for _, g := range groups {
+			if g < 1 || g > node.Shards {
+				return nil, fmt.Errorf("group %d is not a data group of a cluster with %d shards", g, node.Shards)
+			}
+			hosted = append(hosted, g)
+		}
+	}
+
+	for i, g := range hosted {
+		store := base.NewStore(conf.Policy)
+		store.BuildStore(groupConfig(conf, i-1, len(hosted)-1))
+		store.HTTPAddr = httpAddr
+		node.stores[g] = store
+	}
+	if node.Sharded() {
+		node.stores[META_GROUP].Groups = node.DataGroupsHosted()
+	}
+	return node, nil
+}
+
+// groupConfig returns the configuration of the i-th of n data groups
+// a node hosts, whose keyspace size, the remainder spread over the
+// first groups, and memory bound are their share of those of the
+// node. The meta group, whose i is -1, and a single group hosted
+// alone keep them whole.
+func groupConfig(conf config.Configuration, i int, n int) config.Configuration {
+	if i < 0 || n <= 1 {
+		return conf
+	}
+	size := conf.KeyspaceSize / int32(n)
+	if int32(i) < conf.KeyspaceSize%int32(n) {
+		size++
+	}
+	if size < 1 {
+		size = 1
+	}
+	conf.KeyspaceSize = size
+	conf.MaxMemoryBytes /= int64(n)
+	return conf
+}
+
+// Sharded reports if the keyspace is split over several groups.
+func (node *Node) Sharded() bool {
+	return node.Shards > 1
+}
+
+// Open opens the Raft group of every store. The meta group uses
+// raftDir and raftAddr, and data group g the directory group-g
+// under raftDir and the Raft address GroupRaftAddr gives it. The
+// node refuses to open if any two of its groups share an address,
+// or any address is already in use, before opening any group. If
+// bootstrap is set every group starts as a single node cluster
+// led by this node.
+func (node *Node) Open(raftDir string, raftAddr string, bootstrap bool) error {
+	addrs, err := node.raftAddrs(raftAddr)
+	if err != nil {
+		return err
+	}
+	for _, g := range node.Groups() {
+		store := node.stores[g]
+		store.RaftDir = GroupRaftDir(raftDir, g)
+		if err := os.MkdirAll(store.RaftDir, 0700); err != nil {
+			return err
+		}
+		store.RaftBind = addrs[g]
+		if err := store.Open(bootstrap, node.ID); err != nil {
+			return fmt.Errorf("group %d: %s", g, err)
+		}
+	}
+	return nil
+}
+
+// raftAddrs returns the Raft address of every group the node hosts,
+// checking that each is its own and free to listen on.
+func (node *Node) raftAddrs(raftAddr string) (map[int]string, error) {
+	addrs := make(map[int]string)
+	groups := make(map[string]int)
+	for _, g := range node.Groups() {
+		addr, err := GroupRaftAddr(raftAddr, node.GroupPort, g)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := groups[addr]; ok {
+			return nil, fmt.Errorf("groups %d and %d share the raft address %s", other, g, addr)
+		}
+		groups[addr] = g
+		addrs[g] = addr
+	}
+	for _, g := range node.Groups() {
+		ln, err := net.Listen("tcp", addrs[g])
+		if err != nil {
+			return nil, fmt.Errorf("raft address %s of group %d is not free: %s", addrs[g], g, err)
+		}
+		ln.Close()
+	}
+	return addrs, nil
+}
+
+// GroupRaftDir returns the Raft directory of a group.
+func GroupRaftDir(raftDir string, group int) string {
+	if group == META_GROUP {
+		return raftDir
+	}
+	return filepath.Join(raftDir, fmt.Sprintf("group-%d", group))
+}
+
+// GroupRaftAddr returns the Raft address of a group. The meta group
+// uses raftAddr, and data group g the port g-1 above groupPort, or
+// if groupPort is 0 the port g above that of the meta group.
+func GroupRaftAddr(raftAddr string, groupPort int, group int) (string, error) {
+	host, port, err := net.SplitHostPort(raftAddr)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("bad raft port %q", port)
+	}
+	if group != META_GROUP && groupPort > 0 {
+		p = groupPort + group - 1
+	} else {
+		p += group
+	}
+	if p > 65535 {
+		return "", fmt.Errorf("raft port %d of group %d is out of range", p, group)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p)), nil
+}
+
+// Close closes the Raft group of every store.
+func (node *Node) Close() error {
+	var err error
+	for _, store := range node.stores {
+		if e := store.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Store returns the store of a group, if the node hosts it.
+func (node *Node) Store(group int) (*base.Store, bool) {
+	store, ok := node.stores[group]
+	return store, ok
+}
+
+// Meta returns the store of the meta group.
+func (node *Node) Meta() *base.Store {
+	return node.stores[META_GROUP]
+}
+
+// Groups returns the groups the node hosts, the meta group first.
+func (node *Node) Groups() []int {
+	groups := make([]int, 0, len(node.stores))
+	for g := range node.stores {
+		groups = append(groups, g)
+	}
+	sort.Ints(groups)
+	return groups
+}
+
+// DataGroups returns the data groups of the cluster.
+func (node *Node) DataGroups() []int {
+	return DataGroups(node.Shards)
+}
+
+// DataGroupsHosted returns the data groups the node hosts.
+func (node *Node) DataGroupsHosted() []int {
+	groups := []int{}
+	for _, g := range node.Groups() {
+		if g != META_GROUP || !node.Sharded() {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// Group returns the group that owns a key.
+func (node *Node) Group(key string) int {
+	return node.Owner(Slot(key))
+}
+
+// Owner returns the group that owns a slot, which is the group the
+// slot was last moved to, or if it never moved its initial group.
+func (node *Node) Owner(slot int) int {
+	if group, ok := node.Meta().SlotOwner(slot); ok {
+		return group
+	}
+	return node.slots[slot]
+}
+
+// Migrating returns the migration of a slot that is being moved.
+func (node *Node) Migrating(slot int) (base.Migration, bool) {
+	m, ok := node.Meta().SlotMigration(slot)
+	if !ok || m.State != base.MIGRATION_COPYING {
+		return base.Migration{}, false
+	}
+	return m, true
+}
+
+// Members returns the HTTP addresses of the other nodes
+// the directory records as hosting a group.
+func (node *Node) Members(group int) []string {
+	members := []string{}
+	for _, addr := range node.Meta().GroupMembers(group) {
+		if addr != node.HTTPAddr {
+			members = append(members, addr)
+		}
+	}
+	return members
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package shard
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/base"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+// freeRaftAddrs returns a local address whose port, and the
+// ports of the given number of groups above it, are all free
+func freeRaftAddrs(t *testing.T, groups int) string {
+	for attempt := 0; attempt < 100; attempt++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to find a free port: %s", err)
+		}
+		base := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+
+		free := true
+		for g := 1; g <= groups && free; g++ {
+			l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(base+g))
+			if err != nil {
+				free = false
+				continue
+			}
+			l.Close()
+		}
+		if free {
+			return "127.0.0.1:" + strconv.Itoa(base)
+		}
+	}
+	t.Fatalf("failed to find free ports")
+	return ""
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestShardedNodes(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.RaftGroups = 2
+
+	// The seed hosts every group, the second node only group 2
+	seed, err := NewNode(conf, "node0", "node0:7991", []int{1, 2})
+	utils.AssertEqual(t, err, nil, "")
+	seedDir, _ := ioutil.TempDir("", "shard_test")
+	seedAddr := freeRaftAddrs(t, 2)
+	if err := seed.Open(seedDir, seedAddr, true); err != nil {
+		t.Fatalf("failed to open node: %s", err)
+	}
+	defer seed.Close()
+
+	other, err := NewNode(conf, "node1", "node1:7991", []int{2})
+	utils.AssertEqual(t, err, nil, "")
+	otherDir, _ := ioutil.TempDir("", "shard_test")
+	otherAddr := freeRaftAddrs(t, 2)
+	if err := other.Open(otherDir, otherAddr, false); err != nil {
+		t.Fatalf("failed to open node: %s", err)
+	}
+	defer other.Close()
+
+	utils.AssertEqual(t, reflect.DeepEqual(seed.Groups(), []int{0, 1, 2}), true, "")
+	utils.AssertEqual(t, reflect.DeepEqual(other.Groups(), []int{0, 2}), true, "")
+	utils.AssertEqual(t, reflect.DeepEqual(other.DataGroupsHosted(), []int{2}), true, "")
+	_, ok := other.Store(1)
+	utils.AssertEqual(t, ok, false, "")
+
+	led := waitFor(10*time.Second, func() bool {
+		for _, g := range seed.Groups() {
+			store, _ := seed.Store(g)
+			if !store.IsLeader() {
+				return false
+			}
+		}
+		return true
+	})
+	if !led {
+		t.Fatalf("no leader elected")
+	}
+
+	for _, g := range other.Groups() {
+		store, _ := seed.Store(g)
+		addr, _ := GroupRaftAddr(otherAddr, 0, g)
+		if err := store.Join("node1", addr); err != nil {
+			t.Fatalf("failed to join group %d: %s", g, err)
+		}
+		utils.AssertEqual(t, store.SetPeerHTTPAddr("node1", other.HTTPAddr), nil, "")
+	}
+	utils.AssertEqual(t, seed.Meta().SetPeerGroups("node1", other.DataGroupsHosted()), nil, "")
+
+	// Every node routes with the same directory
+	known := waitFor(10*time.Second, func() bool {
+		return reflect.DeepEqual(other.Members(1), []string{"node0:7991"}) &&
+			reflect.DeepEqual(other.Members(2), []string{"node0:7991"})
+	})
+	utils.AssertEqual(t, known, true, "")
+	utils.AssertEqual(t, reflect.DeepEqual(seed.Members(2), []string{"node1:7991"}), true, "")
+	utils.AssertEqual(t, len(seed.Members(1)), 0, "")
+
+	// Each key is written to the group that owns it, and is
+	// only replicated to the nodes hosting that group.
+	keys := map[int]string{}
+	for i := 0; len(keys) < 2; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys[seed.Group(key)] = key
+	}
+	for g, key := range keys {
+		store, _ := seed.Store(g)
+		x := store.Execute("put", request.NewRequestFromValues(key, "value", -1))
+		utils.AssertEqual(t, x.Status, int32(1), "")
+	}
+
+	group2, _ := other.Store(2)
+	replicated := waitFor(10*time.Second, func() bool {
+		x := group2.Execute("get", request.NewRequestFromValues(keys[2], "", -1))
+		return x.Gobj.Value == "value"
+	})
+	utils.AssertEqual(t, replicated, true, "")
+	x := group2.Execute("get", request.NewRequestFromValues(keys[1], "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, nil, "")
+	x = other.Meta().Execute("get", request.NewRequestFromValues(keys[2], "", -1))
+	utils.AssertEqual(t, x.Gobj.Value, nil, "")
+
+	// A slot is owned by its old group until its migration completes,
+	// and then by its new group on every node.
+	slot := Slot(keys[1])
+	m := base.Migration{Slot: slot, From: 1, To: 2, State: base.MIGRATION_COPYING}
+	utils.AssertEqual(t, seed.Meta().SetMigration(m), nil, "")
+	_, migrating := seed.Migrating(slot)
+	utils.AssertEqual(t, migrating, true, "")
+	utils.AssertEqual(t, seed.Group(keys[1]), 1, "")
+
+	m.State = base.MIGRATION_COMPLETE
+	utils.AssertEqual(t, seed.Meta().SetMigration(m), nil, "")
+	_, migrating = seed.Migrating(slot)
+	utils.AssertEqual(t, migrating, false, "")
+	utils.AssertEqual(t, seed.Group(keys[1]), 2, "")
+	moved := waitFor(10*time.Second, func() bool {
+		return other.Owner(slot) == 2
+	})
+	utils.AssertEqual(t, moved, true, "")
+}
+
+func TestNodeRejectsUnknownGroup(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.RaftGroups = 2
+	_, err := NewNode(conf, "node0", "node0:7991", []int{3})
+	utils.AssertEqual(t, err != nil, true, "")
+
+	// A single shard ignores data groups, the meta group holds every key
+	conf.RaftGroups = 1
+	node, err := NewNode(conf, "node0", "node0:7991", []int{0})
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, reflect.DeepEqual(node.Groups(), []int{0}), true, "")
+	utils.AssertEqual(t, reflect.DeepEqual(node.DataGroupsHosted(), []int{0}), true, "")
+	utils.AssertEqual(t, node.Group("England"), META_GROUP, "")
+}
+
+func TestNodeRejectsPersistence(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.RaftGroups = 2
+	conf.SnapshotEnabled = true
+	_, err := NewNode(conf, "node0", "node0:7991", []int{1, 2})
+	utils.AssertEqual(t, err != nil, true, "")
+
+	conf.SnapshotEnabled = false
+	conf.PersistenceAOF = true
+	_, err = NewNode(conf, "node0", "node0:7991", []int{1, 2})
+	utils.AssertEqual(t, err != nil, true, "")
+
+	// A single shard keeps its snapshots and append-only file
+	conf.RaftGroups = 1
+	_, err = NewNode(conf, "node0", "node0:7991", nil)
+	utils.AssertEqual(t, err, nil, "")
+}
+
+func TestNodeDividesBounds(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.RaftGroups = 4
+	conf.KeyspaceSize = 10
+	conf.MaxMemoryBytes = 3000
+
+	// The bounds of the node are shared by the data groups it hosts
+	node, err := NewNode(conf, "node0", "node0:7991", []int{1, 2, 3})
+	utils.AssertEqual(t, err, nil, "")
+	sizes := []int32{}
+	for _, g := range node.DataGroupsHosted() {
+		store, _ := node.Store(g)
+		utils.AssertEqual(t, store.Conf.MaxMemoryBytes, int64(1000), "")
+		sizes = append(sizes, store.Conf.KeyspaceSize)
+	}
+	utils.AssertEqual(t, reflect.DeepEqual(sizes, []int32{4, 3, 3}), true, "")
+	utils.AssertEqual(t, node.Meta().Conf.KeyspaceSize, int32(10), "")
+
+	// A group hosted alone has the bounds of the node
+	node, err = NewNode(conf, "node1", "node1:7991", []int{2})
+	utils.AssertEqual(t, err, nil, "")
+	store, _ := node.Store(2)
+	utils.AssertEqual(t, store.Conf.KeyspaceSize, int32(10), "")
+	utils.AssertEqual(t, store.Conf.MaxMemoryBytes, int64(3000), "")
+}
+
+func TestGroupRaftPortCollisions(t *testing.T) {
+	conf := config.InitializeConfiguration()
+	conf.SnapshotEnabled = false
+	conf.RaftGroups = 2
+	dir, _ := ioutil.TempDir("", "shard_test")
+	addr := freeRaftAddrs(t, 2)
+	_, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+
+	// Data group 1 would share the port of the meta group
+	node, _ := NewNode(conf, "node0", "node0:7991", []int{1, 2})
+	node.GroupPort = p
+	err := node.Open(dir, addr, true)
+	utils.AssertEqual(t, err != nil, true, "")
+
+	// Data group 2 would listen on a port already in use
+	l, _ := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(p+2))
+	node, _ = NewNode(conf, "node0", "node0:7991", []int{1, 2})
+	err = node.Open(dir, addr, true)
+	l.Close()
+	utils.AssertEqual(t, err != nil, true, "")
+
+	// No group is opened once a collision is found
+	utils.AssertEqual(t, node.Meta().Raft == nil, true, "")
+}
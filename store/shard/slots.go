@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package shard
+
+import (
+	"hash/crc32"
+)
+
+const (
+	// SLOT_COUNT is the number of slots the keyspace is split into.
+	// Slots, not keys, are assigned to Raft groups, so the assignment
+	// stays small however many keys the cluster holds.
+	SLOT_COUNT = 1024
+
+	// META_GROUP is the Raft group every node hosts. It holds the
+	// directory of which nodes host which data groups. In a cluster
+	// with a single shard it is also the only data group.
+	META_GROUP = 0
+)
+
+// Slot returns the slot a key belongs to.
+func Slot(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % SLOT_COUNT)
+}
+
+// SlotMap assigns each slot to the Raft group that owns it.
+type SlotMap []int
+
+// NewSlotMap spreads the slots evenly over the data groups of a
+// cluster with the given number of shards, giving each group a
+// contiguous range of slots. With a single shard every slot is
+// owned by the meta group.
+func NewSlotMap(shards int) SlotMap {
+	slots := make(SlotMap, SLOT_COUNT)
+	if shards <= 1 {
+		return slots
+	}
+	for slot := range slots {
+		slots[slot] = 1 + slot*shards/SLOT_COUNT
+	}
+	return slots
+}
+
+// Group returns the group that owns the slot of a key.
+func (slots SlotMap) Group(key string) int {
+	return slots[Slot(key)]
+}
+
+// DataGroups returns the groups that hold key-value pairs in a
+// cluster with the given number of shards.
+func DataGroups(shards int) []int {
+	if shards <= 1 {
+		return []int{META_GROUP}
+	}
+	groups := make([]int, 0, shards)
+	for g := 1; g <= shards; g++ {
+		groups = append(groups, g)
+	}
+	return groups
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package shard
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestSlotMap(t *testing.T) {
+	// A single shard keeps every key in the meta group
+	slots := NewSlotMap(1)
+	utils.AssertEqual(t, len(slots), SLOT_COUNT, "")
+	utils.AssertEqual(t, slots.Group("England"), META_GROUP, "")
+	utils.AssertEqual(t, reflect.DeepEqual(DataGroups(1), []int{META_GROUP}), true, "")
+
+	// Each data group owns an equal, contiguous range of slots
+	slots = NewSlotMap(4)
+	owned := map[int]int{}
+	for slot, g := range slots {
+		owned[g]++
+		if slot > 0 {
+			utils.AssertEqual(t, g >= slots[slot-1], true, "")
+		}
+	}
+	utils.AssertEqual(t, reflect.DeepEqual(owned, map[int]int{1: 256, 2: 256, 3: 256, 4: 256}), true, "")
+	utils.AssertEqual(t, reflect.DeepEqual(DataGroups(4), []int{1, 2, 3, 4}), true, "")
+
+	// Keys spread over every group, and always map to the same one
+	keys := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		utils.AssertEqual(t, slots.Group(key), slots[Slot(key)], "")
+		keys[slots.Group(key)]++
+	}
+	utils.AssertEqual(t, len(keys), 4, "")
+}
+
+func TestGroupRaftAddr(t *testing.T) {
+	addr, err := GroupRaftAddr("127.0.0.1:11000", 0, 0)
+	utils.AssertEqual(t, err, nil, "")
+	utils.AssertEqual(t, addr, "127.0.0.1:11000", "")
+	addr, _ = GroupRaftAddr("127.0.0.1:11000", 0, 3)
+	utils.AssertEqual(t, addr, "127.0.0.1:11003", "")
+	_, err = GroupRaftAddr("127.0.0.1", 0, 3)
+	utils.AssertEqual(t, err != nil, true, "")
+
+	// With a group port the data groups start there
+	addr, _ = GroupRaftAddr("127.0.0.1:11000", 12000, 0)
+	utils.AssertEqual(t, addr, "127.0.0.1:11000", "")
+	addr, _ = GroupRaftAddr("127.0.0.1:11000", 12000, 3)
+	utils.AssertEqual(t, addr, "127.0.0.1:12002", "")
+	_, err = GroupRaftAddr("127.0.0.1:65535", 0, 1)
+	utils.AssertEqual(t, err != nil, true, "")
+
+	utils.AssertEqual(t, GroupRaftDir("/data", 0), "/data", "")
+	utils.AssertEqual(t, GroupRaftDir("/data", 2), "/data/group-2", "")
+}
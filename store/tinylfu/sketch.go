@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tinylfu
+
+import (
+	"hash/fnv"
+)
+
+const (
+	// sketchDepth is the number of counter rows in the sketch.
+	// Each key maps to one counter per row.
+	sketchDepth = 4
+
+	// maxCount is the largest value a counter can hold. Small
+	// counters are enough to tell hot keys from one-hit wonders.
+	maxCount = 15
+
+	// widthFactor sets the number of counters in each row per
+	// key-value pair the cache can hold. Wider rows mean fewer
+	// keys share a counter and so fewer overestimates.
+	widthFactor = 8
+
+	// sampleFactor sets how many increments, per key-value pair
+	// the cache can hold, the sketch records before every counter
+	// is halved.
+	sampleFactor = 10
+)
+
+// countMinSketch is a compact, approximate frequency counter.
+// Estimates never undercount, and halving the counters once the
+// sample is full lets the sketch follow changes in popularity.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+// newCountMinSketch creates a sketch sized for the given
+// number of key-value pairs.
+func newCountMinSketch(capacity int32) *countMinSketch {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	width := uint64(16)
+	for width < uint64(capacity)*widthFactor {
+		width <<= 1
+	}
+
+	sketch := &countMinSketch{
+		mask:       width - 1,
+		sampleSize: int(capacity) * sampleFactor,
+	}
+	for i := range sketch.rows {
+		sketch.rows[i] = make([]uint8, width)
+	}
+	return sketch
+}
+
+// Increment records an access to a key.
+func (sketch *countMinSketch) Increment(key string) {
+	h1, h2 := hashKey(key)
+	for i := range sketch.rows {
+		index := (h1 + uint64(i)*h2) & sketch.mask
+		if sketch.rows[i][index] < maxCount {
+			sketch.rows[i][index]++
+		}
+	}
+
+	sketch.additions++
+	if sketch.additions >= sketch.sampleSize {
+		sketch.age()
+	}
+}
+
+// Estimate returns the approximate number of accesses to a key.
+func (sketch *countMinSketch) Estimate(key string) uint8 {
+	h1, h2 := hashKey(key)
+	estimate := uint8(maxCount)
+	for i := range sketch.rows {
+		index := (h1 + uint64(i)*h2) & sketch.mask
+		if sketch.rows[i][index] < estimate {
+			estimate = sketch.rows[i][index]
+		}
+	}
+	return estimate
+}
+
+// Clear resets every counter to zero.
+func (sketch *countMinSketch) Clear() {
+	for i := range sketch.rows {
+		for j := range sketch.rows[i] {
+			sketch.rows[i][j] = 0
+		}
+	}
+	sketch.additions = 0
+}
+
+// age halves every counter.
+func (sketch *countMinSketch) age() {
+	for i := range sketch.rows {
+		for j := range sketch.rows[i] {
+			sketch.rows[i][j] >>= 1
+		}
+	}
+	sketch.additions /= 2
+}
+
+// hashKey derives the two hashes used to index each row
+// of the sketch by double hashing.
+func hashKey(key string) (uint64, uint64) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	h := hasher.Sum64()
+	return h, (h >> 32) | 1
+}
@@ -0,0 +1,319 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tinylfu
+
+import (
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+const (
+	// windowPercent is the share of the keyspace given to
+	// the admission window.
+	windowPercent = 1
+
+	// protectedPercent is the share of the main region given
+	// to the protected segment.
+	protectedPercent = 80
+)
+
+// TinyLFUCache represents a W-TinyLFU cache object.
+//
+// New key-value pairs enter a small LRU admission window. When a
+// pair falls out of the window it competes with the next victim of
+// the main region, and only gets in if the frequency sketch says
+// it is accessed more often. The main region is a segmented LRU:
+// pairs start in the probation segment and move to the protected
+// segment when they are accessed again.
+//
+// Reads update the frequency sketch, and each replica of a cluster
+// serves its own reads, so replicas may admit different pairs, as
+// replicas of the other policies may evict different pairs.
+type TinyLFUCache struct {
+	lru.Keyed
+
+	// Size represents the maximum number of allowable
+	// key-value pairs in the cache.
+	Size int32
+
+	// Count records the number of key-value pairs
+	// currently in the cache.
+	Count int32
+
+	// Full tracks if Count is equal to Size
+	Full bool
+
+	// Admitted is the cumulative number of key-value pairs
+	// that won admission to the main region over a victim.
+	Admitted uint64
+
+	// Rejected is the cumulative number of key-value pairs
+	// evicted from the window because they lost to the victim.
+	Rejected uint64
+
+
+	// lists maps every key to the list it is in
+	lists map[string]*lru.List
+
+	window    *lru.List
+	probation *lru.List
+	protected *lru.List
+
+	windowSize    int32
+	mainSize      int32
+	protectedSize int32
+
+	sketch *countMinSketch
+}
+
+// NewTinyLFU will initialize the cache
+func NewTinyLFU(config config.Configuration) *TinyLFUCache {
+	cache := &TinyLFUCache{
+		Size: config.KeyspaceSize,
+	}
+	cache.resize(config.KeyspaceSize)
+	cache.InitKeyed(cache.remove, cache.replayOrder)
+	return cache
+}
+
+// resize sets the segment sizes for a keyspace size and
+// empties the cache.
+func (cache *TinyLFUCache) resize(size int32) {
+	cache.Size = size
+	cache.windowSize = size * windowPercent / 100
+	if cache.windowSize < 1 {
+		cache.windowSize = 1
+	}
+	cache.mainSize = size - cache.windowSize
+	cache.protectedSize = cache.mainSize * protectedPercent / 100
+	cache.sketch = newCountMinSketch(size)
+	cache.reset()
+}
+
+func (cache *TinyLFUCache) reset() {
+	cache.Count = 0
+	cache.Full = false
+	cache.Hashtable = make(map[string]*lru.Node)
+	cache.lists = make(map[string]*lru.List)
+	cache.window = lru.InitList()
+	cache.probation = lru.InitList()
+	cache.protected = lru.InitList()
+}
+
+// Get will fetch a key/value pair from the cache
+func (cache *TinyLFUCache) Get(args request.CacheRequest) response.CacheResponse {
+	key := args.Gobj.Key
+
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	// Misses are counted too, so a key that keeps being
+	// requested can win admission once it is stored.
+	cache.sketch.Increment(key)
+
+	node, ok := cache.Live(key)
+	if !ok {
+		return response.NewCacheMissResponse()
+	}
+	cache.hit(node)
+
+	return response.NewResponseFromValue(node.Value)
+}
+
+// Put will add a key/value pair to the cache, possibly
+// overwriting an existing key/value pair. Put will evict
+// a key/value pair if the cache is full.
+func (cache *TinyLFUCache) Put(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.sketch.Increment(args.Gobj.Key)
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Value = args.Gobj.Value
+		node.TTL = args.Gobj.TTL
+		node.Stamp(args)
+		cache.hit(node)
+		return response.NewResponseFromMessage(lru.STORED, 1)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Add will add a key/value pair to the cache if the key
+// does not exist already.
+func (cache *TinyLFUCache) Add(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.sketch.Increment(args.Gobj.Key)
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok && !cache.Expire(node, lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_STORED, 0)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL)
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Flush removes all key/value pairs from the cache even if they have not expired
+func (cache *TinyLFUCache) Flush(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.reset()
+	cache.sketch.Clear()
+
+	return response.NewResponseFromMessage(lru.FLUSHED, 1)
+}
+
+// CountKeys return the number of keys in the cache
+func (cache *TinyLFUCache) CountKeys(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+	return response.NewResponseFromValue(cache.Count)
+}
+
+// PolicyMetrics reports how many candidates the admission
+// filter let into the main region and how many it turned away.
+func (cache *TinyLFUCache) PolicyMetrics() map[string]int64 {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	return map[string]int64{
+		"TinyLfuAdmitted":      int64(cache.Admitted),
+		"TinyLfuRejected":      int64(cache.Rejected),
+		"TinyLfuWindowSize":    int64(cache.window.Size),
+		"TinyLfuProbationSize": int64(cache.probation.Size),
+		"TinyLfuProtectedSize": int64(cache.protected.Size),
+	}
+}
+
+// hit records an access to a cached key-value pair. Pairs in
+// probation are promoted to the protected segment, which may in
+// turn demote its least recently used pair back to probation.
+// The caller must hold the cache lock.
+func (cache *TinyLFUCache) hit(node *lru.Node) {
+	ll := cache.lists[node.Key]
+	lru.RemoveNode(ll, node)
+
+	if ll != cache.probation {
+		lru.InsertNode(ll, node)
+		return
+	}
+
+	lru.InsertNode(cache.protected, node)
+	cache.lists[node.Key] = cache.protected
+	if cache.protected.Size > cache.protectedSize {
+		demoted, err := lru.RemoveLast(cache.protected)
+		if err == nil {
+			lru.InsertNode(cache.probation, demoted)
+			cache.lists[demoted.Key] = cache.probation
+		}
+	}
+}
+
+// insert adds a new key-value pair to the admission window. If the
+// window overflows, its oldest pair becomes a candidate for the
+// main region. The caller must hold the cache lock.
+func (cache *TinyLFUCache) insert(key string, value interface{}, ttl int64) {
+	node, _ := lru.Insert(cache.window, key, value, ttl)
+	cache.Hashtable[key] = node
+	cache.lists[key] = cache.window
+	cache.Count++
+
+	if cache.window.Size > cache.windowSize {
+		candidate, err := lru.RemoveLast(cache.window)
+		if err == nil {
+			cache.admit(candidate)
+		}
+	}
+	cache.Full = cache.Count >= cache.Size
+}
+
+// admit moves a candidate from the window into the probation
+// segment. If the main region is full, the candidate only gets
+// in if it is estimated to be used more often than the victim,
+// otherwise the candidate itself is evicted.
+func (cache *TinyLFUCache) admit(candidate *lru.Node) {
+	if cache.probation.Size+cache.protected.Size < cache.mainSize {
+		lru.InsertNode(cache.probation, candidate)
+		cache.lists[candidate.Key] = cache.probation
+		return
+	}
+
+	victimList := cache.probation
+	if victimList.Size == 0 {
+		victimList = cache.protected
+	}
+	victim, err := lru.GetLastNode(victimList)
+	if err != nil || cache.sketch.Estimate(candidate.Key) <= cache.sketch.Estimate(victim.Key) {
+		cache.Rejected++
+		cache.forget(candidate)
+		return
+	}
+
+	cache.Admitted++
+	cache.remove(victim)
+	lru.InsertNode(cache.probation, candidate)
+	cache.lists[candidate.Key] = cache.probation
+}
+
+// remove unlinks a key-value pair from its list and the
+// lookup tables. The caller must hold the cache lock.
+func (cache *TinyLFUCache) remove(node *lru.Node) {
+	lru.RemoveNode(cache.lists[node.Key], node)
+	cache.forget(node)
+}
+
+// replayOrder returns the lists whose key-value pairs are replayed,
+// the main segments before the window, each least recently used first.
+func (cache *TinyLFUCache) replayOrder() []*lru.List {
+	return []*lru.List{cache.probation, cache.protected, cache.window}
+}
+
+// forget drops an unlinked key-value pair from the lookup
+// tables. The caller must hold the cache lock.
+func (cache *TinyLFUCache) forget(node *lru.Node) {
+	delete(cache.Hashtable, node.Key)
+	delete(cache.lists, node.Key)
+	cache.Count--
+	cache.Full = false
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package tinylfu
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestTinyLfu(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.KeyspaceSize = 2
+	cache := NewTinyLFU(config)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", -1))
+
+	// Window: Dublin
+	// Probation: London
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v1.Gobj.Value, "")
+
+	// Dublin leaves the window but is used less often than
+	// London, so it is not admitted.
+	cache.Put(request.NewRequestFromValues("America", "Washington", -1))
+
+	v2 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v2.Message, "")
+	utils.AssertEqual(t, cache.Rejected, uint64(1), "")
+}
+
+func TestTinyLfuRejectsOneHitWonders(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	config.KeyspaceSize = 100
+	cache := NewTinyLFU(config)
+
+	// Build a working set that fills the main region
+	// and is read several times.
+	for i := 0; i < 100; i++ {
+		cache.Put(request.NewRequestFromValues(fmt.Sprintf("key-%d", i), i, -1))
+	}
+	for r := 0; r < 3; r++ {
+		for i := 0; i < 100; i++ {
+			cache.Get(request.NewRequestFromValues(fmt.Sprintf("key-%d", i), "", -1))
+		}
+	}
+
+	// Scan keys that are only ever written once.
+	for i := 0; i < 500; i++ {
+		cache.Put(request.NewRequestFromValues(fmt.Sprintf("scan-%d", i), i, -1))
+	}
+
+	hits := 0
+	for i := 0; i < 100; i++ {
+		if cache.Get(request.NewRequestFromValues(fmt.Sprintf("key-%d", i), "", -1)).Status == 1 {
+			hits++
+		}
+	}
+
+	// Only the working set key that was in the window
+	// when the scan started can have been pushed out.
+	utils.AssertEqual(t, hits >= 99, true, fmt.Sprintf("only %d working set keys survived the scan", hits))
+	utils.AssertEqual(t, cache.Count, int32(100), "")
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tlru
+
+import (
+	"math"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/store/response"
+)
+
+// evictionWindow is the number of least recently used key-value
+// pairs considered when choosing a victim, weighing how recently
+// each pair was used against how long it has left to live.
+const evictionWindow = 8
+
+// TLRUCache represents a time-aware least recently used cache object
+type TLRUCache struct {
+	lru.Keyed
+
+	// Size represents the maximum number of allowable
+	// key-value pairs in the cache.
+	Size int32
+
+	// Count records the number of key-value pairs
+	// currently in the cache.
+	Count int32
+
+	// Full tracks if Count is equal to Size
+	Full bool
+
+	// DLL is a doubly linked list containing all key-value pairs
+	DLL *lru.List `json:"-"`
+}
+
+// NewTLRU will initialize the cache
+func NewTLRU(config config.Configuration) *TLRUCache {
+	cache := &TLRUCache{
+		Size:  config.KeyspaceSize,
+		Count: int32(0),
+		Full:  false,
+		DLL:   lru.InitList(),
+	}
+	cache.InitKeyed(cache.remove, cache.replayOrder)
+	return cache
+}
+
+// Get will fetch a key/value pair from the cache
+func (cache *TLRUCache) Get(args request.CacheRequest) response.CacheResponse {
+	key := args.Gobj.Key
+
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	node, ok := cache.Live(key)
+	if !ok {
+		return response.NewCacheMissResponse()
+	}
+
+	lru.RemoveNode(cache.DLL, node)
+	lru.InsertNode(cache.DLL, node)
+
+	return response.NewResponseFromValue(node.Value)
+}
+
+// Put will add a key/value pair to the cache, possibly
+// overwriting an existing key/value pair. Put will evict
+// a key/value pair if the cache is full.
+func (cache *TLRUCache) Put(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Value = args.Gobj.Value
+		node.TTL = args.Gobj.TTL
+		node.Stamp(args)
+
+		lru.RemoveNode(cache.DLL, node)
+		lru.InsertNode(cache.DLL, node)
+		return response.NewResponseFromMessage(lru.STORED, 1)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL, lru.RequestTime(args))
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Add will add a key/value pair to the cache if the key
+// does not exist already.
+func (cache *TLRUCache) Add(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok && !cache.Expire(node, lru.RequestTime(args)) {
+		return response.NewResponseFromMessage(lru.NOT_STORED, 0)
+	}
+
+	cache.insert(args.Gobj.Key, args.Gobj.Value, args.Gobj.TTL, lru.RequestTime(args))
+	if node, ok := cache.Hashtable[args.Gobj.Key]; ok {
+		node.Stamp(args)
+	}
+	return response.NewResponseFromMessage(lru.STORED, 1)
+}
+
+// Flush removes all key/value pairs from the cache even if they have not expired
+func (cache *TLRUCache) Flush(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+
+	cache.DLL = lru.InitList()
+	cache.Hashtable = make(map[string]*lru.Node)
+	cache.Count = 0
+	cache.Full = false
+
+	return response.NewResponseFromMessage(lru.FLUSHED, 1)
+}
+
+// CountKeys return the number of keys in the cache
+func (cache *TLRUCache) CountKeys(args request.CacheRequest) response.CacheResponse {
+	cache.Mux.Lock()
+	defer cache.Mux.Unlock()
+	return response.NewResponseFromValue(cache.Count)
+}
+
+// insert adds a new key-value pair, evicting a victim first
+// if the cache is full. The caller must hold the cache lock.
+func (cache *TLRUCache) insert(key string, value interface{}, ttl int64, now int64) {
+	if cache.Count >= cache.Size {
+		if victim := cache.victim(now); victim != nil {
+			cache.remove(victim)
+		}
+	}
+
+	node, _ := lru.Insert(cache.DLL, key, value, ttl)
+	cache.Hashtable[key] = node
+	cache.Count++
+	cache.Full = cache.Count >= cache.Size
+}
+
+// victim picks the key-value pair to evict from the evictionWindow
+// least recently used pairs. Expired pairs are evicted first.
+// Otherwise each pair is scored by its time left to live, capped at
+// the longest TTL left in the window, times its position counting
+// from the least recently used pair. The lowest score is evicted,
+// so a pair about to expire goes before older pairs, while pairs
+// that never expire are still evicted once they are the least
+// recently used. Ties go to the less recently used pair, so with
+// no TTLs set the cache behaves as a plain LRU cache.
+func (cache *TLRUCache) victim(now int64) *lru.Node {
+	var window [evictionWindow]*lru.Node
+	var remaining [evictionWindow]int64
+	var horizon int64 = 1
+
+	n := 0
+	for node := cache.DLL.Tail.Prev; n < evictionWindow && node != cache.DLL.Head; node = node.Prev {
+		window[n] = node
+		remaining[n] = remainingTTL(node, now)
+		if remaining[n] != math.MaxInt64 && remaining[n] > horizon {
+			horizon = remaining[n]
+		}
+		n++
+	}
+
+	var victim *lru.Node
+	var victimScore int64
+	for i := 0; i < n; i++ {
+		left := remaining[i]
+		if left > horizon {
+			left = horizon
+		}
+		score := left * int64(i+1)
+		if left <= 0 {
+			// Expired pairs go before any pair still live
+			score = left - 1
+		}
+		if victim == nil || score < victimScore {
+			victim = window[i]
+			victimScore = score
+		}
+	}
+	return victim
+}
+
+// remainingTTL returns the number of seconds a key-value pair has
+// left to live. Pairs that never expire have the maximum remaining
+// time, expired pairs have a negative remaining time.
+func remainingTTL(node *lru.Node, now int64) int64 {
+	if node.TTL == -1 {
+		return math.MaxInt64
+	}
+	return node.CreatedAt + node.TTL - now
+}
+
+// remove unlinks a key-value pair from the list and the
+// hashtable. The caller must hold the cache lock.
+func (cache *TLRUCache) remove(node *lru.Node) {
+	lru.RemoveNode(cache.DLL, node)
+	delete(cache.Hashtable, node.Key)
+	cache.Count--
+	cache.Full = false
+}
+
+// replayOrder returns the list of the cache, whose key-value
+// pairs are replayed least recently used first.
+func (cache *TLRUCache) replayOrder() []*lru.List {
+	return []*lru.List{cache.DLL}
+}
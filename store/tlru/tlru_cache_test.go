@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ * 
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ * 
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ * 
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ * 
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package tlru
+
+import (
+	"testing"
+
+	"github.com/ghostdb/ghostdb-cache-node/config"
+	"github.com/ghostdb/ghostdb-cache-node/store/lru"
+	"github.com/ghostdb/ghostdb-cache-node/store/request"
+	"github.com/ghostdb/ghostdb-cache-node/utils"
+)
+
+func TestTlru(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewTLRU(config)
+	cache.Size = int32(3)
+
+	cache.Put(request.NewRequestFromValues("England", "London", 3600))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", 60))
+	cache.Put(request.NewRequestFromValues("Italy", "Rome", -1))
+
+	// HEAD -> Rome -> Dublin -> London
+
+	// London is the least recently used, but Dublin is closer
+	// to expiring so it is evicted first.
+	cache.Put(request.NewRequestFromValues("France", "Paris", -1)) // Ireland evicted here
+
+	v1 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+
+	v2 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v2.Gobj.Value, "")
+
+	// Overwrite London so that it never expires.
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+
+	// HEAD -> London -> Paris -> Rome
+
+	// With no TTLs to compare, the least recently used pair goes.
+	cache.Put(request.NewRequestFromValues("Spain", "Madrid", -1)) // Italy evicted here
+
+	v3 := cache.Get(request.NewRequestFromValues("Italy", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v3.Message, "")
+}
+
+func TestTlruEvictsExpiredFirst(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewTLRU(config)
+	cache.Size = int32(2)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", 60))
+
+	// Age Ireland so it has already expired.
+	cache.Hashtable["Ireland"].CreatedAt -= 120
+
+	cache.Put(request.NewRequestFromValues("France", "Paris", -1)) // Ireland evicted here
+
+	v1 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+
+	v2 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, "London", v2.Gobj.Value, "")
+}
+
+func TestTlruEvictsPairsWithoutTTL(t *testing.T) {
+	var config config.Configuration = config.InitializeConfiguration()
+	cache := NewTLRU(config)
+	cache.Size = int32(3)
+
+	cache.Put(request.NewRequestFromValues("England", "London", -1))
+	cache.Put(request.NewRequestFromValues("Ireland", "Dublin", 3600))
+	cache.Put(request.NewRequestFromValues("France", "Paris", 3600))
+
+	// England never expires, but is the least recently used pair.
+	cache.Put(request.NewRequestFromValues("Italy", "Rome", 3600)) // England evicted here
+
+	v1 := cache.Get(request.NewRequestFromValues("England", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v1.Message, "")
+
+	// A pair close to expiring goes before an older pair.
+	cache.Hashtable["Italy"].CreatedAt -= 3590
+
+	cache.Put(request.NewRequestFromValues("Spain", "Madrid", -1)) // Italy evicted here
+
+	v2 := cache.Get(request.NewRequestFromValues("Italy", "", -1))
+	utils.AssertEqual(t, lru.CACHE_MISS, v2.Message, "")
+
+	v3 := cache.Get(request.NewRequestFromValues("Ireland", "", -1))
+	utils.AssertEqual(t, "Dublin", v3.Gobj.Value, "")
+}